@@ -0,0 +1,95 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"modernc.org/sqlite"
+)
+
+// backupStepPages is the number of pages copied by each step of a backup.
+const backupStepPages = 256
+
+// backuper is the interface to a driver connection that supports the SQLite
+// online backup API.
+type backuper interface {
+	NewBackup(dstURI string) (*sqlite.Backup, error)
+}
+
+// Backup copies the contents of the database to a new database at destPath,
+// using the SQLite online backup API. Pages are copied in small steps, and
+// writers are not blocked between steps, so the store remains usable while
+// the backup is in progress.
+//
+// If progress != nil, it is called after each step with the number of pages
+// copied so far and the total number of pages in the database.
+//
+// Note that if the database is modified during the backup, SQLite restarts the
+// copy from the beginning at the next step, so a backup of a store that is
+// continuously written may take much longer than one of an idle store.
+// Backup requires the default driver, and reports [errors.ErrUnsupported]
+// with other drivers.
+func (s Store) Backup(ctx context.Context, destPath string, progress func(done, total int)) (err error) {
+	ctx, op := s.begin(ctx, "backup", "")
+	defer op.end(&err)
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	defer conn.Close()
+
+	var bk *sqlite.Backup
+	if err := conn.Raw(func(dc any) error {
+		b, ok := dc.(backuper)
+		if !ok {
+			return errors.ErrUnsupported
+		}
+		bk, err = b.NewBackup(destPath)
+		return err
+	}); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+
+	// Copy pages until the backup is complete, releasing the lock between
+	// steps so that writers are not starved.
+	for done := 0; ; done += backupStepPages {
+		if err := ctx.Err(); err != nil {
+			return errors.Join(err, bk.Finish())
+		}
+		more, total, err := s.backupStep(ctx, conn, bk)
+		if err != nil {
+			return fmt.Errorf("backup: %w", errors.Join(err, bk.Finish()))
+		}
+		if progress != nil {
+			progress(min(done+backupStepPages, total), total)
+		}
+		if !more {
+			break
+		}
+	}
+	if err := bk.Finish(); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	return nil
+}
+
+// backupStep copies the next batch of pages for bk, and reports whether more
+// pages remain, along with the total number of pages in the source.
+func (s Store) backupStep(ctx context.Context, conn *sql.Conn, bk *sqlite.Backup) (more bool, total int, err error) {
+	s.txmu.RLock()
+	defer s.txmu.RUnlock()
+
+	if err := conn.QueryRowContext(ctx, `pragma page_count`).Scan(&total); err != nil {
+		return false, 0, err
+	}
+	err = conn.Raw(func(any) error {
+		more, err = bk.Step(backupStepPages)
+		return err
+	})
+	return more, total, err
+}
@@ -0,0 +1,172 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/creachadair/ffs/blob"
+)
+
+// BatchGet returns the values of the specified keys, as a map from key to
+// value. Keys that are not present in s are omitted from the result.
+//
+// The keys are divided among as many concurrent transactions as the
+// connection pool allows (see [Options.PoolSize]), so that a large set of
+// reads is not serialized on a single connection. Because the transactions
+// are separate, the result is not a consistent view of s if it is modified
+// concurrently; use [KV.Snapshot] for that. If any read fails, BatchGet
+// stops the others and reports the first error.
+//
+// If access times are updated on Get (see [Options.TouchOnGet]), the reads
+// are performed in a single transaction.
+func (s KV) BatchGet(ctx context.Context, keys ...string) (_ map[string][]byte, err error) {
+	ctx, op := s.db.begin(ctx, "batchget", s.tableName)
+	defer op.end(&err)
+
+	touch := s.db.touch && s.db.evicting()
+	if touch {
+		s.db.txmu.Lock()
+		defer s.db.txmu.Unlock()
+	} else {
+		s.db.txmu.RLock()
+		defer s.db.txmu.RUnlock()
+	}
+	if _, err := s.prepare(ctx, s.getQuery()); err != nil {
+		return nil, fmt.Errorf("batchget: %w", err)
+	}
+
+	n := min(len(keys), s.db.db.Stats().MaxOpenConnections)
+	if n <= 0 || touch {
+		n = min(len(keys), 1) // unlimited pool, or writes to serialize
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Each worker reads a contiguous range of keys, and records their values
+	// at the corresponding positions, so the workers do not share state.
+	vals := make([][]byte, len(keys))
+	found := make([]bool, len(keys))
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	for i := range n {
+		lo, hi := i*len(keys)/n, (i+1)*len(keys)/n
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := withTxErr(ctx, s.db.sqlDB, func(tx *sql.Tx) error {
+				clear(found[lo:hi]) // reset in case of retry
+				for j := lo; j < hi; j++ {
+					data, err := s.getTx(ctx, tx, keys[j])
+					if blob.IsKeyNotFound(err) {
+						continue
+					} else if err != nil {
+						return err
+					}
+					if touch {
+						if err := s.touchTx(ctx, tx, keys[j]); err != nil {
+							return err
+						}
+					}
+					vals[j], found[j] = data, true
+				}
+				return nil
+			}); err != nil {
+				errOnce.Do(func() { firstErr = err; cancel() })
+			}
+		}()
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, fmt.Errorf("batchget: %w", firstErr)
+	}
+
+	out := make(map[string][]byte)
+	var size int
+	for i, key := range keys {
+		if found[i] {
+			out[key] = vals[i]
+			size += len(vals[i])
+		}
+	}
+	op.setSize(size)
+	return out, nil
+}
+
+// BatchDelete deletes the specified keys from s in a single transaction, and
+// reports the number of keys deleted. Keys that are not present in s are not
+// counted, and are not an error.
+func (s KV) BatchDelete(ctx context.Context, keys ...string) (_ int, err error) {
+	ctx, op := s.db.begin(ctx, "batchdelete", s.tableName)
+	defer op.end(&err)
+	var cs []change
+	defer s.db.changed(&cs, &err) // after the lock is released
+
+	s.db.txmu.Lock()
+	defer s.db.txmu.Unlock()
+
+	var deleted int64 // rows deleted, including expired ones
+	var live int      // rows deleted that had not expired
+	if err := withTxErr(ctx, s.db.sqlDB, func(tx *sql.Tx) error {
+		deleted, live, cs = 0, 0, cs[:0] // reset in case of retry
+		now := nowArg()
+		for chunk := range slices.Chunk(keys, statChunkSize) {
+			params := make([]string, len(chunk))
+			args := make([]any, len(chunk), len(chunk)+1)
+			for i, key := range chunk {
+				params[i] = fmt.Sprintf("$k%d", i)
+				args[i] = sql.Named(fmt.Sprintf("k%d", i), s.encodeKey(key))
+			}
+			rows, err := tx.QueryContext(ctx, fmt.Sprintf(`delete from %[1]s where key in (%[2]s) returning key, ref, %[3]s`,
+				s.table(), strings.Join(params, ", "), liveRow(s.table())), append(args, now)...)
+			if err != nil {
+				return err
+			}
+
+			// Release content references after the deletion is complete, since
+			// the transaction cannot be used while rows are open.
+			var refs [][]byte
+			for rows.Next() {
+				var ekey any
+				var ref []byte
+				var ok bool
+				if err := rows.Scan(&ekey, &ref, &ok); err != nil {
+					rows.Close()
+					return err
+				}
+				deleted++
+				if ok {
+					key, err := s.decodeKey(storedBytes(ekey))
+					if err != nil {
+						rows.Close()
+						return err
+					}
+					live++
+					cs = append(cs, change{"delete", key})
+				}
+				if ref != nil {
+					refs = append(refs, ref)
+				}
+			}
+			if err := rows.Close(); err != nil {
+				return err
+			}
+			for _, ref := range refs {
+				if err := s.db.release(ctx, tx, ref); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("batchdelete: %w", err)
+	}
+	s.db.addLen(s.tableName, -deleted)
+	return live, nil
+}
@@ -0,0 +1,297 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/creachadair/ffs/blob"
+)
+
+// BufferOptions are options for [KV.Buffered]. A nil *BufferOptions is ready
+// for use and provides default values as described.
+type BufferOptions struct {
+	// The maximum number of distinct keys to buffer before writing them to
+	// the store. If <= 0, use 100.
+	MaxWrites int
+
+	// The maximum time a write may remain buffered before it is written to
+	// the store. If <= 0, use 10ms.
+	Interval time.Duration
+}
+
+func (o *BufferOptions) maxWrites() int {
+	if o == nil || o.MaxWrites <= 0 {
+		return 100
+	}
+	return o.MaxWrites
+}
+
+func (o *BufferOptions) interval() time.Duration {
+	if o == nil || o.Interval <= 0 {
+		return 10 * time.Millisecond
+	}
+	return o.Interval
+}
+
+// A BufferedKV implements the [blob.KV] interface over a [KV], buffering
+// writes in memory and committing them to the store in batches. This is much
+// faster than writing each value in its own transaction, when there are many
+// small writes. Use [KV.Buffered] to construct a BufferedKV.
+//
+// Get and Stat observe buffered writes. Delete, List, and Len first write
+// any buffered values to the store. Changes made to the underlying KV
+// directly are not coordinated with the buffer, so the caller should Flush
+// before using it.
+//
+// Buffered values are not durable until they are written. [Store.Flush]
+// flushes all the open BufferedKV values of a store, and [Store.Close]
+// closes them before it closes the database. An error writing buffered
+// values in the background is reported by the next call to Flush or Close.
+type BufferedKV struct {
+	kv        KV
+	maxWrites int
+
+	mu      sync.Mutex
+	pending map[string][]byte // key → value, not yet written
+	err     error             // error from a background flush, if any
+
+	stop chan struct{} // closed to request the flusher to exit
+	done chan struct{} // closed by the flusher when it exits
+	once sync.Once
+}
+
+// Buffered returns a [BufferedKV] that buffers writes to s.  The caller must
+// close the BufferedKV when it is no longer in use, to write any remaining
+// values to the store and stop its background flusher.
+func (s KV) Buffered(opts *BufferOptions) *BufferedKV {
+	b := &BufferedKV{
+		kv:        s,
+		maxWrites: opts.maxWrites(),
+		pending:   make(map[string][]byte),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	s.db.bufmu.Lock()
+	s.db.buffers[b] = struct{}{}
+	s.db.bufmu.Unlock()
+	go b.flusher(opts.interval())
+	return b
+}
+
+// flusher writes buffered values to the store every interval, until b is
+// closed.
+func (b *BufferedKV) flusher(interval time.Duration) {
+	defer close(b.done)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-t.C:
+			b.mu.Lock()
+			if err := b.flushLocked(context.Background()); err != nil && b.err == nil {
+				b.err = err
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+// flushLocked writes all the buffered values to the store in a single
+// transaction. If this fails, the values remain buffered.
+// The caller must hold b.mu.
+func (b *BufferedKV) flushLocked(ctx context.Context) error {
+	if len(b.pending) == 0 {
+		return nil
+	}
+	puts := make([]blob.PutOptions, 0, len(b.pending))
+	for _, key := range slices.Sorted(maps.Keys(b.pending)) {
+		puts = append(puts, blob.PutOptions{Key: key, Data: b.pending[key], Replace: true})
+	}
+	if err := b.kv.BatchPut(ctx, puts); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+	clear(b.pending)
+	return nil
+}
+
+// Flush writes all buffered values to the store. It also reports any error
+// from an earlier background flush.
+func (b *BufferedKV) Flush(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	err := b.err
+	b.err = nil
+	return errors.Join(err, b.flushLocked(ctx))
+}
+
+// Close implements the [blob.Closer] interface. It stops the background
+// flusher and writes any buffered values to the store. It does not close the
+// underlying KV.
+func (b *BufferedKV) Close(ctx context.Context) error {
+	b.once.Do(func() { close(b.stop) })
+	<-b.done
+	if err := b.Flush(ctx); err != nil {
+		return err
+	}
+	b.kv.db.bufmu.Lock()
+	delete(b.kv.db.buffers, b)
+	b.kv.db.bufmu.Unlock()
+	return nil
+}
+
+// Flush writes the buffered values of all the open [BufferedKV] values of
+// the database to the store, then runs a passive checkpoint of the
+// write-ahead log, if there is one. Flush can be used in this way to force a
+// checkpoint, even if nothing is buffered. Flush does not wait for buffers
+// opened or writes buffered after it is called.
+//
+// When Flush returns without error, all the values buffered before the call
+// have been committed. A committed write survives a crash of the process,
+// and it survives a power failure if the database uses synchronous=FULL (the
+// default) or EXTRA. With synchronous=NORMAL in WAL mode, recent commits may
+// be lost on power failure until they are checkpointed; the checkpoint makes
+// the writes it copies into the database durable, but since a passive
+// checkpoint does not wait for readers, it may not copy the whole log.
+func (s Store) Flush(ctx context.Context) (err error) {
+	ctx, op := s.begin(ctx, "flush", "")
+	defer op.end(&err)
+
+	s.bufmu.Lock()
+	bufs := slices.Collect(maps.Keys(s.buffers))
+	s.bufmu.Unlock()
+
+	var errs []error
+	for _, b := range bufs {
+		errs = append(errs, b.Flush(ctx))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	s.txmu.Lock()
+	defer s.txmu.Unlock()
+	if _, err := s.db.ExecContext(ctx, `pragma wal_checkpoint(PASSIVE)`); err != nil {
+		return fmt.Errorf("flush: %w", err)
+	}
+	return nil
+}
+
+// closeBuffers closes all the open [BufferedKV] values of d, writing their
+// buffered values to the store. They are no longer tracked by d afterward,
+// even if writing fails.
+func (d *sqlDB) closeBuffers(ctx context.Context) error {
+	d.bufmu.Lock()
+	bufs := slices.Collect(maps.Keys(d.buffers))
+	clear(d.buffers)
+	d.bufmu.Unlock()
+
+	var errs []error
+	for _, b := range bufs {
+		errs = append(errs, b.Close(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// Get implements part of [blob.KV].
+func (b *BufferedKV) Get(ctx context.Context, key string) ([]byte, error) {
+	b.mu.Lock()
+	data, ok := b.pending[key]
+	b.mu.Unlock()
+	if ok {
+		return bytes.Clone(data), nil
+	}
+	return b.kv.Get(ctx, key)
+}
+
+// Stat implements part of [blob.KV].
+func (b *BufferedKV) Stat(ctx context.Context, keys ...string) (blob.StatMap, error) {
+	out := make(blob.StatMap)
+	var rest []string
+	b.mu.Lock()
+	for _, key := range keys {
+		if data, ok := b.pending[key]; ok {
+			out[key] = blob.Stat{Size: int64(len(data))}
+		} else {
+			rest = append(rest, key)
+		}
+	}
+	b.mu.Unlock()
+	if len(rest) != 0 {
+		st, err := b.kv.Stat(ctx, rest...)
+		if err != nil {
+			return nil, err
+		}
+		maps.Copy(out, st)
+	}
+	return out, nil
+}
+
+// Put implements part of [blob.KV]. If opts.Replace is false, Put checks
+// whether the key exists when it is called, not when the value is written.
+func (b *BufferedKV) Put(ctx context.Context, opts blob.PutOptions) error {
+	if err := b.kv.checkKey(opts.Key); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !opts.Replace {
+		if _, ok := b.pending[opts.Key]; ok {
+			return blob.KeyExists(opts.Key)
+		}
+		st, err := b.kv.Stat(ctx, opts.Key)
+		if err != nil {
+			return err
+		} else if st.Has(opts.Key) {
+			return blob.KeyExists(opts.Key)
+		}
+	}
+	b.pending[opts.Key] = bytes.Clone(opts.Data)
+	if len(b.pending) >= b.maxWrites {
+		return b.flushLocked(ctx)
+	}
+	return nil
+}
+
+// Delete implements part of [blob.KV].
+func (b *BufferedKV) Delete(ctx context.Context, key string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err := b.flushLocked(ctx); err != nil {
+		return err
+	}
+	return b.kv.Delete(ctx, key)
+}
+
+// List implements part of [blob.KV].
+func (b *BufferedKV) List(ctx context.Context, start string, f func(string) error) error {
+	if err := b.flushOnly(ctx); err != nil {
+		return err
+	}
+	return b.kv.List(ctx, start, f)
+}
+
+// Len implements part of [blob.KV].
+func (b *BufferedKV) Len(ctx context.Context) (int64, error) {
+	if err := b.flushOnly(ctx); err != nil {
+		return 0, err
+	}
+	return b.kv.Len(ctx)
+}
+
+// flushOnly writes the buffered values to the store, without reporting an
+// error from an earlier background flush.
+func (b *BufferedKV) flushOnly(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.flushLocked(ctx)
+}
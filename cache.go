@@ -0,0 +1,98 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"bytes"
+	"context"
+	"sync"
+
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/mds/cache"
+)
+
+// A CachedKV implements the [blob.KV] interface over another KV, keeping
+// recently-read values in an in-memory LRU cache, so that repeated reads of
+// the same keys do not touch the underlying store. Use [WithCache] to
+// construct a CachedKV.
+//
+// Put and Delete write through to the underlying KV, and remove the affected
+// key from the cache. Changes made to the underlying KV directly are not
+// coordinated with the cache, so a CachedKV should be the only writer of the
+// KV it wraps.
+type CachedKV struct {
+	kv    blob.KV
+	cache *cache.Cache[string, []byte]
+
+	// Each write increments gen, so that a read that races with a write does
+	// not cache a value the write has replaced.
+	mu  sync.Mutex
+	gen uint64
+}
+
+// WithCache returns a [CachedKV] that caches up to maxBytes bytes of values
+// read from kv, discarding the least-recently used values first.
+func WithCache(kv blob.KV, maxBytes int64) *CachedKV {
+	return &CachedKV{
+		kv:    kv,
+		cache: cache.New(maxBytes, cache.LRU[string, []byte]().WithSize(cache.Length)),
+	}
+}
+
+// Get implements part of [blob.KV].
+func (c *CachedKV) Get(ctx context.Context, key string) ([]byte, error) {
+	if data, ok := c.cache.Get(key); ok {
+		return bytes.Clone(data), nil
+	}
+	gen := c.generation()
+	data, err := c.kv.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen == gen {
+		c.cache.Put(key, bytes.Clone(data))
+	}
+	return data, nil
+}
+
+// Stat implements part of [blob.KV].
+func (c *CachedKV) Stat(ctx context.Context, keys ...string) (blob.StatMap, error) {
+	return c.kv.Stat(ctx, keys...)
+}
+
+// Put implements part of [blob.KV].
+func (c *CachedKV) Put(ctx context.Context, opts blob.PutOptions) error {
+	defer c.invalidate(opts.Key)
+	return c.kv.Put(ctx, opts)
+}
+
+// Delete implements part of [blob.KV].
+func (c *CachedKV) Delete(ctx context.Context, key string) error {
+	defer c.invalidate(key)
+	return c.kv.Delete(ctx, key)
+}
+
+// List implements part of [blob.KV].
+func (c *CachedKV) List(ctx context.Context, start string, f func(string) error) error {
+	return c.kv.List(ctx, start, f)
+}
+
+// Len implements part of [blob.KV].
+func (c *CachedKV) Len(ctx context.Context) (int64, error) { return c.kv.Len(ctx) }
+
+// generation returns the current write generation of c.
+func (c *CachedKV) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+// invalidate removes key from the cache, and starts a new write generation.
+func (c *CachedKV) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.cache.Remove(key)
+}
@@ -0,0 +1,245 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+// A Codec encodes values before they are written to the database, and
+// decodes them when they are read back. A Codec may, for example, compress
+// or encrypt values. Its methods must be safe for concurrent use by multiple
+// goroutines.
+type Codec interface {
+	// Encode returns the encoding of data. It must not modify data.
+	Encode(data []byte) ([]byte, error)
+
+	// Decode returns the data encoded by enc. An error from Decode is
+	// reported as [ErrCorruptValue].
+	Decode(enc []byte) ([]byte, error)
+}
+
+var (
+	// SnappyCodec compresses values with Snappy. This is the default.
+	SnappyCodec Codec = snappyCodec{}
+
+	// NoCodec stores values without encoding.
+	NoCodec Codec = noCodec{}
+
+	// GzipCodec stores each value as a standard gzip stream (RFC 1952), which
+	// other tools can read without this package. Gzip usually compresses
+	// better than Snappy, but costs several times more CPU to encode and
+	// decode, so it is best suited to values that are read rarely or must be
+	// readable elsewhere.
+	GzipCodec Codec = gzipCodec{}
+)
+
+// Codec IDs identify the codec that encoded a stored value. Each value is
+// stored with the ID of its codec, so that the values of a table need not
+// all be encoded the same way (see [KV.Recompress]).
+const (
+	// CodecStore identifies the codec of the store (see [Options]), when it
+	// is not one of the built-in codecs; for example, an encrypting codec.
+	CodecStore byte = iota
+
+	CodecNone   // [NoCodec]
+	CodecSnappy // [SnappyCodec]
+	CodecGzip   // [GzipCodec]
+)
+
+// rowCodec is an SQL expression for the codec ID of a row of a KV table with
+// alias t. A row without one, for example because it was written by another
+// program, is taken to be encoded by the codec of the store.
+const rowCodec = `coalesce(t.codec, 0)` // CodecStore
+
+// codecNames are the names of the built-in codecs, indexed by ID.
+var codecNames = [...]string{CodecNone: "none", CodecSnappy: "snappy", CodecGzip: "gzip"}
+
+// builtinCodecID returns the ID of c, and reports whether c is one of the
+// built-in codecs.
+func builtinCodecID(c Codec) (byte, bool) {
+	switch c.(type) {
+	case noCodec:
+		return CodecNone, true
+	case snappyCodec:
+		return CodecSnappy, true
+	case gzipCodec:
+		return CodecGzip, true
+	}
+	return CodecStore, false
+}
+
+// decodedLen returns the length of the value encoded as enc by the built-in
+// codec with the given ID, and reports whether the codec records the length
+// so that it can be found without decoding the value.
+func decodedLen(codec byte, enc []byte) (int, bool) {
+	switch codec {
+	case CodecNone:
+		return len(enc), true
+	case CodecSnappy:
+		n, err := snappy.DecodedLen(enc)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+// codecByID returns the codec identified by id, or nil if there is none.
+func (d *sqlDB) codecByID(id byte) Codec {
+	switch id {
+	case CodecStore:
+		return d.codec
+	case CodecNone:
+		return NoCodec
+	case CodecSnappy:
+		return SnappyCodec
+	case CodecGzip:
+		return GzipCodec
+	}
+	return nil
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) Encode(data []byte) ([]byte, error) { return snappy.Encode(nil, data), nil }
+func (snappyCodec) Decode(enc []byte) ([]byte, error)  { return snappy.Decode(nil, enc) }
+
+// encodeTo encodes data into the storage of *buf, and updates *buf to retain
+// the storage used, which may be new if *buf was too small.
+func (snappyCodec) encodeTo(buf *[]byte, data []byte) []byte {
+	// Encode writes into dst only if it is long enough for any encoding.
+	enc := snappy.Encode((*buf)[:cap(*buf)], data)
+	*buf = enc[:0]
+	return enc
+}
+
+// maxPooledBuf is the largest encoding buffer retained for reuse, so that
+// an occasional large value does not pin its storage indefinitely.
+const maxPooledBuf = 1 << 20
+
+// getBuf returns an empty buffer for encoding values from the pool of d.
+// The caller should return it with putBuf when its contents are unused.
+func (d *sqlDB) getBuf() *[]byte {
+	if buf, ok := d.encbufs.Get().(*[]byte); ok {
+		return buf
+	}
+	return new([]byte)
+}
+
+// putBuf returns buf to the pool of d, unless it is too large to retain.
+func (d *sqlDB) putBuf(buf *[]byte) {
+	if cap(*buf) <= maxPooledBuf {
+		*buf = (*buf)[:0]
+		d.encbufs.Put(buf)
+	}
+}
+
+type gzipCodec struct{}
+
+// gzipWriters holds idle gzip writers for reuse, since each holds a large
+// compression state.
+var gzipWriters sync.Pool
+
+func (gzipCodec) Encode(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, ok := gzipWriters.Get().(*gzip.Writer)
+	if ok {
+		w.Reset(&buf)
+	} else {
+		w = gzip.NewWriter(&buf)
+	}
+	defer gzipWriters.Put(w)
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	} else if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// gzipMagic is the header that begins every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func (gzipCodec) Decode(enc []byte) ([]byte, error) {
+	if !bytes.HasPrefix(enc, gzipMagic) {
+		return nil, errors.New("value is not a gzip stream")
+	}
+	r, err := gzip.NewReader(bytes.NewReader(enc))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+type noCodec struct{}
+
+func (noCodec) Encode(data []byte) ([]byte, error) { return data, nil }
+func (noCodec) Decode(enc []byte) ([]byte, error)  { return enc, nil }
+
+// NewAESCodec returns a [Codec] that encodes values with base (or
+// [NoCodec] if base == nil), and then encrypts them with AES-GCM using the
+// given key, which must be 16, 24, or 32 bytes long. Each value is encrypted
+// with a fresh random nonce, which is stored with the ciphertext.
+//
+// Encryption protects the contents of values at rest. It does not protect:
+//
+//   - The keys of the store, which are stored according to the key encoding.
+//   - The sizes of values, which are stored in plaintext.
+//   - Which values are equal, if Dedup or Verify is enabled, since these
+//     store a hash or checksum of the plaintext.
+//
+// Values are authenticated, so that modification of a stored value is
+// reported as [ErrCorruptValue]. However, the ciphertext is not bound to
+// its key, so an attacker with write access to the database can exchange
+// the values of two keys undetected.
+func NewAESCodec(key []byte, base Codec) (Codec, error) {
+	c, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(c)
+	if err != nil {
+		return nil, err
+	}
+	if base == nil {
+		base = NoCodec
+	}
+	return aesCodec{base: base, aead: gcm}, nil
+}
+
+type aesCodec struct {
+	base Codec
+	aead cipher.AEAD
+}
+
+func (c aesCodec) Encode(data []byte) ([]byte, error) {
+	enc, err := c.base.Encode(data)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(enc)+c.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, enc, nil), nil
+}
+
+func (c aesCodec) Decode(enc []byte) ([]byte, error) {
+	ns := c.aead.NonceSize()
+	if len(enc) < ns {
+		return nil, errors.New("encrypted value is too short")
+	}
+	dec, err := c.aead.Open(nil, enc[:ns], enc[ns:], nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.base.Decode(dec)
+}
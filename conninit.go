@@ -0,0 +1,106 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+)
+
+// openDB opens a database handle for uri with the specified driver. If init
+// is non-empty, each of its statements is executed on every new connection
+// before the connection is used. If keepWAL is true, every new connection is
+// marked to keep the write-ahead log when it is closed (see persistWAL).
+func openDB(driverName, uri string, init []string, keepWAL bool) (*sql.DB, error) {
+	db, err := sql.Open(driverName, uri)
+	if err != nil || (len(init) == 0 && !keepWAL) {
+		return db, err
+	}
+	drv := db.Driver()
+	db.Close() // we only needed the driver
+
+	c := &initConnector{drv: drv, name: uri, init: init, keepWAL: keepWAL}
+	if dc, ok := drv.(driver.DriverContext); ok {
+		c.base, err = dc.OpenConnector(uri)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sql.OpenDB(c), nil
+}
+
+// initConnector is a [driver.Connector] that executes initialization
+// statements on each connection it opens.
+type initConnector struct {
+	drv     driver.Driver
+	base    driver.Connector // if nil, use drv.Open(name)
+	name    string
+	init    []string
+	keepWAL bool // mark each connection with persistWAL
+}
+
+func (c *initConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	var conn driver.Conn
+	var err error
+	if c.base != nil {
+		conn, err = c.base.Connect(ctx)
+	} else {
+		conn, err = c.drv.Open(c.name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range c.init {
+		if err := execConn(ctx, conn, stmt); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("initialize connection: %w", err)
+		}
+	}
+	if c.keepWAL {
+		if err := persistWAL(conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("initialize connection: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+func (c *initConnector) Driver() driver.Driver { return c.drv }
+
+// persistWAL sets the SQLITE_FCNTL_PERSIST_WAL hint on conn, so that when it
+// is the last connection to the database to be closed, SQLite leaves the
+// write-ahead log and its frames on disk rather than truncating and deleting
+// it. SQLite still checkpoints the log into the database at that point, since
+// the driver does not expose SQLITE_DBCONFIG_NO_CKPT_ON_CLOSE.
+func persistWAL(conn driver.Conn) error {
+	fc, ok := conn.(interface {
+		FileControlPersistWAL(schema string, mode int) (int, error)
+	})
+	if !ok {
+		return fmt.Errorf("keep write-ahead log: %w", errors.ErrUnsupported)
+	}
+	if _, err := fc.FileControlPersistWAL("main", 1); err != nil {
+		return fmt.Errorf("keep write-ahead log: %w", err)
+	}
+	return nil
+}
+
+// execConn executes stmt, which takes no arguments, on conn.
+func execConn(ctx context.Context, conn driver.Conn, stmt string) error {
+	if ec, ok := conn.(driver.ExecerContext); ok {
+		_, err := ec.ExecContext(ctx, stmt, nil)
+		if err != driver.ErrSkip {
+			return err
+		}
+	}
+	st, err := conn.Prepare(stmt)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+	_, err = st.Exec(nil)
+	return err
+}
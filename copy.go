@@ -0,0 +1,144 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/creachadair/ffs/blob"
+)
+
+// CopyOptions are options for [KV.CopyTo]. A nil *CopyOptions is ready for
+// use and provides default values as described.
+type CopyOptions struct {
+	// The number of key-value pairs to read from the source and write to the
+	// destination at a time. If <= 0, use 100.
+	BatchSize int
+
+	// If true, replace the values of keys already present in the destination.
+	// Otherwise, such keys are skipped and their values are not copied.
+	Replace bool
+
+	// If set, Progress is called after each batch is written. Keys already
+	// present in the destination and not replaced are reported as skipped.
+	// Progress is called without holding any lock on the source.
+	Progress func(Progress)
+}
+
+func (o *CopyOptions) batchSize() int {
+	if o == nil || o.BatchSize <= 0 {
+		return 100
+	}
+	return o.BatchSize
+}
+
+func (o *CopyOptions) replace() bool { return o != nil && o.Replace }
+
+func (o *CopyOptions) wantProgress() bool { return o != nil && o.Progress != nil }
+
+// batchPutter is the interface to a [blob.KV] that supports writing multiple
+// values at once, as [KV.BatchPut] does.
+type batchPutter interface {
+	BatchPut(context.Context, []blob.PutOptions) error
+}
+
+// errBatchFull is a sentinel used by CopyTo to end a scan early.
+var errBatchFull = errors.New("batch full")
+
+// CopyTo copies all the key-value pairs in s to dst, in key order.
+//
+// CopyTo reads s in batches, so that only one batch of values is held in
+// memory at a time, and writes of the source store are blocked only while a
+// batch is being read. If dst has a BatchPut method with the same signature
+// as [KV.BatchPut], each batch is written with a single call; otherwise each
+// value is written with a separate call to Put.
+//
+// If the copy fails partway, keys copied before the failure remain in dst.
+func (s KV) CopyTo(ctx context.Context, dst blob.KV, opts *CopyOptions) error {
+	var prog Progress
+	if opts.wantProgress() {
+		n, err := s.Len(ctx)
+		if err != nil {
+			return fmt.Errorf("copy: %w", err)
+		}
+		prog.Total = n
+	}
+	var batch []blob.PutOptions
+	rel, from := ">=", s.encodeStart("")
+	for {
+		// Read the next batch of values from the source.
+		batch = batch[:0]
+		last, err := s.scanBatch(ctx, rel, from, func(key string, data []byte) error {
+			batch = append(batch, blob.PutOptions{Key: key, Data: data, Replace: opts.replace()})
+			if len(batch) >= opts.batchSize() {
+				return errBatchFull
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("copy: %w", err)
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		// Resume after the stored key, since a key codec need not preserve
+		// the order of keys.
+		rel, from = ">", last
+
+		// Unless we are replacing, skip keys already present in dst.
+		nb := len(batch)
+		if !opts.replace() {
+			keys := make([]string, len(batch))
+			for i, p := range batch {
+				keys[i] = p.Key
+			}
+			have, err := dst.Stat(ctx, keys...)
+			if err != nil {
+				return fmt.Errorf("copy: %w", err)
+			}
+			batch = slices.DeleteFunc(batch, func(p blob.PutOptions) bool { return have.Has(p.Key) })
+		}
+		prog.Skipped += int64(nb - len(batch))
+
+		// Write the batch to the destination.
+		if bp, ok := dst.(batchPutter); ok {
+			if err := bp.BatchPut(ctx, batch); err != nil {
+				return fmt.Errorf("copy: %w", err)
+			}
+		} else {
+			for _, p := range batch {
+				if err := dst.Put(ctx, p); err != nil {
+					return fmt.Errorf("copy: %w", err)
+				}
+			}
+		}
+		prog.Processed += int64(nb)
+		if opts.wantProgress() {
+			opts.Progress(prog)
+		}
+	}
+}
+
+// scanBatch calls f with each key and decoded value in s, in order, starting
+// from the first key whose stored representation compares to from by rel, in
+// a read transaction, and reports the stored representation of the last key
+// passed to f. If f reports errBatchFull, the scan stops without error.
+func (s KV) scanBatch(ctx context.Context, rel string, from any, f func(key string, data []byte) error) (last any, _ error) {
+	s.db.txmu.RLock()
+	defer s.db.txmu.RUnlock()
+
+	// Since f has side-effects, do not retry the transaction.
+	err := runTx(ctx, s.db.sqlDB, func(tx *sql.Tx) error {
+		var err error
+		last, err = s.scanFromTx(ctx, tx, rel, from, f)
+		return err
+	})
+	if errors.Is(err, errBatchFull) {
+		return last, nil
+	}
+	return last, err
+}
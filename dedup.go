@@ -0,0 +1,94 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+)
+
+// When deduplication is enabled, each distinct value is stored once in a
+// content table shared by all the keyspaces of the store, keyed by the
+// SHA-256 digest of the unencoded value and carrying a count of the rows that
+// refer to it.  A row whose value is stored in the content table has an empty
+// value column, and its ref column holds the digest.  Rows written without
+// deduplication have a NULL ref, and their value is stored inline.
+//
+// Large values are also stored in the content table when an overflow database
+// is configured, in which case the content table is in the overflow database.
+
+// contentTable returns the name of the content table for d.
+func (d *sqlDB) contentTable() string {
+	if d.table != "" {
+		return d.table + "_content"
+	}
+	return "blob_content"
+}
+
+// contentIdent returns the quoted name of the content table for d, qualified
+// by the name of its database if that is not the main database.
+func (d *sqlDB) contentIdent() string {
+	if d.overflow > 0 {
+		return "overflow." + quoteIdent(d.contentTable())
+	}
+	return quoteIdent(d.contentTable())
+}
+
+// initContent creates the content table if it does not exist.
+func (d *sqlDB) initContent(ctx context.Context, tx *sql.Tx) error {
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`create table if not exists %s (
+  hash BLOB primary key,
+  value BLOB not null,
+  refs INTEGER not null
+) without rowid`, d.contentIdent()))
+	return err
+}
+
+// retain records a reference to the content with the given hash (see
+// contentHash), whose encoded form is enc, in the content table within tx,
+// and returns the content reference.
+func (d *sqlDB) retain(ctx context.Context, tx *sql.Tx, hash, enc []byte) ([]byte, error) {
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`insert into %s (hash, value, refs) values ($hash, $value, 1)
+  on conflict (hash) do update set refs = refs + 1`, d.contentIdent()),
+		sql.Named("hash", hash), sql.Named("value", enc),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+// contentHash returns the hash identifying data in the content table, for a
+// value encoded by the codec with the given ID.
+func contentHash(codec byte, data []byte) []byte {
+	return hashContent(codecNames[codec], data)
+}
+
+// hashContent returns the hash identifying data in the content table, for a
+// value encoded by the built-in codec with the given name, or "" for the
+// codec of the store (see [CodecStore]). Values are hashed with the name of
+// their codec, so that they share content only with values encoded the same
+// way.
+func hashContent(codec string, data []byte) []byte {
+	h := sha256.New()
+	if codec != "" {
+		h.Write([]byte(codec + "\x00"))
+	}
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// release removes a reference to the content with the given reference within
+// tx, and discards the content if no references remain.
+func (d *sqlDB) release(ctx context.Context, tx *sql.Tx, ref []byte) error {
+	ctab := d.contentIdent()
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`update %s set refs = refs - 1 where hash = $hash`, ctab),
+		sql.Named("hash", ref)); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`delete from %s where hash = $hash and refs <= 0`, ctab),
+		sql.Named("hash", ref))
+	return err
+}
@@ -0,0 +1,140 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"errors"
+	"strings"
+)
+
+// Errors reported by the database are classified by cause, when the cause is
+// one a caller is likely to want to handle. A classified error matches one of
+// the following sentinels with [errors.Is], and also still wraps the original
+// error from the database.
+var (
+	// ErrDiskFull is reported when a write fails because the disk is full.
+	ErrDiskFull = errors.New("database or disk is full")
+
+	// ErrIO is reported when the database fails to read or write the disk.
+	ErrIO = errors.New("disk I/O error")
+
+	// ErrCorrupt is reported when the database file is corrupt, or is not a
+	// database. Compare [ErrCorruptValue], which concerns a single stored
+	// value. See also [Store.Recover].
+	ErrCorrupt = errors.New("database is corrupt")
+
+	// ErrReadOnly is reported when a write is attempted on a database that
+	// cannot be written, for example one opened with mode=ro, or through a
+	// [Snapshot].
+	ErrReadOnly = errors.New("database is read-only")
+)
+
+// Primary and extended result codes from SQLite.
+// See https://www.sqlite.org/rescode.html.
+const (
+	sqliteBusy     = 5
+	sqliteLocked   = 6
+	sqliteReadOnly = 8
+	sqliteIOErr    = 10
+	sqliteCorrupt  = 11
+	sqliteFull     = 13
+	sqliteNotADB   = 26
+
+	sqliteConstraintPrimaryKey = 1555
+	sqliteConstraintUnique     = 2067
+)
+
+// An ErrorCoder extracts SQLite result codes from the errors reported by a
+// database driver. The store uses these codes to detect a busy database and
+// constraint violations, and to classify errors by cause.
+// See [Options.ErrorCoder].
+type ErrorCoder interface {
+	// ResultCode reports the SQLite extended result code carried by err or
+	// an error it wraps, and whether there is one.
+	ResultCode(err error) (int, bool)
+}
+
+// ErrorCoderFunc adapts a function to the [ErrorCoder] interface.
+type ErrorCoderFunc func(err error) (int, bool)
+
+// ResultCode implements the [ErrorCoder] interface.
+func (f ErrorCoderFunc) ResultCode(err error) (int, bool) { return f(err) }
+
+// codeMethod is the default [ErrorCoder]. It accepts any error that has a
+// Code method reporting the extended result code, as the errors of the
+// default driver do.
+type codeMethod struct{}
+
+func (codeMethod) ResultCode(err error) (int, bool) {
+	var cerr interface{ Code() int }
+	if errors.As(err, &cerr) {
+		return cerr.Code(), true
+	}
+	return 0, false
+}
+
+// sqliteCode reports the extended result code of the SQLite error wrapped by
+// err, if any.
+func (d *sqlDB) sqliteCode(err error) (int, bool) {
+	if err == nil {
+		return 0, false
+	}
+	return d.coder.ResultCode(err)
+}
+
+// primaryCode reports the primary result code of the SQLite error wrapped by
+// err, or 0 if err does not wrap a SQLite error.
+func (d *sqlDB) primaryCode(err error) int {
+	code, _ := d.sqliteCode(err)
+	return code & 0xff
+}
+
+// isBusy reports whether err indicates the database is busy or locked.
+func (d *sqlDB) isBusy(err error) bool {
+	code := d.primaryCode(err)
+	return code == sqliteBusy || code == sqliteLocked
+}
+
+// isUniqueViolation reports whether err indicates a write violated a
+// uniqueness or primary key constraint. If err carries no result code, this
+// falls back to checking for the message SQLite reports for a violation,
+// which drivers generally pass through.
+func (d *sqlDB) isUniqueViolation(err error) bool {
+	code, ok := d.sqliteCode(err)
+	if !ok {
+		return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+	}
+	return code == sqliteConstraintUnique || code == sqliteConstraintPrimaryKey
+}
+
+// classifyError returns err wrapped with the sentinel for its cause, if err
+// wraps a SQLite error whose cause has a sentinel. Otherwise it returns err
+// unmodified.
+func (d *sqlDB) classifyError(err error) error {
+	var kind error
+	switch d.primaryCode(err) {
+	case sqliteFull:
+		kind = ErrDiskFull
+	case sqliteIOErr:
+		kind = ErrIO
+	case sqliteCorrupt, sqliteNotADB:
+		kind = ErrCorrupt
+	case sqliteReadOnly:
+		kind = ErrReadOnly
+	default:
+		return err
+	}
+	if errors.Is(err, kind) {
+		return err // already classified
+	}
+	return classifiedError{kind: kind, err: err}
+}
+
+// A classifiedError wraps an error from the database with the sentinel for
+// its cause. Its message is that of the original error.
+type classifiedError struct {
+	kind, err error
+}
+
+func (c classifiedError) Error() string   { return c.err.Error() }
+func (c classifiedError) Unwrap() []error { return []error{c.kind, c.err} }
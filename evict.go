@@ -0,0 +1,77 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// evicting reports whether d has a capacity limit.
+func (d *sqlDB) evicting() bool { return d.maxKeys > 0 || d.maxBytes > 0 }
+
+// evictTx deletes the least-recently used keys from the table of s within
+// tx, until the table is within the capacity limits of the store, and
+// reports the keys deleted, decoded as by List. Keys without an access time,
+// such as those written before eviction was enabled, are evicted first. The
+// caller is responsible for updating the cached length.
+func (s KV) evictTx(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	if !s.db.evicting() {
+		return nil, nil
+	}
+	var nkeys, nbytes int64
+	if err := tx.QueryRowContext(ctx, fmt.Sprintf(`select count(*), coalesce(sum(vsize), 0) from %s`, s.table())).
+		Scan(&nkeys, &nbytes); err != nil {
+		return nil, fmt.Errorf("evict: %w", err)
+	}
+	over := func() bool {
+		return (s.db.maxKeys > 0 && nkeys > s.db.maxKeys) || (s.db.maxBytes > 0 && nbytes > s.db.maxBytes)
+	}
+	if !over() {
+		return nil, nil
+	}
+
+	// Select victims in order of access until the remainder is within the
+	// limits, then delete them.
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`select key, vsize from %s order by accessed_at, key`, s.table()))
+	if err != nil {
+		return nil, fmt.Errorf("evict: %w", err)
+	}
+	defer rows.Close()
+	var victims []any // stored keys, which a lossy key codec cannot reproduce
+	for over() && rows.Next() {
+		var ekey any
+		var size int64
+		if err := rows.Scan(&ekey, &size); err != nil {
+			return nil, fmt.Errorf("evict: %w", err)
+		}
+		victims = append(victims, storedKey(ekey))
+		nkeys--
+		nbytes -= size
+	}
+	if err := rows.Close(); err != nil {
+		return nil, fmt.Errorf("evict: %w", err)
+	}
+	keys := make([]string, len(victims))
+	for i, ekey := range victims {
+		if err := s.deleteRowTx(ctx, tx, ekey); err != nil {
+			return nil, fmt.Errorf("evict: %w", err)
+		}
+		key, err := s.decodeKey(storedBytes(ekey))
+		if err != nil {
+			return nil, fmt.Errorf("evict: %w", err)
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+// touchTx updates the access time of key within tx.
+func (s KV) touchTx(ctx context.Context, tx *sql.Tx, key string) error {
+	query := fmt.Sprintf(`update %s set accessed_at = $now where key = $key`, s.table())
+	if _, err := tx.ExecContext(ctx, query, sql.Named("key", s.encodeKey(key)), nowArg()); err != nil {
+		return fmt.Errorf("get: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"archive/tar"
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"github.com/creachadair/ffs/blob"
+)
+
+// ExportTar writes the contents of s to w as a tar archive, in key order.
+//
+// Each key-value pair is stored as a regular file whose name is the
+// hexadecimal encoding of the key, and whose contents are the value.  Values
+// are decoded (and decompressed), so the archive does not depend on how the
+// store was configured, and can be read by [KV.ImportTar] into any store.
+//
+// ExportTar holds a read transaction for the duration of the export, and
+// writes to the store are blocked until it completes.
+func (s KV) ExportTar(ctx context.Context, w io.Writer) (err error) {
+	ctx, op := s.db.begin(ctx, "exporttar", s.tableName)
+	defer op.end(&err)
+
+	s.db.txmu.RLock()
+	defer s.db.txmu.RUnlock()
+
+	tw := tar.NewWriter(w)
+	if err := runTx(ctx, s.db.sqlDB, func(tx *sql.Tx) error { // not retried; see List
+		return s.scanTx(ctx, tx, "", func(key string, data []byte) error {
+			if err := tw.WriteHeader(&tar.Header{
+				Typeflag: tar.TypeReg,
+				Name:     hex.EncodeToString([]byte(key)),
+				Size:     int64(len(data)),
+				Mode:     0644,
+				Format:   tar.FormatPAX,
+			}); err != nil {
+				return err
+			}
+			_, err := tw.Write(data)
+			return err
+		})
+	}); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	return nil
+}
+
+// ImportTar reads a tar archive in the format written by [KV.ExportTar] from
+// r, and writes its contents to s. If replace is false, an entry whose key is
+// already present in s causes ImportTar to fail with [blob.ErrKeyExists];
+// entries imported before the failure remain in the store.
+func (s KV) ImportTar(ctx context.Context, r io.Reader, replace bool) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("import: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		key, err := hex.DecodeString(hdr.Name)
+		if err != nil {
+			return fmt.Errorf("import: invalid entry name %q: %w", hdr.Name, err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("import: %w", err)
+		}
+		if err := s.Put(ctx, blob.PutOptions{
+			Key:     string(key),
+			Data:    data,
+			Replace: replace,
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// A jsonRecord is a single key-value pair in the format of [KV.ExportJSON].
+type jsonRecord struct {
+	Key       string `json:"key"`
+	BinaryKey []byte `json:"binary_key,omitempty"` // if set, overrides Key
+	Value     []byte `json:"value"`
+}
+
+// ExportJSON writes the contents of s to w as a stream of JSON objects, one
+// per line, in key order. Each object has the form
+//
+//	{"key": "<key>", "value": "<base64>"}
+//
+// The value is encoded in base64 (as by [encoding/json] for []byte), so that
+// arbitrary binary values can be represented. A key that is not valid UTF-8
+// is instead encoded in base64 as "binary_key", and "key" is empty. As for
+// [KV.ExportTar], values are decoded, so the output can be read by
+// [KV.ImportJSON] into any store.
+//
+// Each record is written to w as soon as it is read, so the export does not
+// hold the contents of the store in memory. ExportJSON holds a read
+// transaction for the duration of the export, and writes to the store are
+// blocked until it completes.
+func (s KV) ExportJSON(ctx context.Context, w io.Writer) (err error) {
+	ctx, op := s.db.begin(ctx, "exportjson", s.tableName)
+	defer op.end(&err)
+
+	s.db.txmu.RLock()
+	defer s.db.txmu.RUnlock()
+
+	enc := json.NewEncoder(w)
+	if err := runTx(ctx, s.db.sqlDB, func(tx *sql.Tx) error { // not retried; see List
+		return s.scanTx(ctx, tx, "", func(key string, data []byte) error {
+			rec := jsonRecord{Key: key, Value: data}
+			if data == nil {
+				rec.Value = []byte{} // encode as "", not null
+			}
+			if !utf8.ValidString(key) {
+				rec.Key, rec.BinaryKey = "", []byte(key)
+			}
+			return enc.Encode(rec)
+		})
+	}); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+	return nil
+}
+
+// ImportJSON reads a stream of JSON objects in the format written by
+// [KV.ExportJSON] from r, and writes them to s. Records are decoded and
+// written one at a time. If replace is false, a record whose key is already
+// present in s causes ImportJSON to fail with [blob.ErrKeyExists]; records
+// imported before the failure remain in the store.
+func (s KV) ImportJSON(ctx context.Context, r io.Reader, replace bool) error {
+	dec := json.NewDecoder(r)
+	for {
+		var rec jsonRecord
+		if err := dec.Decode(&rec); errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("import: %w", err)
+		}
+		key := rec.Key
+		if rec.BinaryKey != nil {
+			key = string(rec.BinaryKey)
+		}
+		if err := s.Put(ctx, blob.PutOptions{
+			Key:     key,
+			Data:    rec.Value,
+			Replace: replace,
+		}); err != nil {
+			return err
+		}
+	}
+}
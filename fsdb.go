@@ -0,0 +1,94 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"reflect"
+	"sync"
+
+	"modernc.org/sqlite/vfs"
+)
+
+// NewFS opens a read-only store on the database file at the specified path in
+// fsys, for example a reference database embedded in the program with
+// [embed.FS]. The database is read directly from fsys, and is not copied to
+// disk or into memory. Files opened from fsys must implement [io.Seeker], as
+// those of [embed.FS], [os.DirFS], and [fstest.MapFS] do.
+//
+// All writes to the store report [ErrReadOnly]. The database must have been
+// written by a store of this version, since an older schema cannot be
+// upgraded in place, and must not have an active write-ahead log. Close the
+// source store before copying its database, so that the log is checkpointed.
+//
+// NewFS requires the default driver. The options are as for [New], except
+// that Shared, MaintenanceInterval, NoVacuum, VacuumOnClose, and
+// CheckpointOnClose are ignored.
+//
+// Each file system is registered with SQLite for the remaining life of the
+// program; opening another database from the same fsys reuses the
+// registration if the value of fsys is comparable.
+func NewFS(fsys fs.FS, path string, opts *Options) (Store, error) {
+	if !fs.ValidPath(path) {
+		return Store{}, fmt.Errorf("invalid database path %q", path)
+	}
+	var o Options
+	if opts != nil {
+		o = *opts
+	}
+	if o.driverName() != "sqlite" {
+		return Store{}, fmt.Errorf("driver %q cannot open a database from a file system", o.driverName())
+	}
+	o.Shared, o.MaintenanceInterval, o.NoVacuum = false, 0, true
+	o.VacuumOnClose, o.CheckpointOnClose = nil, nil
+
+	name, err := fsVFS.register(fsys)
+	if err != nil {
+		return Store{}, fmt.Errorf("register file system: %w", err)
+	}
+	// With immutable set, SQLite does not attempt to lock the database or
+	// look for a journal, neither of which the file system supports.
+	s, err := New("file:"+url.PathEscape(path)+"?mode=ro&immutable=1&vfs="+name, &o)
+	if err != nil {
+		return Store{}, err
+	}
+	s.readOnly, s.path = true, "" // the path is not on disk
+	return s, nil
+}
+
+// fsVFS records the names of the SQLite VFS registered for each file system
+// opened by NewFS. A registration is never released, since unregistering a
+// VFS is not safe with the current driver.
+var fsVFS = vfsRegistry{names: make(map[fs.FS]string)}
+
+type vfsRegistry struct {
+	mu    sync.Mutex
+	names map[fs.FS]string // comparable file systems only
+}
+
+// register returns the name of a VFS for fsys, registering one if necessary.
+func (r *vfsRegistry) register(fsys fs.FS) (string, error) {
+	if fsys == nil {
+		return "", errors.New("nil file system")
+	}
+	canReuse := reflect.TypeOf(fsys).Comparable()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if canReuse {
+		if name, ok := r.names[fsys]; ok {
+			return name, nil
+		}
+	}
+	name, _, err := vfs.New(fsys)
+	if err != nil {
+		return "", err
+	}
+	if canReuse {
+		r.names[fsys] = name
+	}
+	return name, nil
+}
@@ -8,6 +8,8 @@ require (
 	github.com/creachadair/ffs v0.10.0
 	github.com/creachadair/mds v0.22.1
 	github.com/golang/snappy v0.0.4
+	go.opentelemetry.io/otel v1.31.0
+	go.opentelemetry.io/otel/trace v1.31.0
 	modernc.org/sqlite v1.34.4
 )
 
@@ -0,0 +1,146 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+
+	"github.com/creachadair/ffs/blob"
+)
+
+// ImportFSOptions are options for [KV.ImportFS]. A nil *ImportFSOptions is
+// ready for use and provides default values as described.
+type ImportFSOptions struct {
+	// The number of files to write in each transaction. If <= 0, use 100.
+	BatchSize int
+
+	// If true, replace the values of keys already present in the store.
+	// Otherwise, such files are reported as [blob.ErrKeyExists] errors.
+	Replace bool
+
+	// If set, OnError is called for each file that cannot be read or stored,
+	// with the path of the file and the error. If OnError returns nil, the
+	// file is skipped and the import continues; otherwise the import stops
+	// and reports that error. By default, the first such error stops the
+	// import.
+	OnError func(path string, err error) error
+}
+
+func (o *ImportFSOptions) batchSize() int {
+	if o == nil || o.BatchSize <= 0 {
+		return 100
+	}
+	return o.BatchSize
+}
+
+func (o *ImportFSOptions) replace() bool { return o != nil && o.Replace }
+
+func (o *ImportFSOptions) onError(path string, err error) error {
+	if o == nil || o.OnError == nil {
+		return err
+	}
+	return o.OnError(path, err)
+}
+
+// ImportFS walks fsys and writes the contents of each regular file as the
+// value of a key given by its path relative to the root of fsys (for example,
+// "dir/file.txt").  Directories and other non-regular files are skipped.
+// Files are written in batches, one transaction per batch; if the import
+// stops early, batches already written remain in the store.
+func (s KV) ImportFS(ctx context.Context, fsys fs.FS, opts *ImportFSOptions) error {
+	var batch []blob.PutOptions
+	flush := func() error {
+		err := s.putBatch(ctx, batch, opts.onError)
+		batch = batch[:0]
+		return err
+	}
+	if err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return opts.onError(path, err)
+		} else if !d.Type().IsRegular() {
+			return nil
+		}
+		data, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return opts.onError(path, err)
+		}
+		batch = append(batch, blob.PutOptions{Key: path, Data: data, Replace: opts.replace()})
+		if len(batch) >= opts.batchSize() {
+			return flush()
+		}
+		return ctx.Err()
+	}); err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	if err := flush(); err != nil {
+		return fmt.Errorf("import: %w", err)
+	}
+	return nil
+}
+
+// BatchPut writes all the values in puts to s in a single transaction.  If
+// any write fails (for example, with [blob.ErrKeyExists] for an existing key
+// whose PutOptions do not set Replace), none of the values are written.
+func (s KV) BatchPut(ctx context.Context, puts []blob.PutOptions) error {
+	return s.putBatch(ctx, puts, func(_ string, err error) error { return err })
+}
+
+// putBatch writes all the values in batch to s in a single transaction.  If
+// writing an individual value fails, onError is called with its key and the
+// error; if onError returns nil that value is skipped, otherwise the
+// transaction is abandoned and putBatch reports that error.
+func (s KV) putBatch(ctx context.Context, batch []blob.PutOptions, onError func(string, error) error) (err error) {
+	if len(batch) == 0 {
+		return nil
+	}
+	ctx, op := s.db.begin(ctx, "putbatch", s.tableName)
+	defer op.end(&err)
+	var cs []change
+	defer s.db.changed(&cs, &err) // after the lock is released
+
+	s.db.txmu.Lock()
+	defer s.db.txmu.Unlock()
+
+	var added int64
+	var size int // total bytes written
+	if err := withTxErr(ctx, s.db.sqlDB, func(tx *sql.Tx) error {
+		added, size, cs = 0, 0, cs[:0] // reset in case of retry
+		for _, p := range batch {
+			// Each write gets its own savepoint, so that a failed write does
+			// not leave partial effects (e.g., content references) behind.
+			if _, err := tx.ExecContext(ctx, `savepoint putbatch`); err != nil {
+				return err
+			}
+			ok, err := s.putTx(ctx, tx, p.Key, p.Data, p.Replace, 0)
+			if err != nil {
+				if _, rerr := tx.ExecContext(ctx, `rollback to putbatch`); rerr != nil {
+					return rerr
+				}
+				if err := onError(p.Key, err); err != nil {
+					return err
+				}
+			} else {
+				size += len(p.Data)
+				if ok {
+					added++
+				}
+				cs = append(cs, change{"put", p.Key})
+			}
+			if _, err := tx.ExecContext(ctx, `release putbatch`); err != nil {
+				return err
+			}
+		}
+		evicted, err := s.evictTx(ctx, tx)
+		added -= int64(len(evicted))
+		cs = withDeletes(cs, evicted)
+		return err
+	}); err != nil {
+		return err
+	}
+	s.db.addLen(s.tableName, added)
+	op.setSize(size)
+	return nil
+}
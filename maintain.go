@@ -0,0 +1,67 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// A maintainer runs periodic maintenance on a database in the background.
+type maintainer struct {
+	stop chan struct{} // closed to request the goroutine to exit
+	done chan struct{} // closed by the goroutine when it exits
+	once sync.Once
+}
+
+// startMaintenance starts a goroutine that calls d.maintain every interval,
+// until stopMaintenance is called.
+func (d *sqlDB) startMaintenance(interval time.Duration) {
+	m := &maintainer{stop: make(chan struct{}), done: make(chan struct{})}
+	d.maint = m
+	go func() {
+		defer close(m.done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-t.C:
+				if err := d.maintain(context.Background()); err != nil {
+					d.logger.Error("sqlitestore maintenance failed", "err", err)
+				}
+			}
+		}
+	}()
+}
+
+// stopMaintenance stops the maintenance goroutine, if there is one, and
+// waits for it to exit. It is safe to call more than once. The caller must
+// not hold d.txmu.
+func (d *sqlDB) stopMaintenance() {
+	if m := d.maint; m != nil {
+		m.once.Do(func() { close(m.stop) })
+		<-m.done
+	}
+}
+
+// maintain checkpoints and truncates the write-ahead log, if there is one
+// (unless NoCheckpoint is set), and updates the query planner statistics. It
+// excludes all other operations while it runs.
+func (d *sqlDB) maintain(ctx context.Context) (err error) {
+	ctx, op := d.begin(ctx, "maintain", "")
+	defer op.end(&err)
+
+	d.txmu.Lock()
+	defer d.txmu.Unlock()
+
+	if !d.noCheckpoint {
+		if _, err := d.db.ExecContext(ctx, `pragma wal_checkpoint(TRUNCATE)`); err != nil {
+			return err
+		}
+	}
+	_, err = d.db.ExecContext(ctx, `pragma optimize`)
+	return err
+}
@@ -0,0 +1,280 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/creachadair/ffs/storage/dbkey"
+)
+
+// Namespaces returns the keyspace prefixes of all the KV tables in the
+// database, in order of their hex encodings. The table for a prefix p holds
+// the KV returned by a [Store] method such as s.KV(ctx, name) when
+// p == s.tableName.Keyspace(name); note that this includes the KVs of all
+// substores, not only of s itself.
+func (s Store) Namespaces(ctx context.Context) (_ []dbkey.Prefix, err error) {
+	ctx, op := s.begin(ctx, "namespaces", "")
+	defer op.end(&err)
+
+	s.txmu.RLock()
+	defer s.txmu.RUnlock()
+
+	tables, err := withTxValue(ctx, s.sqlDB, func(tx *sql.Tx) ([]string, error) {
+		return s.kvTables(ctx, tx, "true")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("namespaces: %w", err)
+	}
+	out := make([]dbkey.Prefix, 0, len(tables))
+	for _, table := range tables {
+		if p, ok := s.tablePrefix(table); ok {
+			out = append(out, p)
+		}
+	}
+	return out, nil
+}
+
+// prefixTable returns the name of the KV table for the keyspace prefix p.
+func (d *sqlDB) prefixTable(p dbkey.Prefix) string {
+	if d.table != "" {
+		return d.table + "_" + p.String()
+	}
+	return p.String() // hex-encoded
+}
+
+// DropNamespace deletes the KV table for the keyspace prefix p, along with
+// its indexes, and releases any shared content referenced by its values. It
+// is not an error if there is no table for p. A [KV] for p obtained before
+// the table is dropped must not be used afterward; opening the KV again
+// creates a new, empty table.
+func (s Store) DropNamespace(ctx context.Context, p dbkey.Prefix) (err error) {
+	table := s.prefixTable(p)
+	ctx, op := s.begin(ctx, "dropnamespace", table)
+	defer op.end(&err)
+
+	s.txmu.Lock()
+	defer s.txmu.Unlock()
+
+	// Close cached statements first, since they may block dropping the table.
+	if err := s.stmts.closeTable(table); err != nil {
+		return fmt.Errorf("drop namespace: %w", err)
+	}
+	if err := withTxErr(ctx, s.sqlDB, func(tx *sql.Tx) error {
+		tables, err := s.kvTables(ctx, tx, "s.name = $table", sql.Named("table", table))
+		if err != nil || len(tables) == 0 {
+			return err
+		}
+		if err := s.releaseAll(ctx, tx, table); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, `drop table `+quoteIdent(table)); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`delete from %s where name = $table`, quoteIdent(s.schemaTable())),
+			sql.Named("table", table))
+		return err
+	}); err != nil {
+		return fmt.Errorf("drop namespace: %w", err)
+	}
+	delete(s.lens, table)
+	delete(s.codecs, table)
+	return nil
+}
+
+// RenameNamespace renames the KV table for the keyspace prefix from, so that
+// it holds the KV for the prefix to, without copying its contents. It reports
+// an error if there is no table for from, or if a table for to already
+// exists. As with [Store.DropNamespace], a [KV] for from obtained before the
+// rename must not be used afterward.
+func (s Store) RenameNamespace(ctx context.Context, from, to dbkey.Prefix) (err error) {
+	src, dst := s.prefixTable(from), s.prefixTable(to)
+	ctx, op := s.begin(ctx, "renamenamespace", src)
+	defer op.end(&err)
+
+	s.txmu.Lock()
+	defer s.txmu.Unlock()
+
+	if err := s.stmts.closeTable(src); err != nil {
+		return fmt.Errorf("rename namespace: %w", err)
+	}
+	if err := withTxErr(ctx, s.sqlDB, func(tx *sql.Tx) error {
+		_, indexes, err := s.namespaceDefs(ctx, tx, from, to)
+		if err != nil {
+			return err
+		}
+
+		// Indexes keep their names when their table is renamed, but they are
+		// named after the table, so drop them and recreate them afterward.
+		for _, ix := range indexes {
+			if _, err := tx.ExecContext(ctx, `drop index `+quoteIdent(ix.name)); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`alter table %s rename to %s`, quoteIdent(src), quoteIdent(dst))); err != nil {
+			return err
+		}
+		for _, ix := range indexes {
+			if _, err := tx.ExecContext(ctx, ix.def); err != nil {
+				return err
+			}
+		}
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`update %s set name = $dst where name = $src`, quoteIdent(s.schemaTable())),
+			sql.Named("src", src), sql.Named("dst", dst))
+		return err
+	}); err != nil {
+		return fmt.Errorf("rename namespace: %w", err)
+	}
+	if n, ok := s.lens[src]; ok {
+		s.lens[dst] = n
+		delete(s.lens, src)
+	}
+	if tc, ok := s.codecs[src]; ok {
+		s.codecs[dst] = tc
+		delete(s.codecs, src)
+	}
+	return nil
+}
+
+// CloneNamespace creates a KV table for the keyspace prefix dst holding a copy
+// of the contents of the table for src. Stored values are copied as-is, without
+// decoding and re-encoding them, so this is much faster than [KV.CopyTo].
+// It reports an error if there is no table for src, or if a table for dst
+// already exists.
+func (s Store) CloneNamespace(ctx context.Context, src, dst dbkey.Prefix) (err error) {
+	stab, dtab := s.prefixTable(src), s.prefixTable(dst)
+	ctx, op := s.begin(ctx, "clonenamespace", stab)
+	defer op.end(&err)
+
+	s.txmu.Lock()
+	defer s.txmu.Unlock()
+
+	if err := withTxErr(ctx, s.sqlDB, func(tx *sql.Tx) error {
+		table, indexes, err := s.namespaceDefs(ctx, tx, src, dst)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, table); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`insert into %s select * from %s`, quoteIdent(dtab), quoteIdent(stab))); err != nil {
+			return err
+		}
+		for _, ix := range indexes {
+			if _, err := tx.ExecContext(ctx, ix.def); err != nil {
+				return err
+			}
+		}
+
+		// The copied values hold an additional reference to shared content.
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`update %[1]s set refs = refs + r.n
+  from (select ref, count(*) as n from %[2]s where ref is not null group by ref) as r
+  where %[1]s.hash = r.ref`, s.contentIdent(), quoteIdent(dtab))); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, fmt.Sprintf(`insert into %[1]s (name, version, codec)
+  select $dst, version, codec from %[1]s where name = $src`, quoteIdent(s.schemaTable())),
+			sql.Named("src", stab), sql.Named("dst", dtab))
+		return err
+	}); err != nil {
+		return fmt.Errorf("clone namespace: %w", err)
+	}
+	if n, ok := s.lens[stab]; ok {
+		s.lens[dtab] = n
+	}
+	if tc, ok := s.codecs[stab]; ok {
+		s.codecs[dtab] = tc
+	}
+	return nil
+}
+
+// A schemaDef is the name and definition of a schema object.
+type schemaDef struct{ name, def string }
+
+// namespaceDefs checks that there is a KV table for from and that no table for
+// to exists, and returns the definitions of the table for from and its
+// indexes, rewritten to define the corresponding objects for to.
+func (d *sqlDB) namespaceDefs(ctx context.Context, tx *sql.Tx, from, to dbkey.Prefix) (table string, indexes []schemaDef, _ error) {
+	src, dst := d.prefixTable(from), d.prefixTable(to)
+	if tables, err := d.kvTables(ctx, tx, "s.name = $table", sql.Named("table", src)); err != nil {
+		return "", nil, err
+	} else if len(tables) == 0 {
+		return "", nil, fmt.Errorf("no table for %s", from)
+	}
+	var n int
+	if err := tx.QueryRowContext(ctx, `select count(*) from sqlite_schema where name = $name`,
+		sql.Named("name", dst)).Scan(&n); err != nil {
+		return "", nil, err
+	} else if n != 0 {
+		return "", nil, fmt.Errorf("table for %s already exists", to)
+	}
+
+	// Table names are hex, and the names of their indexes are derived from
+	// them, so this replaces only table and index names.
+	rewrite := func(def string) string { return strings.ReplaceAll(def, `"`+src, `"`+dst) }
+	rows, err := tx.QueryContext(ctx, `select type, name, sql from sqlite_schema
+  where tbl_name = $table and sql is not null`, sql.Named("table", src))
+	if err != nil {
+		return "", nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind, name, def string
+		if err := rows.Scan(&kind, &name, &def); err != nil {
+			return "", nil, err
+		}
+		if kind == "table" {
+			table = rewrite(def)
+		} else if kind == "index" {
+			indexes = append(indexes, schemaDef{name: name, def: rewrite(def)})
+		}
+	}
+	return table, indexes, rows.Err()
+}
+
+// releaseAll releases the content references of all the values in table.
+func (d *sqlDB) releaseAll(ctx context.Context, tx *sql.Tx, table string) error {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`select ref from %s where ref is not null`, quoteIdent(table)))
+	if err != nil {
+		return err
+	}
+	var refs [][]byte
+	for rows.Next() {
+		var ref []byte
+		if err := rows.Scan(&ref); err != nil {
+			rows.Close()
+			return err
+		}
+		refs = append(refs, ref)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	for _, ref := range refs {
+		if err := d.release(ctx, tx, ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tablePrefix reports the keyspace prefix encoded by the name of a KV table,
+// and whether the name is a valid encoding.
+func (d *sqlDB) tablePrefix(table string) (dbkey.Prefix, bool) {
+	if d.table != "" {
+		var ok bool
+		table, ok = strings.CutPrefix(table, d.table+"_")
+		if !ok {
+			return "", false
+		}
+	}
+	p, err := hex.DecodeString(table)
+	if err != nil || len(p) != dbkey.PrefixLen {
+		return "", false
+	}
+	return dbkey.Prefix(p), true
+}
@@ -0,0 +1,137 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+// Package prommetrics implements the [sqlitestore.Metrics] interface using
+// Prometheus collectors.
+//
+// Example:
+//
+//	m := prommetrics.New("myapp_sqlitestore")
+//	if err := prometheus.DefaultRegisterer.Register(m); err != nil {
+//	  log.Fatalf("Register metrics: %v", err)
+//	}
+//	s, err := sqlitestore.New(url, &sqlitestore.Options{Metrics: m})
+//	// ...
+//	kv, err := s.KV(ctx, "blobs")
+//	m.Watch("blobs", kv.(sqlitestore.KV))
+package prommetrics
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/creachadair/sqlitestore"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics implements the [sqlitestore.Metrics] interface. It is also a
+// [prometheus.Collector], and must be registered to export its values.
+//
+// Operation counts are exported as a counter vector with labels "op" and
+// "result" (either "ok" or "error"), and operation latencies as a histogram
+// vector with label "op".  In addition, the number of keys and the total size
+// of values in each watched KV (see [Metrics.Watch]) are exported as gauges
+// with label "kv".
+type Metrics struct {
+	ops     *prometheus.CounterVec
+	latency *prometheus.HistogramVec
+	keys    *prometheus.Desc
+	bytes   *prometheus.Desc
+
+	// Timeout bounds the time spent querying each watched KV when the
+	// collector is scraped. If zero, a default of 5 seconds is used.
+	Timeout time.Duration
+
+	μ     sync.Mutex
+	watch map[string]sqlitestore.KV
+}
+
+// New constructs a new unregistered Metrics whose metric names begin with the
+// given namespace.
+func New(namespace string) *Metrics {
+	return &Metrics{
+		ops: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "ops_total",
+			Help:      "Number of store operations performed.",
+		}, []string{"op", "result"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "op_duration_seconds",
+			Help:      "Latency of store operations.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"op"}),
+		keys: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "keys"),
+			"Number of keys stored in a KV.",
+			[]string{"kv"}, nil,
+		),
+		bytes: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "bytes"),
+			"Total size in bytes of values stored in a KV, before compression.",
+			[]string{"kv"}, nil,
+		),
+		watch: make(map[string]sqlitestore.KV),
+	}
+}
+
+// ObserveOp implements the [sqlitestore.Metrics] interface.
+func (m *Metrics) ObserveOp(op, _ string, elapsed time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	m.ops.WithLabelValues(op, result).Inc()
+	m.latency.WithLabelValues(op).Observe(elapsed.Seconds())
+}
+
+// Watch adds kv to the set of KVs whose key count and size are exported,
+// labelled with the given name. Watching a name a second time replaces the
+// previous KV for that name.
+func (m *Metrics) Watch(name string, kv sqlitestore.KV) {
+	m.μ.Lock()
+	defer m.μ.Unlock()
+	m.watch[name] = kv
+}
+
+// Unwatch removes the KV with the given name from the watched set.
+func (m *Metrics) Unwatch(name string) {
+	m.μ.Lock()
+	defer m.μ.Unlock()
+	delete(m.watch, name)
+}
+
+// Describe implements part of the [prometheus.Collector] interface.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.ops.Describe(ch)
+	m.latency.Describe(ch)
+	ch <- m.keys
+	ch <- m.bytes
+}
+
+// Collect implements part of the [prometheus.Collector] interface.
+// Watched KVs whose statistics cannot be read are omitted.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.ops.Collect(ch)
+	m.latency.Collect(ch)
+
+	m.μ.Lock()
+	defer m.μ.Unlock()
+	for name, kv := range m.watch {
+		ctx, cancel := context.WithTimeout(context.Background(), m.timeout())
+		if n, err := kv.Len(ctx); err == nil {
+			ch <- prometheus.MustNewConstMetric(m.keys, prometheus.GaugeValue, float64(n), name)
+		}
+		if n, err := kv.Size(ctx); err == nil {
+			ch <- prometheus.MustNewConstMetric(m.bytes, prometheus.GaugeValue, float64(n), name)
+		}
+		cancel()
+	}
+}
+
+func (m *Metrics) timeout() time.Duration {
+	if m.Timeout <= 0 {
+		return 5 * time.Second
+	}
+	return m.Timeout
+}
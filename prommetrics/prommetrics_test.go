@@ -0,0 +1,76 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package prommetrics_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/sqlitestore"
+	"github.com/creachadair/sqlitestore/prommetrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetrics(t *testing.T) {
+	ctx := context.Background()
+	m := prommetrics.New("test")
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(m); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+
+	url := "file:" + filepath.Join(t.TempDir(), "test.db")
+	s, err := sqlitestore.New(url, &sqlitestore.Options{Metrics: m})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close(ctx)
+	kv, err := s.KV(ctx, "test")
+	if err != nil {
+		t.Fatalf("KV failed: %v", err)
+	}
+	m.Watch("test", kv.(sqlitestore.KV))
+
+	if err := kv.Put(ctx, blob.PutOptions{Key: "a", Data: []byte("apple")}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, err := kv.Get(ctx, "a"); err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if _, err := kv.Get(ctx, "nonesuch"); !blob.IsKeyNotFound(err) {
+		t.Fatalf("Get: got %v, want %v", err, blob.ErrKeyNotFound)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("Gather failed: %v", err)
+	}
+	got := make(map[string]float64)
+	for _, mf := range mfs {
+		for _, v := range mf.GetMetric() {
+			key := mf.GetName()
+			for _, lp := range v.GetLabel() {
+				key += "/" + lp.GetValue()
+			}
+			switch {
+			case v.Counter != nil:
+				got[key] = v.Counter.GetValue()
+			case v.Gauge != nil:
+				got[key] = v.Gauge.GetValue()
+			}
+		}
+	}
+	for key, want := range map[string]float64{
+		"test_ops_total/get/ok":    1,
+		"test_ops_total/get/error": 1,
+		"test_ops_total/put/ok":    1,
+		"test_keys/test":           1,
+		"test_bytes/test":          5,
+	} {
+		if got[key] != want {
+			t.Errorf("Metric %q: got %v, want %v", key, got[key], want)
+		}
+	}
+}
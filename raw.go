@@ -0,0 +1,110 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/mds/value"
+)
+
+// GetRaw returns the value of key as it is stored, without decoding it, and
+// the ID of the codec that encoded it (see [CodecStore] and the IDs that
+// follow it). Since values are recorded with their codec, the result need
+// not be encoded by the current codec of s. The value can be written to
+// another store with [KV.PutRaw], to copy values without decoding and
+// re-encoding them. Unlike Get, GetRaw does not verify the checksum of the
+// value, nor update its access time.
+func (s KV) GetRaw(ctx context.Context, key string) (_ []byte, codec byte, err error) {
+	ctx, op := s.db.begin(ctx, "getraw", s.tableName)
+	defer op.end(&err)
+
+	s.db.txmu.RLock()
+	defer s.db.txmu.RUnlock()
+
+	op.setKey(key)
+	if _, err := s.prepare(ctx, s.getQuery()); err != nil {
+		return nil, 0, fmt.Errorf("getraw: %w", err)
+	}
+	data, err := withTxValue(ctx, s.db.sqlDB, func(tx *sql.Tx) ([]byte, error) {
+		st, err := s.stmt(ctx, tx, s.getQuery())
+		if err != nil {
+			return nil, fmt.Errorf("getraw: %w", err)
+		}
+		var data []byte
+		var sum sql.NullInt64
+		if err := st.QueryRowContext(ctx, sql.Named("key", s.encodeKey(key)), nowArg()).Scan(&data, &codec, &sum); errors.Is(err, sql.ErrNoRows) {
+			return nil, blob.KeyNotFound(key)
+		} else if err != nil {
+			return nil, fmt.Errorf("getraw: %w", err)
+		}
+		op.setSize(len(data))
+		return data, nil
+	})
+	return data, codec, err
+}
+
+// PutRaw writes opts.Data, which must be a value encoded by the codec with
+// the given ID (for example, as returned by [KV.GetRaw]), as the value of
+// opts.Key without re-encoding it. The codec is recorded with the value, so
+// it need not be the current codec of s; it must be one of the built-in
+// codecs, or [CodecStore] for the codec of s. Otherwise PutRaw behaves as Put.
+//
+// PutRaw decodes the value only if it needs the decoded contents: to find
+// its size, unless the codec records it ([CodecNone] and [CodecSnappy] do),
+// or to compute its checksum (see [Options.Verify]) or content hash (see
+// [Options.Dedup] and [Options.Overflow]). A value that it decodes and
+// cannot be decoded is reported as [ErrCorruptValue], and is not written; a
+// value it does not decode is written as given, and if it is not valid, Get
+// reports ErrCorruptValue when it is read.
+func (s KV) PutRaw(ctx context.Context, opts blob.PutOptions, codec byte) (err error) {
+	ctx, op := s.db.begin(ctx, "putraw", s.tableName)
+	defer op.end(&err)
+	var cs []change
+	defer s.db.changed(&cs, &err) // after the lock is released
+
+	s.db.txmu.Lock()
+	defer s.db.txmu.Unlock()
+
+	op.setKey(opts.Key)
+	op.setSize(len(opts.Data))
+	if s.db.codecByID(codec) == nil {
+		return fmt.Errorf("putraw: unknown codec ID %d", codec)
+	} else if codec == CodecStore {
+		codec = s.db.codecID // record a built-in store codec by its own ID
+	}
+	var data []byte // the decoded value, if needed
+	size, ok := decodedLen(codec, opts.Data)
+	if !ok || s.needsDecoded(len(opts.Data)) {
+		data, err = s.decodeBlob(opts.Key, codec, opts.Data)
+		if err != nil {
+			return fmt.Errorf("putraw: %w", err)
+		}
+		size = len(data)
+	}
+	enc := opts.Data
+	if enc == nil {
+		enc = []byte{} // a nil slice is stored as NULL
+	}
+	if _, err := s.prepare(ctx, s.putQuery(opts.Replace)); err != nil {
+		return fmt.Errorf("putraw: %w", err)
+	}
+	var added bool
+	var evicted []string
+	if err := withTxErr(ctx, s.db.sqlDB, func(tx *sql.Tx) (err error) {
+		added, err = s.putEncodedTx(ctx, tx, opts.Key, size, data, enc, codec, opts.Replace, 0)
+		if err == nil {
+			evicted, err = s.evictTx(ctx, tx)
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+	s.db.addLen(s.tableName, value.Cond[int64](added, 1, 0)-int64(len(evicted)))
+	cs = withDeletes([]change{{"put", opts.Key}}, evicted)
+	return nil
+}
@@ -0,0 +1,177 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/creachadair/ffs/blob"
+)
+
+// RecompressOptions are options for [KV.RecompressWith]. A nil
+// *RecompressOptions is ready for use and provides default values as
+// described.
+type RecompressOptions struct {
+	// The number of values to examine in each transaction. If <= 0, use 100.
+	BatchSize int
+
+	// If set, Progress is called after each batch is committed. Values
+	// already encoded with the target codec are reported as skipped.
+	// Progress is called without holding any lock on the store.
+	Progress func(Progress)
+}
+
+func (o *RecompressOptions) batchSize() int {
+	if o == nil || o.BatchSize <= 0 {
+		return 100
+	}
+	return o.BatchSize
+}
+
+// Recompress rewrites the stored values of s that are not encoded with codec,
+// decoding each with the codec recorded for it and re-encoding it with codec,
+// and reports the number of values rewritten. Use it after changing the codec
+// of an existing store (see [Options]) or namespace (see [KV.SetCompression])
+// to convert the values written with the previous codec. The codec must be
+// one of the built-in codecs ([SnappyCodec], [GzipCodec], or [NoCodec]), or
+// the codec of the store.
+//
+// Values are rewritten in batches, each in its own transaction, and values
+// are readable throughout, whichever codec they are encoded with. Since
+// values already encoded with codec are skipped, a Recompress that fails or
+// is interrupted can be resumed by calling it again.
+func (s KV) Recompress(ctx context.Context, codec Codec) (int, error) {
+	return s.RecompressWith(ctx, codec, nil)
+}
+
+// RecompressWith is as [KV.Recompress], with options to set the size of
+// batches and to report progress.
+func (s KV) RecompressWith(ctx context.Context, codec Codec, opts *RecompressOptions) (_ int, err error) {
+	ctx, op := s.db.begin(ctx, "recompress", s.tableName)
+	defer op.end(&err)
+
+	id, ok := builtinCodecID(codec)
+	if !ok && (codec == nil || codec != s.db.codec) {
+		return 0, errors.New("recompress: codec is neither built in nor the codec of the store")
+	}
+
+	var prog Progress
+	if opts != nil && opts.Progress != nil {
+		n, err := s.Len(ctx)
+		if err != nil {
+			return 0, fmt.Errorf("recompress: %w", err)
+		}
+		prog.Total = n
+	}
+	var done int
+	rel, from := ">=", s.encodeStart("")
+	for {
+		n, skipped, last, err := s.recompressBatch(ctx, id, codec, rel, from, opts.batchSize())
+		done += n
+		if err != nil {
+			return done, fmt.Errorf("recompress: %w", err)
+		} else if n+skipped == 0 {
+			return done, nil
+		}
+		rel, from = ">", last // resume after the last stored key
+		if opts != nil && opts.Progress != nil {
+			prog.Processed += int64(n + skipped)
+			prog.Skipped += int64(skipped)
+			opts.Progress(prog)
+		}
+	}
+}
+
+// recompressBatch rewrites values with codec, whose ID is id, among up to
+// limit keys starting from the first key whose stored representation compares
+// to from by rel. It reports how many values it rewrote and how many it
+// skipped because they were already encoded with codec, and the stored
+// representation of the last key it examined.
+func (s KV) recompressBatch(ctx context.Context, id byte, codec Codec, rel string, from any, limit int) (n, skipped int, last any, _ error) {
+	s.db.txmu.Lock()
+	defer s.db.txmu.Unlock()
+
+	type row struct {
+		ekey  any    // as stored
+		value []byte // nil if already encoded with codec
+		codec byte
+		ref   []byte
+	}
+	var batch []row
+	if err := withTxErr(ctx, s.db.sqlDB, func(tx *sql.Tx) error {
+		batch, n, skipped = batch[:0], 0, 0 // reset in case of retry
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf(`select t.key,
+    case when %[1]s = $codec then NULL else coalesce(c.value, t.value) end, %[1]s, t.ref
+  from %[2]s as t left join %[3]s as c on c.hash = t.ref
+  where t.key %[4]s $from order by t.key limit $limit`, rowCodec, s.table(), s.db.contentIdent(), rel),
+			sql.Named("codec", id), sql.Named("from", from), sql.Named("limit", limit))
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var r row
+			if err := rows.Scan(&r.ekey, &r.value, &r.codec, &r.ref); err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, r)
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+
+		buf := s.db.getBuf()
+		defer s.db.putBuf(buf)
+		for _, r := range batch {
+			if r.codec == id {
+				skipped++
+				continue
+			}
+			key, err := s.decodeKey(storedBytes(r.ekey))
+			if err != nil {
+				return err
+			}
+			data, err := s.decodeBlob(key, r.codec, r.value)
+			if err != nil {
+				return err
+			}
+			enc, err := encodeWith(codec, buf, data)
+			if err != nil {
+				return &blob.KeyError{Key: key, Err: err}
+			}
+			if r.ref == nil {
+				if _, err := tx.ExecContext(ctx, fmt.Sprintf(`update %s set value = $value, codec = $codec where key = $key`, s.table()),
+					sql.Named("value", enc), sql.Named("codec", id), sql.Named("key", storedKey(r.ekey))); err != nil {
+					return err
+				}
+				n++
+				continue
+			}
+
+			// The content hash depends on the codec, so the value gets a new
+			// reference, which it may share with other values.
+			ref, err := s.db.retain(ctx, tx, contentHash(id, data), enc)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf(`update %s set ref = $ref, codec = $codec where key = $key`, s.table()),
+				sql.Named("ref", ref), sql.Named("codec", id), sql.Named("key", storedKey(r.ekey))); err != nil {
+				return err
+			}
+			if err := s.db.release(ctx, tx, r.ref); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	}); err != nil {
+		return 0, 0, nil, err
+	}
+	if len(batch) == 0 {
+		return 0, 0, nil, nil
+	}
+	return n, skipped, storedKey(batch[len(batch)-1].ekey), nil
+}
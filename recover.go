@@ -0,0 +1,131 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Recover copies whatever rows can still be read from the database into a
+// new database at destPath, which must not already contain any tables. It is
+// meant for salvaging data from a database that reports [ErrCorrupt].
+//
+// Recover is best-effort: each table is copied in order of its rows until the
+// first row that cannot be read, and then the indexes are rebuilt. Rows after
+// a damaged region of a table are lost. If any table or index could not be
+// copied completely, Recover reports an error describing the failures, but
+// the rows it did copy remain in the new database. Values are copied as
+// stored, so the new database must be opened with the same options (codec,
+// key encoding, and so on) as the original.
+func (s Store) Recover(ctx context.Context, destPath string) (err error) {
+	ctx, op := s.begin(ctx, "recover", "")
+	defer op.end(&err)
+
+	s.txmu.RLock()
+	defer s.txmu.RUnlock()
+
+	src, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("recover: %w", s.classifyError(err))
+	}
+	defer src.Close()
+
+	// Ignore errors in the schema, so that the surviving tables can be read.
+	if _, err := src.ExecContext(ctx, `pragma writable_schema = on`); err != nil {
+		return fmt.Errorf("recover: %w", s.classifyError(err))
+	}
+	defer src.ExecContext(context.Background(), `pragma writable_schema = off`)
+
+	type schemaRow struct{ kind, name, sql string }
+	var schema []schemaRow
+	rows, err := src.QueryContext(ctx, `select type, name, sql from sqlite_schema
+  where type in ('table', 'index') and sql is not null and name not like 'sqlite_%'
+  order by type desc`) // tables before indexes
+	if err != nil {
+		return fmt.Errorf("recover: read schema: %w", s.classifyError(err))
+	}
+	for rows.Next() {
+		var r schemaRow
+		if err := rows.Scan(&r.kind, &r.name, &r.sql); err != nil {
+			rows.Close()
+			return fmt.Errorf("recover: read schema: %w", s.classifyError(err))
+		}
+		schema = append(schema, r)
+	}
+	if err := rows.Close(); err != nil {
+		return fmt.Errorf("recover: read schema: %w", s.classifyError(err))
+	}
+
+	dst := sql.OpenDB(&initConnector{drv: s.db.Driver(), name: "file:" + destPath})
+	defer dst.Close()
+	var ntab int
+	if err := dst.QueryRowContext(ctx, `select count(*) from sqlite_schema where type = 'table'`).Scan(&ntab); err != nil {
+		return fmt.Errorf("recover: open destination: %w", err)
+	} else if ntab != 0 {
+		return fmt.Errorf("recover: destination %q is not empty", destPath)
+	}
+
+	var errs []error
+	for _, r := range schema {
+		if _, err := dst.ExecContext(ctx, r.sql); err != nil {
+			errs = append(errs, fmt.Errorf("create %s %s: %w", r.kind, r.name, err))
+			continue
+		}
+		if r.kind == "table" {
+			if err := recoverTable(ctx, src, dst, r.name); err != nil {
+				errs = append(errs, fmt.Errorf("table %s: %w", r.name, s.classifyError(err)))
+			}
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("recover: %w", err)
+	}
+	return nil
+}
+
+// recoverTable copies the readable rows of the specified table from src to
+// the same table in dst, stopping at the first row that cannot be read.
+func recoverTable(ctx context.Context, src *sql.Conn, dst *sql.DB, table string) error {
+	name := quoteIdent(table)
+	rows, err := src.QueryContext(ctx, `select * from `+name)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	vals := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range vals {
+		ptrs[i] = &vals[i]
+	}
+	// Commit the rows copied before a read error, and report the error.
+	var readErr error
+	if err := inTx(ctx, dst, func(tx *sql.Tx) error {
+		ins, err := tx.PrepareContext(ctx, fmt.Sprintf(`insert into %s values (%s)`,
+			name, strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")))
+		if err != nil {
+			return err
+		}
+		defer ins.Close()
+		for rows.Next() {
+			if readErr = rows.Scan(ptrs...); readErr != nil {
+				return nil
+			}
+			if _, err := ins.ExecContext(ctx, vals...); err != nil {
+				return err
+			}
+		}
+		readErr = rows.Err()
+		return nil
+	}); err != nil {
+		return err
+	}
+	return readErr
+}
@@ -0,0 +1,138 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/creachadair/ffs/blob"
+)
+
+// Progress reports the progress of an operation that visits the keys of a
+// KV, such as [KV.Scan] or [KV.CopyTo].
+type Progress struct {
+	// The number of keys processed so far, including any that were skipped.
+	Processed int64
+
+	// The number of keys processed so far that were skipped without being
+	// acted upon, for example because they were already present in the
+	// destination of a copy.
+	Skipped int64
+
+	// The total number of keys in the KV when the operation began. It may be
+	// inexact if the KV is modified during the operation.
+	Total int64
+}
+
+// ScanOptions are options for [KV.Scan]. A nil *ScanOptions is ready for use
+// and provides default values as described.
+type ScanOptions struct {
+	// Scan keys greater than or equal to Start. By default, scan all keys.
+	Start string
+
+	// The number of key-value pairs to read at a time. If <= 0, use 100.
+	BatchSize int
+
+	// If set, Progress is called after every ProgressEvery pairs, and once
+	// more when the scan ends. A scan does not skip any keys. Progress is
+	// called without holding any lock on the store.
+	Progress func(Progress)
+
+	// The number of pairs to process between calls to Progress.
+	// If <= 0, use 1000.
+	ProgressEvery int
+}
+
+func (o *ScanOptions) start() string {
+	if o == nil {
+		return ""
+	}
+	return o.Start
+}
+
+func (o *ScanOptions) batchSize() int {
+	if o == nil || o.BatchSize <= 0 {
+		return 100
+	}
+	return o.BatchSize
+}
+
+func (o *ScanOptions) progressEvery() int64 {
+	if o == nil || o.ProgressEvery <= 0 {
+		return 1000
+	}
+	return int64(o.ProgressEvery)
+}
+
+// ForEach calls fn with each key in s and its value, in lexicographic order
+// by key. If fn reports an error, ForEach stops and returns that error,
+// except that if the error is [blob.ErrStopListing], ForEach returns nil.
+//
+// Keys and values are read in batches, and writes to the store are blocked
+// only while a batch is read, not while fn is running. Thus fn may safely
+// write to the store, but such writes may or may not be observed by later
+// calls of fn.
+func (s KV) ForEach(ctx context.Context, fn func(key string, data []byte) error) (err error) {
+	ctx, op := s.db.begin(ctx, "foreach", s.tableName)
+	defer op.end(&err)
+	return s.scan(ctx, nil, fn)
+}
+
+// Scan is as [KV.ForEach], with options to set where the scan starts, the
+// size of batches, and to report progress.
+func (s KV) Scan(ctx context.Context, opts *ScanOptions, fn func(key string, data []byte) error) (err error) {
+	ctx, op := s.db.begin(ctx, "scan", s.tableName)
+	defer op.end(&err)
+	return s.scan(ctx, opts, fn)
+}
+
+func (s KV) scan(ctx context.Context, opts *ScanOptions, fn func(key string, data []byte) error) error {
+	var prog Progress
+	var progress func(Progress)
+	if opts != nil && opts.Progress != nil {
+		n, err := s.Len(ctx)
+		if err != nil {
+			return fmt.Errorf("scan: %w", err)
+		}
+		prog.Total, progress = n, opts.Progress
+		defer func() { progress(prog) }()
+	}
+	every := opts.progressEvery()
+
+	type pair struct {
+		key  string
+		data []byte
+	}
+	var batch []pair
+	rel, from := ">=", s.encodeStart(opts.start())
+	for {
+		batch = batch[:0]
+		last, err := s.scanBatch(ctx, rel, from, func(key string, data []byte) error {
+			batch = append(batch, pair{key, data})
+			if len(batch) >= opts.batchSize() {
+				return errBatchFull
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("scan: %w", err)
+		}
+		if len(batch) == 0 {
+			return nil
+		}
+		for _, p := range batch {
+			if err := fn(p.key, p.data); errors.Is(err, blob.ErrStopListing) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			prog.Processed++
+			if progress != nil && prog.Processed%every == 0 {
+				progress(prog)
+			}
+		}
+		rel, from = ">", last // resume after the last stored key
+	}
+}
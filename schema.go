@@ -0,0 +1,291 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/creachadair/mds/value"
+)
+
+// schemaVersion is the current version of the schema of a KV table.
+// Tables created before versioning was introduced have version 0.
+const schemaVersion = 2
+
+// migrations[v] upgrades a KV table of d from schema version v to v+1.
+// Each migration must be idempotent, since a table created with the current
+// schema is also passed through them when it is first recorded.
+var migrations = []func(ctx context.Context, d *sqlDB, tx *sql.Tx, table string) error{
+	// Version 0 → 1: Tables created before checksums, deduplication, expiry,
+	// eviction, and creation times were supported lack the corresponding
+	// columns. Rows in such tables have no checksum, content reference,
+	// expiry, access, or creation time, and NULL is the correct value for
+	// each of these, so no backfill is needed.
+	func(ctx context.Context, _ *sqlDB, tx *sql.Tx, table string) error {
+		for _, col := range []string{"checksum", "ref", "expires_at", "accessed_at", "created_at"} {
+			decl := "INTEGER"
+			if col == "ref" {
+				decl = "BLOB"
+			}
+			if err := addColumn(ctx, tx, table, col, decl); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+
+	// Version 1 → 2: Each value records the ID of the codec that encoded it.
+	// The values of an older table were all encoded by the codec of the
+	// table, if it has its own (see KV.SetCompression), or else by the codec
+	// of the store, which is the one it must be opened with.
+	func(ctx context.Context, d *sqlDB, tx *sql.Tx, table string) error {
+		if err := addColumn(ctx, tx, table, "codec", "INTEGER"); err != nil {
+			return err
+		}
+		id := d.codecID
+		if tc, ok, err := d.readCodec(ctx, tx, table); err != nil {
+			return err
+		} else if ok {
+			id, _ = builtinCodecID(tc.codec)
+		}
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(`update %s set codec = $codec where codec is null`, quoteIdent(table)),
+			sql.Named("codec", id))
+		return err
+	},
+}
+
+// schemaTable returns the name of the table recording the schema versions of
+// the KV tables of d.
+func (d *sqlDB) schemaTable() string {
+	if d.table != "" {
+		return d.table + "_schema_version"
+	}
+	return "schema_version"
+}
+
+// initSchema creates the schema version table if it does not exist, and
+// reports an error if any table has a newer version than this package
+// supports.
+func (d *sqlDB) initSchema(ctx context.Context, tx *sql.Tx) error {
+	stab := quoteIdent(d.schemaTable())
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`create table if not exists %s (
+  name TEXT primary key,
+  version INTEGER not null
+) without rowid`, stab)); err != nil {
+		return err
+	}
+	// The codec column records the codec of a table that has its own (see
+	// KV.SetCompression), and is NULL otherwise.
+	if err := addColumn(ctx, tx, d.schemaTable(), "codec", "TEXT"); err != nil {
+		return err
+	}
+	return d.checkNewer(ctx, tx)
+}
+
+// checkNewer reports an error if any table has a newer schema version than
+// this package supports.
+func (d *sqlDB) checkNewer(ctx context.Context, tx *sql.Tx) error {
+	var name string
+	var v int
+	err := tx.QueryRowContext(ctx, fmt.Sprintf(`select name, version from %s where version > $version limit 1`, quoteIdent(d.schemaTable())),
+		sql.Named("version", schemaVersion)).Scan(&name, &v)
+	if err == nil {
+		return fmt.Errorf("table %q has schema version %d, newer than supported (%d)", name, v, schemaVersion)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	return nil
+}
+
+// checkSchema is used in place of migrate when d does not create or alter
+// tables (see Options.NoCreate). It reports an error if the schema table
+// does not exist or is out of date, or if any KV table must be upgraded.
+func (d *sqlDB) checkSchema(ctx context.Context) error {
+	if err := withTxErr(ctx, d, func(tx *sql.Tx) error {
+		if err := requireObject(ctx, tx, "main", "table", d.schemaTable()); err != nil {
+			return err
+		} else if err := requireColumn(ctx, tx, d.schemaTable(), "codec"); err != nil {
+			return err
+		} else if err := d.checkNewer(ctx, tx); err != nil {
+			return err
+		}
+		tables, err := d.kvTables(ctx, tx, fmt.Sprintf(`coalesce((select version from %s v where v.name = s.name), 0) < %d`,
+			quoteIdent(d.schemaTable()), schemaVersion))
+		if err != nil {
+			return err
+		} else if len(tables) != 0 {
+			return fmt.Errorf("table %q must be upgraded to schema version %d", tables[0], schemaVersion)
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("check schema: %w", err)
+	}
+	return nil
+}
+
+// checkTable is used in place of initTable when d does not create or alter
+// tables (see Options.NoCreate). It reports an error if table, or another
+// table or index it requires, does not exist, or if table must be upgraded.
+func (d *sqlDB) checkTable(ctx context.Context, tx *sql.Tx, table string) error {
+	objs := [][2]string{{"table", table}, {"index", table + "_expires"}}
+	if d.covering {
+		objs = append(objs, [2]string{"index", table + "_stat"})
+	}
+	if d.evicting() {
+		objs = append(objs, [2]string{"index", table + "_accessed"})
+	}
+	for _, obj := range objs {
+		if err := requireObject(ctx, tx, "main", obj[0], obj[1]); err != nil {
+			return err
+		}
+	}
+	if err := requireObject(ctx, tx, value.Cond(d.overflow > 0, "overflow", "main"), "table", d.contentTable()); err != nil {
+		return err
+	}
+	if v, err := d.tableVersion(ctx, tx, table); err != nil {
+		return err
+	} else if v != schemaVersion {
+		return fmt.Errorf("table %q has schema version %d, not %d", table, v, schemaVersion)
+	}
+	return nil
+}
+
+// requireObject reports an error wrapping [ErrMissingSchema] if the named
+// table or index (according to typ) does not exist in the given database.
+func requireObject(ctx context.Context, tx *sql.Tx, db, typ, name string) error {
+	var n int
+	if err := tx.QueryRowContext(ctx, fmt.Sprintf(`select count(*) from %s.sqlite_schema where type = $type and name = $name`, db),
+		sql.Named("type", typ), sql.Named("name", name)).Scan(&n); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("%s %q: %w", typ, name, ErrMissingSchema)
+	}
+	return nil
+}
+
+// requireColumn reports an error wrapping [ErrMissingSchema] if table does
+// not have a column with the given name.
+func requireColumn(ctx context.Context, tx *sql.Tx, table, name string) error {
+	var n int
+	if err := tx.QueryRowContext(ctx, `select count(*) from pragma_table_info($table) where name = $name`,
+		sql.Named("table", table), sql.Named("name", name)).Scan(&n); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("column %q of table %q: %w", name, table, ErrMissingSchema)
+	}
+	return nil
+}
+
+// migrate upgrades the existing KV tables of d to the current schema version.
+// Each step of each upgrade runs in its own transaction, and records the new
+// version of the table when it commits, so an interrupted upgrade resumes
+// where it stopped the next time the database is opened.
+func (d *sqlDB) migrate(ctx context.Context) error {
+	var tables []string
+	if err := withTxErr(ctx, d, func(tx *sql.Tx) error {
+		if err := d.initSchema(ctx, tx); err != nil {
+			return err
+		}
+
+		// Tables not yet recorded in the schema table predate versioning.
+		var err error
+		tables, err = d.kvTables(ctx, tx, fmt.Sprintf(`coalesce((select version from %s v where v.name = s.name), 0) < %d`,
+			quoteIdent(d.schemaTable()), schemaVersion))
+		return err
+	}); err != nil {
+		return fmt.Errorf("migrate: %w", err)
+	}
+
+	for _, table := range tables {
+		for {
+			var done bool
+			if err := withTxErr(ctx, d, func(tx *sql.Tx) error {
+				v, err := d.tableVersion(ctx, tx, table)
+				if err != nil || v >= schemaVersion {
+					done = true
+					return err
+				}
+				if err := migrations[v](ctx, d, tx, table); err != nil {
+					return err
+				}
+				done = false
+				return d.setTableVersion(ctx, tx, table, v+1)
+			}); err != nil {
+				return fmt.Errorf("migrate table %q: %w", table, err)
+			} else if done {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// kvTables returns the names of the KV tables of d within tx, in order, that
+// also satisfy cond, an SQL condition on the sqlite_schema row aliased as s,
+// with the given named arguments. KV tables are distinguished by their names
+// and their vsize column. The names are compared exactly, not with LIKE,
+// which ignores case and treats "_" in the table name as a wildcard.
+func (d *sqlDB) kvTables(ctx context.Context, tx *sql.Tx, cond string, args ...any) ([]string, error) {
+	// The name of a KV table is its prefix followed by a hex keyspace name,
+	// which does not contain "_".
+	pattern := `substr(s.name, 1, length($prefix)) = $prefix
+    and instr(substr(s.name, length($prefix) + 1), '_') = 0`
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf(`select s.name from sqlite_schema s
+  where s.type = 'table' and %s and (%s)
+    and exists (select 1 from pragma_table_info(s.name) where name = 'vsize')
+  order by s.name`, pattern, cond), append(args, sql.Named("prefix", value.Cond(d.table == "", "", d.table+"_")))...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+// upgradeTable upgrades table to the current schema version within tx.
+func (d *sqlDB) upgradeTable(ctx context.Context, tx *sql.Tx, table string) error {
+	v, err := d.tableVersion(ctx, tx, table)
+	if err != nil {
+		return err
+	} else if v > schemaVersion {
+		return fmt.Errorf("table %q has schema version %d, newer than supported (%d)", table, v, schemaVersion)
+	} else if v == schemaVersion {
+		return nil
+	}
+	for ; v < schemaVersion; v++ {
+		if err := migrations[v](ctx, d, tx, table); err != nil {
+			return err
+		}
+	}
+	return d.setTableVersion(ctx, tx, table, v)
+}
+
+// tableVersion reports the recorded schema version of table, or 0 if none is
+// recorded.
+func (d *sqlDB) tableVersion(ctx context.Context, tx *sql.Tx, table string) (int, error) {
+	var v int
+	err := tx.QueryRowContext(ctx, fmt.Sprintf(`select version from %s where name = $name`, quoteIdent(d.schemaTable())),
+		sql.Named("name", table)).Scan(&v)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return v, err
+}
+
+// setTableVersion records the schema version of table.
+func (d *sqlDB) setTableVersion(ctx context.Context, tx *sql.Tx, table string, v int) error {
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`insert into %s (name, version) values ($name, $version)
+  on conflict (name) do update set version = excluded.version`,
+		quoteIdent(d.schemaTable())), sql.Named("name", table), sql.Named("version", v))
+	return err
+}
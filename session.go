@@ -0,0 +1,153 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// sessioner is the interface to a driver connection that supports the SQLite
+// session extension. The default driver does not currently provide it.
+type sessioner interface {
+	// StartSession begins recording the changes made to all the tables of
+	// the main database on the connection.
+	StartSession() error
+
+	// EndSession stops recording changes, and returns a changeset that
+	// describes the changes recorded since StartSession.
+	EndSession() ([]byte, error)
+
+	// ApplyChangeset applies the changes described by cs to the database.
+	ApplyChangeset(cs []byte) error
+}
+
+// errNoSessions is reported by the changeset methods when the driver does not
+// support the session extension.
+var errNoSessions = fmt.Errorf("driver does not support the SQLite session extension: %w", errors.ErrUnsupported)
+
+// CaptureChanges calls fn with a [KVTx] as [KV.WithTx] does, and if fn
+// succeeds, returns a changeset describing the changes made by fn. The
+// changeset can be applied to another store with [Store.ApplyChangeset], to
+// replicate the changes without copying the whole store.
+//
+// The changeset includes all the changes made in the transaction, including
+// changes to shared content (see [Options.Dedup]), so the store receiving it
+// should be opened with the same options as s. Unlike WithTx, the
+// transaction is not retried if the database is busy.
+//
+// CaptureChanges requires a driver that supports the SQLite session
+// extension, which the default driver does not; otherwise it reports an
+// error wrapping [errors.ErrUnsupported] without calling fn.
+func (s KV) CaptureChanges(ctx context.Context, fn func(tx *KVTx) error) (_ []byte, err error) {
+	ctx, op := s.db.begin(ctx, "capturechanges", s.tableName)
+	defer op.end(&err)
+	var changes []change
+	defer s.db.changed(&changes, &err) // after the lock is released
+
+	s.db.txmu.Lock()
+	defer s.db.txmu.Unlock()
+
+	conn, err := s.db.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("capture changes: %w", s.db.classifyError(err))
+	}
+	defer conn.Close()
+
+	var sc sessioner
+	if err := conn.Raw(func(dc any) error {
+		var ok bool
+		if sc, ok = dc.(sessioner); !ok {
+			return errNoSessions
+		}
+		return sc.StartSession()
+	}); err != nil {
+		return nil, fmt.Errorf("capture changes: %w", err)
+	}
+
+	var cs []byte
+	endSession := func(any) (err error) {
+		cs, err = sc.EndSession()
+		return err
+	}
+
+	kt := &KVTx{s: s}
+	defer func() { kt.tx = nil }() // invalidate after return
+	if err := s.db.classifyError(s.captureTx(ctx, conn, kt, fn)); err != nil {
+		conn.Raw(endSession) // discard the recorded changes
+		return nil, err
+	}
+	s.db.addLen(s.tableName, kt.added)
+	changes = kt.changes
+
+	if err := conn.Raw(endSession); err != nil {
+		return nil, fmt.Errorf("capture changes: %w", err)
+	}
+	return cs, nil
+}
+
+// captureTx calls fn with kt in a transaction on conn, and commits the
+// transaction if fn succeeds.
+func (s KV) captureTx(ctx context.Context, conn *sql.Conn, kt *KVTx, fn func(tx *KVTx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	kt.tx = tx
+	if err := fn(kt); err != nil {
+		return err
+	}
+	evicted, err := s.evictTx(ctx, tx)
+	if err != nil {
+		return err
+	}
+	kt.added -= int64(len(evicted))
+	kt.changes = withDeletes(kt.changes, evicted)
+	return tx.Commit()
+}
+
+// ApplyChangeset applies a changeset returned by [KV.CaptureChanges] to the
+// database of s. It requires a driver that supports the SQLite session
+// extension, which the default driver does not; otherwise it reports an
+// error wrapping [errors.ErrUnsupported]. The changes it applies are not
+// reported to [Options.OnChange].
+func (s Store) ApplyChangeset(ctx context.Context, cs []byte) (err error) {
+	ctx, op := s.begin(ctx, "applychangeset", "")
+	defer op.end(&err)
+
+	s.txmu.Lock()
+	defer s.txmu.Unlock()
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("apply changeset: %w", s.classifyError(err))
+	}
+	err = conn.Raw(func(dc any) error {
+		sc, ok := dc.(sessioner)
+		if !ok {
+			return errNoSessions
+		}
+		return sc.ApplyChangeset(cs)
+	})
+	conn.Close() // release it before the recount, which uses the pool
+	if err != nil {
+		return fmt.Errorf("apply changeset: %w", s.classifyError(err))
+	}
+
+	// The changeset may have changed any table, so recount the cached
+	// lengths, if enabled.
+	if err := withTxErr(ctx, s.sqlDB, func(tx *sql.Tx) error {
+		for table := range s.lens {
+			if err := s.countRows(ctx, tx, table); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return fmt.Errorf("apply changeset: %w", err)
+	}
+	return nil
+}
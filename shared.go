@@ -0,0 +1,63 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"database/sql"
+	"sync"
+)
+
+// sharedDBs is a registry of database handles shared by stores opened with
+// the Shared option, indexed by driver and URI.
+var sharedDBs struct {
+	sync.Mutex
+	m map[string]*sharedDB
+}
+
+type sharedDB struct {
+	db   *sql.DB
+	refs int
+}
+
+// openShared returns a shared database handle for uri with the specified
+// driver, opening it if necessary, and reports whether it was newly opened.
+// Each successful call must be matched by a call to releaseShared.
+func openShared(driverName, uri string, init []string, keepWAL bool) (_ *sql.DB, fresh bool, _ error) {
+	sharedDBs.Lock()
+	defer sharedDBs.Unlock()
+
+	key := driverName + "\x00" + uri
+	if s, ok := sharedDBs.m[key]; ok {
+		s.refs++
+		return s.db, false, nil
+	}
+	db, err := openDB(driverName, uri, init, keepWAL)
+	if err != nil {
+		return nil, false, err
+	}
+	if sharedDBs.m == nil {
+		sharedDBs.m = make(map[string]*sharedDB)
+	}
+	sharedDBs.m[key] = &sharedDB{db: db, refs: 1}
+	return db, true, nil
+}
+
+// releaseShared releases a reference to a shared database handle, and reports
+// whether it was the last reference. If so, the caller is responsible for
+// closing db.
+func releaseShared(db *sql.DB) bool {
+	sharedDBs.Lock()
+	defer sharedDBs.Unlock()
+
+	for key, s := range sharedDBs.m {
+		if s.db == db {
+			s.refs--
+			if s.refs > 0 {
+				return false
+			}
+			delete(sharedDBs.m, key)
+			return true
+		}
+	}
+	return true // not shared after all
+}
@@ -0,0 +1,132 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/creachadair/ffs/blob"
+)
+
+// Snapshot returns a read-only view of the contents of s as of the time of
+// the call, which remains consistent until it is released, however s is
+// modified in the meantime. The caller must call Release when the snapshot
+// is no longer needed.
+//
+// A snapshot holds a read transaction open on a connection of its own, which
+// is added to the connection pool of the store until the snapshot is
+// released, so that other operations are not starved. If the database uses
+// write-ahead logging (journal_mode=WAL), the snapshot does not block
+// writers; otherwise, writes to the database fail as busy until the snapshot
+// is released. While a snapshot is open, the write-ahead log cannot be
+// checkpointed past the point at which the snapshot began, so snapshots
+// should not be held longer than necessary. Snapshots cannot be used with
+// LockingMode EXCLUSIVE, since only one connection can access the database.
+func (s KV) Snapshot(ctx context.Context) (_ *Snapshot, err error) {
+	ctx, op := s.db.begin(ctx, "snapshot", s.tableName)
+	defer op.end(&err)
+
+	s.db.txmu.RLock()
+	defer s.db.txmu.RUnlock()
+
+	// The transaction outlives the call, so it must not end with ctx.
+	growPool(s.db.db, 1)
+	tx, err := s.db.db.BeginTx(context.WithoutCancel(ctx), nil)
+	if err != nil {
+		growPool(s.db.db, -1)
+		return nil, fmt.Errorf("snapshot: %w", s.db.classifyError(err))
+	}
+
+	// A deferred transaction does not begin reading until its first query,
+	// so read something to fix the view.
+	var n int
+	if err := tx.QueryRowContext(ctx, `select count(*) from sqlite_schema`).Scan(&n); err != nil {
+		tx.Rollback()
+		growPool(s.db.db, -1)
+		return nil, fmt.Errorf("snapshot: %w", s.db.classifyError(err))
+	}
+	return &Snapshot{kv: s, tx: tx}, nil
+}
+
+// poolMu serializes changes to the size of connection pools by growPool.
+var poolMu sync.Mutex
+
+// growPool adjusts the maximum number of open connections of db by delta,
+// if it is limited.
+func growPool(db *sql.DB, delta int) {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+	if n := db.Stats().MaxOpenConnections; n > 0 {
+		db.SetMaxOpenConns(n + delta)
+	}
+}
+
+// A Snapshot is a read-only view of a [KV] at a point in time. It implements
+// the [blob.KV] interface, but its Put and Delete methods report
+// [ErrReadOnly]. See [KV.Snapshot].
+type Snapshot struct {
+	kv   KV
+	tx   *sql.Tx
+	once sync.Once
+}
+
+// Release ends the snapshot and releases its resources. After Release, the
+// methods of s report errors. It is safe to call Release more than once.
+func (s *Snapshot) Release() error {
+	err := s.tx.Rollback()
+	if errors.Is(err, sql.ErrTxDone) {
+		err = nil
+	}
+	s.once.Do(func() { growPool(s.kv.db.db, -1) })
+	if err != nil {
+		return fmt.Errorf("release snapshot: %w", err)
+	}
+	return nil
+}
+
+// Get implements part of [blob.KV].
+func (s *Snapshot) Get(ctx context.Context, key string) ([]byte, error) {
+	s.kv.db.txmu.RLock()
+	defer s.kv.db.txmu.RUnlock()
+	return s.kv.getTx(ctx, s.tx, key)
+}
+
+// Stat implements part of [blob.KV].
+func (s *Snapshot) Stat(ctx context.Context, keys ...string) (blob.StatMap, error) {
+	s.kv.db.txmu.RLock()
+	defer s.kv.db.txmu.RUnlock()
+	return s.kv.statTx(ctx, s.tx, keys)
+}
+
+// List implements part of [blob.KV].
+func (s *Snapshot) List(ctx context.Context, start string, f func(string) error) error {
+	s.kv.db.txmu.RLock()
+	defer s.kv.db.txmu.RUnlock()
+	return s.kv.listTx(ctx, s.tx, start, f)
+}
+
+// Len implements part of [blob.KV]. Unlike [KV.Len], it always counts the
+// keys in the snapshot, even if the FastLen option is set.
+func (s *Snapshot) Len(ctx context.Context) (int64, error) {
+	s.kv.db.txmu.RLock()
+	defer s.kv.db.txmu.RUnlock()
+	var n int64
+	if err := s.tx.QueryRowContext(ctx, fmt.Sprintf(`select count(*) from %s`, s.kv.table())).Scan(&n); err != nil {
+		return 0, fmt.Errorf("len: %w", err)
+	}
+	return n, nil
+}
+
+// Put implements part of [blob.KV]. It always reports [ErrReadOnly].
+func (s *Snapshot) Put(ctx context.Context, opts blob.PutOptions) error {
+	return fmt.Errorf("put: snapshot: %w", ErrReadOnly)
+}
+
+// Delete implements part of [blob.KV]. It always reports [ErrReadOnly].
+func (s *Snapshot) Delete(ctx context.Context, key string) error {
+	return fmt.Errorf("delete: snapshot: %w", ErrReadOnly)
+}
@@ -4,31 +4,48 @@
 package sqlitestore
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"database/sql"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
+	"expvar"
 	"fmt"
+	"hash/crc32"
+	"io/fs"
+	"log/slog"
 	"net/url"
+	"os"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/creachadair/ffs/blob"
 	"github.com/creachadair/ffs/storage/dbkey"
 	"github.com/creachadair/mds/value"
-	"github.com/golang/snappy"
-	"modernc.org/sqlite"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Opener constructs a sqlitestore from a SQLite URI, for use with the store
-// package. To specify the table name, prefix addr with "tablename@".
+// package.
 //
 // If poolsize=n is set, it is used to set the pool size.
-// If compress=v is set, it is used to enable/disable compression (default true).
+// If compress=v is set, it selects the compression codec by name ("snappy",
+// "gzip", or "none"); as a special case, true selects the default codec and
+// false disables compression (default snappy).
+// If table=name is set, it is used as the base table name (default none).
+// If keys=enc is set, it selects the key encoding ("hex", "raw", or "text").
+// If mmapsize=n or cachesize=n is set, it sets the corresponding option.
 // Other query parameters are passed to SQLite.
-func Opener(_ context.Context, addr string) (blob.StoreCloser, error) {
+func Opener(ctx context.Context, addr string) (blob.StoreCloser, error) {
 	var opts Options
 
 	// Extract and remove query parameters specific to the store.
@@ -45,21 +62,82 @@ func Opener(_ context.Context, addr string) (blob.StoreCloser, error) {
 			}
 			delete(q, "poolsize")
 		}
+		if ms := q.Get("mmapsize"); ms != "" {
+			opts.MMapSize, err = strconv.ParseInt(ms, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mmapsize: %w", err)
+			}
+			delete(q, "mmapsize")
+		}
+		if cs := q.Get("cachesize"); cs != "" {
+			opts.CacheSize, err = strconv.Atoi(cs)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cachesize: %w", err)
+			}
+			delete(q, "cachesize")
+		}
 		if c := q.Get("compress"); c != "" {
-			v, err := strconv.ParseBool(c)
+			codec, err := parseCompress(c)
 			if err != nil {
-				return nil, fmt.Errorf("invalid compress: %w", err)
+				return nil, err
 			}
-			opts.Uncompressed = !v
+			opts.Codec = codec
 			delete(q, "compress")
 		}
+		if k := q.Get("keys"); k != "" {
+			switch k {
+			case "hex":
+				opts.KeyEncoding = HexKeys
+			case "raw":
+				opts.KeyEncoding = RawKeys
+			case "text":
+				opts.KeyEncoding = TextKeys
+			default:
+				return nil, fmt.Errorf("invalid key encoding %q", k)
+			}
+			delete(q, "keys")
+		}
+		if t := q.Get("table"); t != "" {
+			if !isSafeIdent(t) {
+				return nil, fmt.Errorf("invalid table name %q", t)
+			}
+			opts.Table = t
+			delete(q, "table")
+		}
 		addr = base
 		if r := q.Encode(); r != "" {
 			addr += "?" + r
 		}
 	}
 
-	return New(addr, &opts)
+	return NewContext(ctx, addr, &opts)
+}
+
+// ErrCorruptValue is reported when a stored value cannot be decoded.
+// Errors with this cause have concrete type [*blob.KeyError] identifying the
+// key of the affected value.
+var ErrCorruptValue = errors.New("corrupt stored value")
+
+// ErrInvalidKey is reported when a key cannot be stored with the key encoding
+// of the store.  Errors with this cause have concrete type [*blob.KeyError]
+// identifying the key.
+var ErrInvalidKey = errors.New("invalid key")
+
+// ErrMissingSchema is reported when the NoCreate option is set and a table
+// or index the store requires does not exist (see [Options]).
+var ErrMissingSchema = errors.New("missing table or index")
+
+// parseCompress parses the value of a compress= query parameter, which may be
+// either a codec name or a boolean, and returns the codec it selects.
+func parseCompress(v string) (Codec, error) {
+	if c, ok := namedCodecs[strings.ToLower(v)]; ok {
+		return c, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid compress: unknown codec %q", v)
+	}
+	return value.Cond(b, SnappyCodec, NoCodec), nil
 }
 
 type Store struct {
@@ -67,274 +145,2854 @@ type Store struct {
 }
 
 // Close implements part of the [blob.StoreCloser] interface.
+//
+// Close first closes all the open [BufferedKV] values of the database, which
+// writes their buffered values to the store. If writing fails, those values
+// are lost, and Close reports the error.
+//
+// Before closing the database, Close updates the query planner statistics
+// and vacuums the database (see [Options.VacuumOnClose]). If ctx ends before
+// these steps are done, they are interrupted and the database is closed
+// anyway; in that case Close reports an error wrapping the error from ctx.
+//
+// Close is idempotent: Only the first call closes the database, and later
+// calls (including calls on substores of s) do nothing and report nil.
 func (s Store) Close(ctx context.Context) error {
+	s.stopMaintenance()
+
+	// Write buffered values before taking the lock, since that requires it.
+	berr := s.closeBuffers(ctx)
+
 	s.txmu.Lock()
 	defer s.txmu.Unlock()
+	if s.closed {
+		return berr
+	}
+	s.closed = true
 
-	// Attempt to vacuum the database before closing.
-	_, verr := s.db.Exec(`vacuum`)
+	// A shared database is closed only when its last user closes it.
+	serr := errors.Join(berr, s.stmts.closeAll())
+	if s.shared && !releaseShared(s.db) {
+		return serr
+	}
+
+	// Attempt to update the query planner statistics and (unless disabled)
+	// vacuum the database before closing.
+	var oerr, verr error
+	if !s.readOnly {
+		_, oerr = s.db.ExecContext(ctx, `pragma optimize`)
+		if !s.noVacuum && ctx.Err() == nil {
+			_, verr = s.db.ExecContext(ctx, `vacuum`)
+		}
+	}
 
-	// Even if that fails, however, make sure the pool gets cleaned up.
+	// Even if those fail, however, make sure the pool gets cleaned up.
 	cerr := s.db.Close()
-	return errors.Join(verr, cerr)
+	if err := ctx.Err(); err != nil {
+		// The other errors are likely just the effects of the interruption.
+		return errors.Join(fmt.Errorf("close: %w", err), serr, cerr)
+	}
+	return errors.Join(oerr, verr, serr, cerr)
 }
 
-type dbMonitor struct {
-	// These fields are read-only after initialization.
-	tableName dbkey.Prefix
-	compress  bool
+// Analyze updates the query planner statistics for all the tables in the
+// database that would benefit from it, as "pragma optimize" does when a
+// database has been open for a while. Other operations on the store wait
+// while it runs.
+func (s Store) Analyze(ctx context.Context) (err error) {
+	ctx, op := s.begin(ctx, "analyze", "")
+	defer op.end(&err)
+
+	s.txmu.Lock()
+	defer s.txmu.Unlock()
 
-	txmu sync.RWMutex // ex: write db, sh: read db
-	db   *sql.DB
+	// The 0x10000 bit checks all tables, not only those queried on the
+	// connection; 0x02 is the default analysis mask.
+	if _, err := s.db.ExecContext(ctx, `pragma optimize = 0x10002`); err != nil {
+		return fmt.Errorf("analyze: %w", err)
+	}
+	return nil
 }
 
-func (d *dbMonitor) KV(ctx context.Context, name string) (blob.KV, error) {
-	ktab := d.tableName.Keyspace(name).String() // hex-encoded
+// Checkpoint copies the contents of the write-ahead log into the database, if
+// the database uses write-ahead logging. The mode is one of "PASSIVE" (the
+// default if empty), "FULL", "RESTART", or "TRUNCATE", as described for
+// "pragma wal_checkpoint"; a passive checkpoint does not wait for readers or
+// writers, and so may not copy the whole log. Other operations on the store
+// wait while it runs.
+func (s Store) Checkpoint(ctx context.Context, mode string) (err error) {
+	ctx, op := s.begin(ctx, "checkpoint", "")
+	defer op.end(&err)
 
-	d.txmu.Lock()
-	defer d.txmu.Unlock()
-	if err := withTxErr(ctx, d.db, func(tx *sql.Tx) error {
-		_, err := tx.ExecContext(ctx, fmt.Sprintf(`create table if not exists "%s" (
-  key BLOB unique not null,
-  value BLOB not null,
-  vsize INTEGER not null
-)`, ktab))
-		return err
-	}); err != nil {
-		return nil, err
+	mode = strings.ToUpper(cmp.Or(mode, "PASSIVE"))
+	if !slices.Contains([]string{"PASSIVE", "FULL", "RESTART", "TRUNCATE"}, mode) {
+		return fmt.Errorf("invalid checkpoint mode %q", mode)
 	}
-	return KV{db: d, tableName: ktab}, nil
+
+	s.txmu.Lock()
+	defer s.txmu.Unlock()
+
+	if _, err := s.db.ExecContext(ctx, fmt.Sprintf(`pragma wal_checkpoint(%s)`, mode)); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	return nil
 }
 
-func (d *dbMonitor) CAS(ctx context.Context, name string) (blob.CAS, error) {
-	kv, err := d.KV(ctx, name)
+// IncrementalVacuum reclaims up to the specified number of free pages from
+// the database, or all of them if pages <= 0. It has no effect unless the
+// database uses incremental auto-vacuum (see [Options]).
+func (s Store) IncrementalVacuum(ctx context.Context, pages int) (err error) {
+	ctx, op := s.begin(ctx, "vacuum", "")
+	defer op.end(&err)
+
+	s.txmu.Lock()
+	defer s.txmu.Unlock()
+
+	// The pragma frees one page for each step, so all its rows must be read.
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`pragma incremental_vacuum(%d)`, max(pages, 0)))
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("vacuum: %w", err)
 	}
-	return blob.CASFromKV(kv), nil
+	defer rows.Close()
+	for rows.Next() {
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	return nil
 }
 
-func (d *dbMonitor) Sub(ctx context.Context, name string) (blob.Store, error) {
-	return Store{dbMonitor: &dbMonitor{
-		tableName: d.tableName.Sub(name),
-		compress:  d.compress,
-		db:        d.db,
-	}}, nil
+// DBStats are storage statistics for a database. See [Store.DatabaseStats].
+type DBStats struct {
+	PageSize  int64 // the size of a database page in bytes
+	PageCount int64 // the total number of pages in the database file
+	FreePages int64 // the number of unused pages on the freelist
+
+	Size        int64 // the size of the database file in bytes
+	Reclaimable int64 // the number of bytes a vacuum would reclaim
 }
 
-// A KV implements the [blob.KV] interface using a SQLite3 database.
-type KV struct {
-	db        *dbMonitor
-	tableName string
+// DatabaseStats reports storage statistics for the main database. The ratio
+// of Reclaimable to Size can be used to decide when a vacuum is worthwhile.
+func (s Store) DatabaseStats(ctx context.Context) (_ DBStats, err error) {
+	ctx, op := s.begin(ctx, "dbstats", "")
+	defer op.end(&err)
+
+	s.txmu.RLock()
+	defer s.txmu.RUnlock()
+
+	var st DBStats
+	if err := withTxErr(ctx, s.sqlDB, func(tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx, `select page_size, page_count, freelist_count
+  from pragma_page_size, pragma_page_count, pragma_freelist_count`).Scan(&st.PageSize, &st.PageCount, &st.FreePages)
+	}); err != nil {
+		return DBStats{}, fmt.Errorf("database stats: %w", err)
+	}
+	st.Size = st.PageSize * st.PageCount
+	st.Reclaimable = st.PageSize * st.FreePages
+	return st, nil
 }
 
-// New creates or opens a store at the specified database.
-func New(uri string, opts *Options) (Store, error) {
-	db, err := sql.Open(opts.driverName(), uri)
+// FilePath returns the path of the main database file, as resolved by SQLite
+// when the store was opened. It returns "" if the database has no file, as
+// for an in-memory or temporary database, or one opened with [NewFS].
+func (s Store) FilePath() string { return s.path }
+
+// FileSize returns the total size in bytes of the files of the main database:
+// the database file and, if they exist, its write-ahead log and shared-memory
+// index. Unlike the Size reported by [Store.DatabaseStats], this is the space
+// the database occupies on disk, including the log. It returns 0 if the
+// database has no file (see [Store.FilePath]).
+func (s Store) FileSize() (int64, error) {
+	if s.path == "" {
+		return 0, nil
+	}
+	var total int64
+	for i, name := range []string{s.path, s.path + "-wal", s.path + "-shm"} {
+		fi, err := os.Stat(name)
+		if i > 0 && errors.Is(err, fs.ErrNotExist) {
+			continue // companion files exist only while needed
+		} else if err != nil {
+			return 0, fmt.Errorf("file size: %w", err)
+		}
+		total += fi.Size()
+	}
+	return total, nil
+}
+
+// CompactEstimate estimates the number of bytes a vacuum would reclaim from
+// the main database, without modifying it. The estimate includes the free
+// pages of the database, and the unused space within pages that are in use,
+// in whole pages, since a vacuum repacks the contents of partly-full pages.
+// If the SQLite build does not provide the dbstat table, only the free pages
+// are counted.
+//
+// CompactEstimate reads every page of the database, so it may take a while
+// for a large database; it blocks writes to the store while it runs.
+func (s Store) CompactEstimate(ctx context.Context) (_ int64, err error) {
+	ctx, op := s.begin(ctx, "compactestimate", "")
+	defer op.end(&err)
+
+	st, err := s.DatabaseStats(ctx)
 	if err != nil {
-		return Store{}, err
+		return 0, fmt.Errorf("compact estimate: %w", err)
 	}
-	if size := opts.poolSize(); size > 0 {
-		db.SetMaxOpenConns(size)
+
+	s.txmu.RLock()
+	defer s.txmu.RUnlock()
+
+	var unused sql.NullInt64
+	if err := withTxErr(ctx, s.sqlDB, func(tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx, `select sum(unused) from dbstat where name != 'sqlite_schema'`).Scan(&unused)
+	}); err != nil && !strings.Contains(err.Error(), "no such table") {
+		return 0, fmt.Errorf("compact estimate: %w", err)
 	}
-	return Store{dbMonitor: &dbMonitor{
-		db:       db,
-		compress: opts == nil || !opts.Uncompressed,
-	}}, nil
+	return st.Reclaimable + (unused.Int64/st.PageSize)*st.PageSize, nil
 }
 
-// Options are options for constructing a [KV].  A nil *Options is ready for
-// use and provides default values as described.
-type Options struct {
-	// The name of the SQL driver to use, default "sqlite".
-	Driver string
+// Ping reports whether the database is reachable and able to execute a
+// trivial query. It is cheap enough to use as a frequently-polled health check.
+func (s Store) Ping(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+	var v int
+	if err := s.db.QueryRowContext(ctx, `select 1`).Scan(&v); err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+	return nil
+}
 
-	// The number of connections to allow in the pool. If <= 0, use runtime.NumCPU.
-	PoolSize int
+type dbMonitor struct {
+	tableName dbkey.Prefix // read-only after initialization
+	*sqlDB
+}
 
-	// If true, store blobs without compression; by default blob data are
-	// compressed with Snappy.
-	Uncompressed bool
+// A sqlDB holds the database handle and settings shared by a store and all
+// its substores.
+type sqlDB struct {
+	// These fields are read-only after initialization.
+	table        string // base table name, may be empty
+	codec        Codec
+	codecID      byte         // the ID recorded for values encoded with codec
+	metrics      Metrics      // may be nil
+	vars         *storeVars   // may be nil
+	tracer       trace.Tracer // may be nil
+	traceKeys    bool
+	slow         time.Duration // if > 0, log operations at least this slow
+	timeout      time.Duration // if > 0, the default timeout for operations
+	logger       *slog.Logger
+	logKeys      bool
+	fastLen      bool
+	verify       bool
+	dedup        bool
+	overflow     int         // if > 0, the minimum size of an overflow value
+	maxKeys      int64       // if > 0, evict keys beyond this many
+	maxBytes     int64       // if > 0, evict keys beyond this many bytes of values
+	touch        bool        // update access times on Get
+	maint        *maintainer // nil if background maintenance is disabled
+	noVacuum     bool        // do not vacuum on close
+	noCheckpoint bool        // do not checkpoint the WAL during maintenance
+	noCreate     bool        // do not create or alter tables and indexes
+	readOnly     bool        // the database cannot be written (see NewFS)
+	path         string      // the path of the database file, or "" if none
+	covering     bool        // maintain a covering index for Stat
+	keys         KeyEncoding
+	binaryKeys   bool     // with TextKeys, store invalid UTF-8 keys as BLOBs
+	keyCodec     KeyCodec // if set, overrides keys
+	withoutRowID bool
+	shared       bool // db is shared with other stores (see openShared)
+	retries      int  // retry limit for busy transactions
+	coder        ErrorCoder
+	retryDelay   time.Duration
+	onChange     func(op, key string) // if set, called after each write
+
+	txmu  sync.RWMutex // ex: write db, sh: read db
+	db    *sql.DB
+	stmts *stmtCache
+	lens  map[string]int64 // table → row count, if fastLen; guarded by txmu
+
+	codecs map[string]tableCodec // table → codec, if set for the table; guarded by txmu
+
+	nsCodecs map[dbkey.Prefix]tableCodec // namespace → codec to record when opened
+
+	bufmu   sync.Mutex
+	buffers map[*BufferedKV]struct{} // open buffered KVs; guarded by bufmu
+
+	encbufs sync.Pool // *[]byte buffers for encoding values; see getBuf
+
+	closed bool // guarded by txmu
 }
 
-func (o *Options) driverName() string {
-	if o == nil || o.Driver == "" {
-		return "sqlite"
+// countRows counts the rows of the specified table, and caches the result.
+// The caller must hold d.txmu exclusively.
+func (d *sqlDB) countRows(ctx context.Context, tx *sql.Tx, table string) error {
+	var nr int64
+	if err := tx.QueryRowContext(ctx, fmt.Sprintf(`select count(*) from %s`, quoteIdent(table))).Scan(&nr); err != nil {
+		return err
 	}
-	return o.Driver
+	d.lens[table] = nr
+	return nil
 }
 
-func (o *Options) poolSize() int {
-	if o == nil || o.PoolSize <= 0 {
-		return runtime.NumCPU()
+// addLen adjusts the cached row count of table by delta, if it is enabled.
+// The caller must hold d.txmu exclusively.
+func (d *sqlDB) addLen(table string, delta int64) {
+	if d.fastLen {
+		d.lens[table] += delta
 	}
-	return o.PoolSize
 }
 
-func encodeKey(key string) string { return hex.EncodeToString([]byte(key)) }
+// begin marks the start of operation name on the specified table, and returns
+// a context for the operation along with an op to track it.  The caller must
+// call the end method of the op when the operation is complete.  If no
+// metrics, expvar counters, tracing, slow-operation logging, or default
+// timeout are enabled, begin returns ctx unchanged and a nil op.
+func (d *sqlDB) begin(ctx context.Context, name, table string) (context.Context, *op) {
+	if d.metrics == nil && d.vars == nil && d.tracer == nil && d.slow == 0 && d.timeout == 0 {
+		return ctx, nil
+	}
+	o := &op{d: d, name: name, table: table, start: time.Now()}
+	if _, ok := ctx.Deadline(); !ok && d.timeout > 0 {
+		ctx, o.cancel = context.WithTimeout(ctx, d.timeout)
+	}
+	if d.tracer != nil {
+		ctx, o.span = d.tracer.Start(ctx, "sqlitestore."+name,
+			trace.WithAttributes(attribute.String("sqlitestore.table", table)))
+	}
+	return ctx, o
+}
+
+// An op tracks the metrics and tracing state for a single operation.
+// A nil *op is valid and its methods do nothing.
+type op struct {
+	d     *sqlDB
+	name  string
+	table string
+	start time.Time
+	key   string
+	size  int
+	span  trace.Span // nil if tracing is disabled
 
-func decodeKey(ekey []byte) string {
-	n, err := hex.Decode(ekey, ekey)
-	if err != nil {
-		panic("invalid key")
+	cancel context.CancelFunc // nil unless a default timeout was applied
+}
+
+// setKey records the key affected by o.
+func (o *op) setKey(key string) {
+	if o == nil {
+		return
+	}
+	o.key = key
+	if o.span != nil && o.d.traceKeys {
+		o.span.SetAttributes(attribute.String("sqlitestore.key", key))
 	}
-	return string(ekey[:n])
 }
 
-func (s KV) encodeBlob(data []byte) []byte {
-	if s.db.compress {
-		return snappy.Encode(nil, data)
+// setSize records the size in bytes of the value affected by o.
+func (o *op) setSize(n int) {
+	if o == nil {
+		return
+	}
+	o.size = n
+	if o.span != nil {
+		o.span.SetAttributes(attribute.Int("sqlitestore.size", n))
 	}
-	return data
 }
 
-func (s *KV) decodeBlob(data []byte) ([]byte, error) {
-	if s.db.compress {
-		return snappy.Decode(nil, data)
+// A change is a write to a key, as reported to the OnChange hook.
+type change struct{ op, key string }
+
+// withDeletes returns cs with a "delete" change appended for each of keys.
+func withDeletes(cs []change, keys []string) []change {
+	for _, key := range keys {
+		cs = append(cs, change{"delete", key})
 	}
-	return data, nil
+	return cs
 }
 
-// Get implements part of [blob.KV].
-func (s KV) Get(ctx context.Context, key string) ([]byte, error) {
-	s.db.txmu.RLock()
-	defer s.db.txmu.RUnlock()
+// changed calls the OnChange hook of d, if it has one, with each of the
+// changes in *cs in order, unless the operation failed with *errp.
+func (d *sqlDB) changed(cs *[]change, errp *error) {
+	if d.onChange == nil || *errp != nil {
+		return
+	}
+	for _, c := range *cs {
+		d.onChange(c.op, c.key)
+	}
+}
 
-	query := fmt.Sprintf(`select value from "%s" where key = $key`, s.tableName)
-	return withTxValue(ctx, s.db.db, func(tx *sql.Tx) ([]byte, error) {
-		row := tx.QueryRowContext(ctx, query, sql.Named("key", encodeKey(key)))
-		var data []byte
-		if err := row.Scan(&data); errors.Is(err, sql.ErrNoRows) {
-			return nil, blob.KeyNotFound(key)
-		} else if err != nil {
-			return nil, fmt.Errorf("get: %w", err)
+// end marks the completion of o with the error value in *errp.
+func (o *op) end(errp *error) {
+	if o == nil {
+		return
+	}
+	if o.cancel != nil {
+		o.cancel()
+	}
+	err := *errp
+	elapsed := time.Since(o.start)
+	if o.d.metrics != nil {
+		o.d.metrics.ObserveOp(o.name, o.table, elapsed, err)
+	}
+	if o.d.vars != nil {
+		o.d.vars.observe(o.name, o.size, err)
+	}
+	if o.d.slow > 0 && elapsed >= o.d.slow {
+		args := []any{"op", o.name, "table", o.table, "elapsed", elapsed}
+		if o.d.logKeys && o.key != "" {
+			args = append(args, "key", o.key)
 		}
-		return s.decodeBlob(data)
-	})
+		if err != nil {
+			args = append(args, "error", err)
+		}
+		o.d.logger.Warn("slow sqlitestore operation", args...)
+	}
+	if o.span != nil {
+		if err != nil {
+			o.span.RecordError(err)
+			o.span.SetStatus(codes.Error, err.Error())
+		}
+		o.span.End()
+	}
 }
 
-// Stat implements part of [blob.KV].
-func (s KV) Stat(ctx context.Context, keys ...string) (blob.StatMap, error) {
-	s.db.txmu.RLock()
-	defer s.db.txmu.RUnlock()
+func (d *dbMonitor) KV(ctx context.Context, name string) (blob.KV, error) {
+	ns := d.tableName.Keyspace(name)
+	ktab := d.prefixTable(ns)
 
-	query := fmt.Sprintf(`select vsize from "%s" where key = $key`, s.tableName)
-	return withTxValue(ctx, s.db.db, func(tx *sql.Tx) (blob.StatMap, error) {
-		out := make(blob.StatMap)
-		for _, key := range keys {
-			row := tx.QueryRowContext(ctx, query, sql.Named("key", encodeKey(key)))
-			var size int64
-			if err := row.Scan(&size); errors.Is(err, sql.ErrNoRows) {
-				continue
-			} else if err != nil {
-				return nil, fmt.Errorf("stat: %w", err)
+	d.txmu.Lock()
+	defer d.txmu.Unlock()
+	if err := withTxErr(ctx, d.sqlDB, func(tx *sql.Tx) error {
+		initTable := d.initTable
+		if d.noCreate {
+			initTable = d.checkTable
+		}
+		if err := initTable(ctx, tx, ktab); err != nil {
+			return err
+		}
+		if tc, ok := d.nsCodecs[ns]; ok && !d.noCreate {
+			if err := d.recordCodec(ctx, tx, ktab, tc, false); err != nil {
+				return err
 			}
-			out[key] = blob.Stat{Size: size}
 		}
-		return out, nil
-	})
+		if err := d.loadCodec(ctx, tx, ktab); err != nil || !d.fastLen {
+			return err
+		} else if _, ok := d.lens[ktab]; ok {
+			return nil // already counted
+		}
+		return d.countRows(ctx, tx, ktab)
+	}); err != nil {
+		return nil, err
+	}
+	return KV{db: d, tableName: ktab}, nil
 }
 
-// Put implements part of [blob.KV].
-func (s KV) Put(ctx context.Context, opts blob.PutOptions) error {
-	s.db.txmu.Lock()
-	defer s.db.txmu.Unlock()
+// initTable creates the specified table if it does not exist, and upgrades
+// the schema of an existing table if necessary.
+func (d *sqlDB) initTable(ctx context.Context, tx *sql.Tx, table string) error {
+	keyDecl, suffix := "unique", ""
+	if d.withoutRowID {
+		keyDecl, suffix = "primary key", " without rowid"
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`create table if not exists %s (
+  key BLOB %s not null,
+  value BLOB not null,
+  vsize INTEGER not null,
+  checksum INTEGER,
+  ref BLOB,
+  expires_at INTEGER,
+  accessed_at INTEGER,
+  created_at INTEGER,
+  codec INTEGER
+)%s`, quoteIdent(table), keyDecl, suffix)); err != nil {
+		return err
+	}
 
-	op := value.Cond(opts.Replace, "replace", "insert")
-	stmt := fmt.Sprintf(`%s into "%s" (key, value, vsize) values ($key, $value, $vsize)`, op, s.tableName)
-	return withTxErr(ctx, s.db.db, func(tx *sql.Tx) error {
-		_, err := tx.ExecContext(ctx, stmt,
-			sql.Named("key", encodeKey(opts.Key)),
-			sql.Named("value", s.encodeBlob(opts.Data)),
-			sql.Named("vsize", len(opts.Data)),
-		)
-		const sqliteConstraintUnique = 2067
-		var serr *sqlite.Error
-		if errors.As(err, &serr) && serr.Code() == sqliteConstraintUnique {
-			return blob.KeyExists(opts.Key)
-		} else if err != nil {
-			return fmt.Errorf("put: %w", err)
+	// Bring a table created by an older version up to date (see schema.go).
+	if err := d.initSchema(ctx, tx); err != nil {
+		return err
+	}
+	if err := d.upgradeTable(ctx, tx, table); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`create index if not exists %s on %s (expires_at) where expires_at is not null`,
+		quoteIdent(table+"_expires"), quoteIdent(table))); err != nil {
+		return err
+	}
+	if d.covering {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`create index if not exists %s on %s (key, vsize, expires_at)`,
+			quoteIdent(table+"_stat"), quoteIdent(table))); err != nil {
+			return err
 		}
+	}
+	if d.evicting() {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(`create index if not exists %s on %s (accessed_at)`,
+			quoteIdent(table+"_accessed"), quoteIdent(table))); err != nil {
+			return err
+		}
+	}
+	return d.initContent(ctx, tx)
+}
+
+// addColumn adds a column with the given name and declaration to table, if
+// the table does not already have a column by that name.
+func addColumn(ctx context.Context, tx *sql.Tx, table, name, decl string) error {
+	var n int
+	if err := tx.QueryRowContext(ctx, `select count(*) from pragma_table_info($table) where name = $name`,
+		sql.Named("table", table), sql.Named("name", name)).Scan(&n); err != nil {
+		return err
+	} else if n != 0 {
 		return nil
-	})
+	}
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`alter table %s add column %s %s`, quoteIdent(table), quoteIdent(name), decl))
+	return err
 }
 
-// Delete implements part of [blob.KV].
-func (s KV) Delete(ctx context.Context, key string) error {
+func (d *dbMonitor) CAS(ctx context.Context, name string) (blob.CAS, error) {
+	kv, err := d.KV(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return blob.CASFromKV(kv), nil
+}
+
+func (d *dbMonitor) Sub(ctx context.Context, name string) (blob.Store, error) {
+	return Store{dbMonitor: &dbMonitor{
+		tableName: d.tableName.Sub(name),
+		sqlDB:     d.sqlDB,
+	}}, nil
+}
+
+// A KV implements the [blob.KV] interface using a SQLite3 database.
+type KV struct {
+	db        *dbMonitor
+	tableName string
+}
+
+// Close implements the [blob.Closer] interface. It releases the prepared
+// statements cached for the table of s, but does not close the underlying
+// database; use the Close method of the [Store] for that. It is safe to
+// continue using s after Close, at the cost of re-preparing statements.
+func (s KV) Close(context.Context) error {
 	s.db.txmu.Lock()
 	defer s.db.txmu.Unlock()
-
-	stmt := fmt.Sprintf(`delete from "%s" where key = $key`, s.tableName)
-	return withTxErr(ctx, s.db.db, func(tx *sql.Tx) error {
-		rsp, err := tx.ExecContext(ctx, stmt, sql.Named("key", encodeKey(key)))
-		if err != nil {
-			return fmt.Errorf("delete: %w", err)
-		} else if nr, _ := rsp.RowsAffected(); nr == 0 {
-			return blob.KeyNotFound(key)
-		}
-		return nil
-	})
+	return s.db.stmts.closeTable(s.tableName)
 }
 
-// List implements part of [blob.KV].
-func (s KV) List(ctx context.Context, start string, f func(string) error) error {
-	s.db.txmu.RLock()
-	defer s.db.txmu.RUnlock()
+// prepare returns a prepared statement for query on the table of s.
+// The caller must hold s.db.txmu, and must not call prepare while a
+// transaction is active, since preparing may require another connection.
+func (s KV) prepare(ctx context.Context, query string) (*sql.Stmt, error) {
+	return s.db.stmts.prepare(ctx, s.db.db, s.tableName, query)
+}
 
-	query := fmt.Sprintf(`select key from "%s" where key >= $start order by key`, s.tableName)
-	return withTxErr(ctx, s.db.db, func(tx *sql.Tx) error {
-		rows, err := tx.QueryContext(ctx, query, sql.Named("start", encodeKey(start)))
-		if err != nil {
-			return fmt.Errorf("list: %w", err)
-		}
-		defer rows.Close()
-		for rows.Next() {
-			var key []byte
-			if err := rows.Scan(&key); err != nil {
-				return fmt.Errorf("list: %w", err)
-			}
-			skey := decodeKey(key)
-			if err := f(skey); errors.Is(err, blob.ErrStopListing) {
-				break
-			} else if err != nil {
-				return err
-			}
-		}
-		return rows.Close()
-	})
+// stmt returns a statement for query bound to tx. If a prepared statement for
+// query was cached by a previous call to prepare, it is reused; otherwise the
+// statement is prepared on tx.
+func (s KV) stmt(ctx context.Context, tx *sql.Tx, query string) (*sql.Stmt, error) {
+	if st := s.db.stmts.lookup(s.tableName, query); st != nil {
+		return tx.StmtContext(ctx, st), nil
+	}
+	return tx.PrepareContext(ctx, query)
 }
 
-// Len implements part of [blob.KV].
-func (s KV) Len(ctx context.Context) (int64, error) {
-	s.db.txmu.RLock()
-	defer s.db.txmu.RUnlock()
+// table returns the quoted name of the table for s.
+func (s KV) table() string { return quoteIdent(s.tableName) }
 
-	query := fmt.Sprintf(`select count(*) from "%s"`, s.tableName)
-	return withTxValue(ctx, s.db.db, func(tx *sql.Tx) (int64, error) {
-		row := tx.QueryRowContext(ctx, query)
-		var nr int64
-		if err := row.Scan(&nr); err != nil {
-			return 0, err
-		}
-		return nr, nil
-	})
+// New creates or opens a store at the specified database.
+// It is equivalent to NewContext with a background context.
+func New(uri string, opts *Options) (Store, error) {
+	return NewContext(context.Background(), uri, opts)
 }
 
-func withTxValue[T any](ctx context.Context, db *sql.DB, f func(*sql.Tx) (T, error)) (T, error) {
-	tx, err := db.BeginTx(ctx, nil)
+// NewContext creates or opens a store at the specified database. The context
+// governs the initial setup of the database, including any retries while it
+// is busy (see [Options.OpenRetryTimeout]), but not the store it returns.
+func NewContext(ctx context.Context, uri string, opts *Options) (Store, error) {
+	if err := opts.Validate(); err != nil {
+		return Store{}, err
+	}
+	table := opts.tableName()
+	init, err := opts.connInit()
 	if err != nil {
-		var zero T
-		return zero, err
+		return Store{}, err
 	}
-	defer tx.Rollback()
-	v, err := f(tx)
+	codec := opts.codec()
+	if opts != nil && opts.EncryptionKey != nil {
+		codec, err = NewAESCodec(opts.EncryptionKey, codec)
+		if err != nil {
+			return Store{}, fmt.Errorf("invalid encryption key: %w", err)
+		}
+	}
+	codecID, _ := builtinCodecID(codec) // CodecStore if not built in
+	nsCodecs, err := opts.namespaceCodecs()
 	if err != nil {
-		return v, err
+		return Store{}, err
+	}
+	var db *sql.DB
+	shared, fresh := opts != nil && opts.Shared, true
+	if shared {
+		db, fresh, err = openShared(opts.driverName(), uri, init, !opts.checkpointOnClose())
+	} else {
+		db, err = openDB(opts.driverName(), uri, init, !opts.checkpointOnClose())
+	}
+	if err != nil {
+		return Store{}, err
+	}
+	if size := opts.poolSize(); size > 0 && fresh {
+		db.SetMaxOpenConns(size)
 	}
-	return v, tx.Commit()
+	if opts != nil && fresh {
+		if opts.MaxIdleConns != 0 {
+			db.SetMaxIdleConns(opts.MaxIdleConns)
+		} else if opts.WarmPool {
+			db.SetMaxIdleConns(opts.poolSize())
+		}
+		if opts.ConnMaxLifetime > 0 {
+			db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+		}
+		if opts.ConnMaxIdleTime > 0 {
+			db.SetConnMaxIdleTime(opts.ConnMaxIdleTime)
+		}
+	}
+	d := &sqlDB{
+		db:           db,
+		shared:       shared,
+		retries:      opts.busyRetries(),
+		coder:        opts.errorCoder(),
+		retryDelay:   opts.busyRetryDelay(),
+		table:        table,
+		codec:        codec,
+		codecID:      codecID,
+		metrics:      opts.metrics(),
+		tracer:       opts.tracer(),
+		traceKeys:    opts != nil && opts.TraceKeys,
+		slow:         opts.slowThreshold(),
+		timeout:      opts.defaultTimeout(),
+		logger:       opts.logger(),
+		logKeys:      opts != nil && opts.LogKeys,
+		fastLen:      opts != nil && opts.FastLen,
+		verify:       opts != nil && opts.Verify,
+		dedup:        opts != nil && opts.Dedup,
+		overflow:     opts.overflowSize(),
+		maxKeys:      opts.maxKeys(),
+		maxBytes:     opts.maxBytes(),
+		touch:        opts != nil && opts.TouchOnGet,
+		noVacuum:     !opts.vacuumOnClose(),
+		noCheckpoint: opts != nil && opts.NoCheckpoint,
+		noCreate:     opts != nil && opts.NoCreate,
+		onChange:     opts.onChange(),
+		covering:     opts != nil && opts.CoveringIndex,
+		keys:         opts.keyEncoding(),
+		binaryKeys:   opts != nil && opts.AllowBinaryKeys,
+		keyCodec:     opts.keyCodec(),
+		withoutRowID: opts != nil && opts.WithoutRowID,
+		stmts:        newStmtCache(),
+		lens:         make(map[string]int64),
+		codecs:       make(map[string]tableCodec),
+		nsCodecs:     nsCodecs,
+		buffers:      make(map[*BufferedKV]struct{}),
+	}
+	if opts != nil && opts.Expvar != nil {
+		d.vars = newStoreVars(opts.Expvar, d)
+	}
+	if err := d.setupWithRetry(ctx, opts, fresh); err != nil {
+		if !shared || releaseShared(db) {
+			db.Close()
+		}
+		return Store{}, err
+	}
+	if opts != nil && opts.MaintenanceInterval > 0 {
+		d.startMaintenance(opts.MaintenanceInterval)
+	}
+	return Store{dbMonitor: &dbMonitor{sqlDB: d}}, nil
+}
+
+// setup prepares a newly-opened database for use by the store.
+func (d *sqlDB) setup(ctx context.Context, opts *Options, fresh bool) error {
+	if err := d.checkJournalMode(ctx, opts); err != nil {
+		return err
+	}
+	if opts != nil && opts.WarmPool && fresh {
+		if err := warmPool(ctx, d.db, opts.poolSize()); err != nil {
+			return err
+		}
+	}
+	migrate := d.migrate
+	if d.noCreate {
+		migrate = d.checkSchema
+	}
+	if err := migrate(ctx); err != nil {
+		return err
+	}
+	return d.resolvePath(ctx)
+}
+
+// setupWithRetry calls setup, and if it fails because the database is busy or
+// locked, calls it again with exponential backoff until it succeeds, fails
+// for another reason, or the open retry timeout of opts elapses or ctx ends.
+// Each step of setup is safe to repeat.
+func (d *sqlDB) setupWithRetry(ctx context.Context, opts *Options, fresh bool) error {
+	timeout := opts.openRetryTimeout()
+	if timeout <= 0 {
+		return d.setup(ctx, opts, fresh)
+	}
+	deadline := time.Now().Add(timeout)
+	delay := opts.busyRetryDelay()
+	for {
+		err := d.setup(ctx, opts, fresh)
+		if err == nil || !d.isBusy(err) {
+			return err
+		}
+		wait := min(delay, time.Until(deadline))
+		if wait <= 0 {
+			return fmt.Errorf("database still busy after %v: %w", timeout, err)
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+			delay *= 2
+		}
+	}
+}
+
+// resolvePath records the path of the main database file, as SQLite reports
+// it. The path is empty for an in-memory or temporary database.
+func (d *sqlDB) resolvePath(ctx context.Context) error {
+	if err := d.db.QueryRowContext(ctx, `select file from pragma_database_list where name = 'main'`).Scan(&d.path); err != nil {
+		return fmt.Errorf("resolve database path: %w", d.classifyError(err))
+	}
+	return nil
+}
+
+// checkJournalMode verifies that the database accepted the journal mode set
+// by opts, if any. SQLite does not report an error for a mode it cannot use,
+// but leaves the mode unchanged.
+func (d *sqlDB) checkJournalMode(ctx context.Context, opts *Options) error {
+	if opts == nil || opts.JournalMode == "" {
+		return nil
+	}
+	var mode string
+	if err := d.db.QueryRowContext(ctx, `pragma journal_mode`).Scan(&mode); err != nil {
+		return fmt.Errorf("check journal mode: %w", d.classifyError(err))
+	}
+	if !strings.EqualFold(mode, opts.JournalMode) {
+		return fmt.Errorf("journal mode %q is not supported (database uses %q)", opts.JournalMode, mode)
+	}
+	return nil
+}
+
+// Options are options for constructing a [KV].  A nil *Options is ready for
+// use and provides default values as described.
+type Options struct {
+	// The name of the SQL driver to use, default "sqlite".
+	//
+	// The driver must speak the SQLite dialect; for example, the libsql driver
+	// can be used with a local or remote libsql database. Some features, such
+	// as [Store.Backup], require the default driver.
+	Driver string
+
+	// If set, ErrorCoder extracts SQLite result codes from the errors reported
+	// by the driver. By default, an error carries a result code if it or an
+	// error it wraps has a method Code() int reporting the extended result
+	// code, as the errors of the default driver do. Set this when using a
+	// driver whose errors do not (such as github.com/mattn/go-sqlite3, whose
+	// sqlite3.Error has an ExtendedCode field); otherwise the store cannot
+	// retry when the database is busy, tell when a write conflicts with an
+	// existing key, or classify errors by cause (see [ErrDiskFull]).
+	ErrorCoder ErrorCoder
+
+	// The number of connections to allow in the pool. If <= 0, use runtime.NumCPU.
+	// If LockingMode is EXCLUSIVE, the pool has one connection regardless.
+	PoolSize int
+
+	// The maximum number of idle connections to retain in the pool. If zero,
+	// use the database/sql default; if negative, retain no idle connections.
+	// The number of idle connections never exceeds PoolSize.
+	MaxIdleConns int
+
+	// If positive, the maximum amount of time a connection may be reused
+	// before it is closed and replaced. By default connections are reused
+	// indefinitely.
+	ConnMaxLifetime time.Duration
+
+	// If positive, the maximum amount of time a connection may remain idle in
+	// the pool before it is closed. By default idle connections are retained
+	// indefinitely (subject to MaxIdleConns).
+	ConnMaxIdleTime time.Duration
+
+	// If true, New opens all PoolSize connections eagerly, so that the first
+	// operations on the store do not pay the cost of opening them, and any
+	// error opening or initializing a connection is reported by New rather
+	// than by the first operation to need it. If MaxIdleConns is zero, the
+	// pool retains all PoolSize connections when they are idle; otherwise
+	// connections in excess of MaxIdleConns are closed after warming.
+	// This option has no effect if the database is already open and shared
+	// (see [Options.Shared]).
+	WarmPool bool
+
+	// If true, store blobs without compression; by default blob data are
+	// compressed with Snappy. This is equivalent to setting Codec to
+	// [NoCodec].
+	Uncompressed bool
+
+	// If set, the codec used to encode values, overriding Uncompressed; for
+	// example [GzipCodec]. Each value records the codec that encoded it, so
+	// values written with the built-in codecs can be read whatever the codec
+	// of the store. Values written with any other codec can only be read
+	// with the same codec, so a store using one must always be opened with
+	// it. To convert existing values to a new codec, use [KV.Recompress].
+	Codec Codec
+
+	// If set, the codecs of namespaces, by prefix (as reported by
+	// [Store.Namespaces]) and name, as for [KV.SetCompression]. When the KV
+	// method opens a namespace listed here that has no codec of its own
+	// recorded in the database, it records the given codec, which then
+	// applies to the values written to the namespace whenever it is opened.
+	// This allows the codec of a namespace to be chosen when it is created,
+	// without a separate call to SetCompression. Values already stored keep
+	// the codec that encoded them. It is an error to set NamespaceCodecs
+	// unless the codec of the store is built in, as for SetCompression. This
+	// option is ignored if NoCreate is set.
+	NamespaceCodecs map[dbkey.Prefix]string
+
+	// If set, encrypt values with AES-GCM using this key, which must be 16,
+	// 24, or 32 bytes long. Values are encrypted after they are encoded by
+	// the codec selected by Codec or Uncompressed. See [NewAESCodec] for the
+	// properties of the encryption.
+	EncryptionKey []byte
+
+	// If set, operations on the store report metrics to this collector.
+	Metrics Metrics
+
+	// If set, the store publishes basic counters in this map, which the
+	// caller may obtain from [expvar.NewMap] to export it under a name of
+	// its choosing. The store sets the following entries:
+	//
+	//   - "ops": a map from operation name (for example "get") to the
+	//     number of times it was performed
+	//   - "errors": a map from operation name to the number of failures,
+	//     including lookups of keys that are not found
+	//   - "bytes_read": the total size of the values read by Get, BatchGet,
+	//     and GetRaw (as stored)
+	//   - "bytes_written": the total size of the values written
+	//   - "keys": a map from table name to the number of keys in each KV
+	//     that has been opened; only present if FastLen is set
+	//
+	// The map should not be shared with another store.
+	Expvar *expvar.Map
+
+	// If set, operations on the store record trace spans using a tracer
+	// from this provider. By default, operations are not traced.
+	TracerProvider trace.TracerProvider
+
+	// If true, trace spans include the key affected by each operation.
+	// By default keys are omitted, since they may be sensitive.
+	TraceKeys bool
+
+	// If positive, an operation whose context has no deadline is given one
+	// this far in the future, so that a stuck query cannot hold the store
+	// indefinitely. This applies to each operation as a whole, including
+	// long-running ones such as [KV.ExportTar] and [KV.Scan], so callers of
+	// those should set their own deadlines. By default, operations are not
+	// limited.
+	DefaultTimeout time.Duration
+
+	// If positive, operations taking at least this long, including time spent
+	// waiting for locks, are logged to Logger. By default slow operations are
+	// not logged.
+	SlowThreshold time.Duration
+
+	// The logger to which slow operations are reported. If nil, use the
+	// default logger from the log/slog package.
+	Logger *slog.Logger
+
+	// If true, slow-operation logs include the key affected by the operation.
+	// By default keys are omitted, since they may be sensitive.
+	LogKeys bool
+
+	// If true, maintain the number of keys in each KV in memory, so that Len
+	// does not need to scan the table. The count is loaded when the KV is
+	// first opened and updated by writes through the store; it will not
+	// reflect changes made by other processes sharing the database (see
+	// [KV.Resync]). By default, Len counts the rows on each call.
+	FastLen bool
+
+	// If true, store a CRC-32C checksum of each value when it is written, and
+	// verify it when the value is read. A value that fails verification is
+	// reported as [ErrCorruptValue]. Values written without verification are
+	// not checked.
+	Verify bool
+
+	// If true, store each distinct value only once, shared among all the keys
+	// in the store whose values are equal, with a reference count. This saves
+	// space when many keys have the same value, at some cost to writes.
+	// Values written without deduplication remain readable, and the option
+	// may be changed when the store is reopened.
+	Dedup bool
+
+	// If set, the path of a second database file, attached to each
+	// connection, for storing large values. This allows, for example, keys
+	// and small values to be kept on fast storage while large values are
+	// kept on cheaper storage. Values whose encoded size is at least
+	// OverflowSize bytes are stored in a content table in the overflow
+	// database, as with Dedup (and if Dedup is also enabled, the content
+	// table for all values is in the overflow database). Rows in the main
+	// database refer to overflow values by their digest.
+	//
+	// Once values have been stored with a given Overflow setting, the store
+	// must always be reopened with the same setting. Backup copies only the
+	// main database, and writes that span both databases are atomic only if
+	// the main database does not use write-ahead logging.
+	Overflow string
+
+	// The minimum encoded size in bytes of a value stored in the Overflow
+	// database. If <= 0, use 64KiB.
+	OverflowSize int
+
+	// If set, the base name prepended to the names of all tables created by
+	// the store. It must consist of ASCII letters, digits, and underscores,
+	// and must not begin with a digit. By default, table names are derived
+	// from the keyspace name only.
+	Table string
+
+	// If positive, the maximum number of keys in each KV. When a write
+	// would exceed this limit, the least-recently used keys are evicted.
+	MaxKeys int64
+
+	// If positive, the maximum total size in bytes of the values in each KV,
+	// before compression. When a write would exceed this limit, the
+	// least-recently used keys are evicted. A value larger than this limit
+	// is evicted as soon as it is written.
+	//
+	// Checking the limits requires a scan of the table on each write, so
+	// MaxKeys and MaxBytes are best suited to stores of moderate size.
+	MaxBytes int64
+
+	// If true, and either MaxKeys or MaxBytes is set, Get updates the access
+	// time of the key it reads. By default, only writes update the access
+	// time, so that eviction is by least-recently written. Note that this
+	// makes Get a write, so that it will not run concurrently with other
+	// operations.
+	TouchOnGet bool
+
+	// If positive, run maintenance in the background at this interval until
+	// the store is closed: Checkpoint and truncate the write-ahead log (if
+	// the database uses one), and update the query planner statistics with
+	// "pragma optimize". Other operations on the store wait while maintenance
+	// is running. By default, no background maintenance is done.
+	MaintenanceInterval time.Duration
+
+	// If true, the store never checkpoints the write-ahead log itself, so
+	// background maintenance only updates the query planner statistics.
+	// This is independent of NoVacuum and of CheckpointOnClose, which
+	// governs the log when the database is closed.
+	NoCheckpoint bool
+
+	// If set, OnChange is called for each key written or deleted by a KV of
+	// the store, once the transaction has committed, with the operation
+	// ("put" or "delete") and the key. This covers every method that writes
+	// individual keys, including batch writes, the writes of a [BufferedKV]
+	// when they are flushed, [KV.WithTx], [KV.CaptureChanges], and [KV.Move],
+	// which reports a "delete" of its source and a "put" of its target. A key
+	// removed by eviction or by [KV.PurgeExpired] is reported as a "delete";
+	// a key that merely expires is not reported until it is purged. Keys
+	// read from the database, such as those evicted or purged, are reported
+	// as by List, so with a KeyCodec that cannot decode keys, they are
+	// reported in their stored form.
+	//
+	// OnChange is called after the store releases its locks, so it may use
+	// the store, but it delays the return of the operation, so it should be
+	// quick. It is not called for a failed or rolled-back write, nor for
+	// changes to whole tables, such as by [Store.DropNamespace],
+	// [Store.RenameNamespace], and [Store.CloneNamespace], nor for
+	// [KV.Recompress] and [KV.SetCompression], which do not change values.
+	OnChange func(op, key string)
+
+	// If true, the store does not create or alter any tables or indexes.
+	// Instead, New and the KV method of the store report an error wrapping
+	// [ErrMissingSchema] if a table or index the store requires does not
+	// exist, or an error if a table must be upgraded to the current schema.
+	// This allows a store to use a database whose schema is provisioned in
+	// advance, through a connection that is not permitted to change it. The
+	// schema must be provisioned with the same table options (such as Table,
+	// Overflow, CoveringIndex, MaxKeys, and MaxBytes) by a store without
+	// NoCreate, for example by opening each KV once.
+	NoCreate bool
+
+	// If true, Close does not vacuum the database. Vacuuming reclaims unused
+	// space, but rewrites the entire database, which may be slow for a large
+	// store. Close always updates the query planner statistics. If
+	// VacuumOnClose is set, it takes precedence.
+	NoVacuum bool
+
+	// If set, whether Close vacuums the database; if nil, Close vacuums it
+	// unless NoVacuum is set. This is independent of CheckpointOnClose.
+	VacuumOnClose *bool
+
+	// If set to false, the write-ahead log (if the database uses one) is
+	// kept when the last connection to the database is closed, rather than
+	// truncated and removed, so that a process that copies or replicates the
+	// log sees its frames. If nil or true, SQLite removes the log at close.
+	//
+	// In either case SQLite checkpoints the log into the database when the
+	// last connection is closed: The driver does not expose the setting
+	// (SQLITE_DBCONFIG_NO_CKPT_ON_CLOSE) that would prevent it, so this
+	// option only controls whether the log file survives. It requires the
+	// default driver, or one whose connections provide the same
+	// FileControlPersistWAL method; otherwise connections fail to open with
+	// an error wrapping [errors.ErrUnsupported].
+	CheckpointOnClose *bool
+
+	// If true, maintain an index on the key, size, and expiration time of
+	// each value, so that Stat, Contains, and MissingKeys can be answered
+	// from the index without reading the stored rows. Without it, even the
+	// existence checks of Contains and MissingKeys read the row of each key
+	// they find, to check its expiration time. This matters most when values
+	// are large, since the size and expiration time are stored after the
+	// value in each row. The index costs some space (roughly the size of the
+	// keys again) and time on each write.
+	CoveringIndex bool
+
+	// How keys are stored in the database. The default is [HexKeys].
+	KeyEncoding KeyEncoding
+
+	// If true, and KeyEncoding is [TextKeys], keys that are not valid UTF-8
+	// are stored as BLOBs of their bytes. In the order used by List, all such
+	// keys follow all the valid UTF-8 keys. By default, writing such a key
+	// reports [ErrInvalidKey].
+	AllowBinaryKeys bool
+
+	// If set, the codec used to encode keys for storage, overriding
+	// KeyEncoding. The same caveats apply as for changing KeyEncoding.
+	KeyCodec KeyCodec
+
+	// If true, create new tables as WITHOUT ROWID tables keyed by the stored
+	// key, instead of rowid tables with a unique index on the key. This
+	// avoids storing each key twice, and saves an index lookup on each read,
+	// which can make small values faster to read and write. For large values
+	// (more than a few kilobytes) rowid tables are usually preferable, since
+	// a WITHOUT ROWID table stores the values in the key index itself. The
+	// option does not affect existing tables.
+	WithoutRowID bool
+
+	// If positive, the page size in bytes for a new database. It must be a
+	// power of two between 512 and 65536. Larger pages may help when values
+	// are large. The page size of an existing database changes only when it
+	// is vacuumed, which Close does by default (see VacuumOnClose), and not
+	// at all if the database uses a write-ahead log.
+	PageSize int
+
+	// If set, the auto-vacuum mode for a new database: "NONE", "FULL", or
+	// "INCREMENTAL" (case does not matter). In FULL mode, free pages are
+	// reclaimed at each commit; in INCREMENTAL mode, they are reclaimed by
+	// [Store.IncrementalVacuum]. The mode must be set before the first table
+	// is created; the mode of an existing database changes only when it is
+	// vacuumed, which Close does by default (see VacuumOnClose).
+	AutoVacuum string
+
+	// If set, the secure-delete mode: "OFF", "ON", or "FAST" (case does not
+	// matter). When ON, deleted content is overwritten with zeros, so that a
+	// deleted value does not remain in the database file. This costs extra
+	// I/O on every delete or update. FAST overwrites deleted content only
+	// when doing so adds no I/O, so some deleted content may remain in free
+	// pages until they are reused or the database is vacuumed. The default
+	// is the compiled-in default of SQLite, normally OFF.
+	SecureDelete string
+
+	// If set, where temporary tables and indexes are stored: "DEFAULT",
+	// "FILE", or "MEMORY" (case does not matter). MEMORY avoids temporary
+	// file I/O for large sorts and the like, at the cost of memory.
+	TempStore string
+
+	// If set, the locking mode: "NORMAL" or "EXCLUSIVE" (case does not
+	// matter). In EXCLUSIVE mode, a connection keeps its lock on the database
+	// file once it has one, which saves system calls when the database is
+	// used by only one process. Since a lock held by one connection excludes
+	// all others, EXCLUSIVE mode limits the pool to a single connection, and
+	// other processes (and other stores opened on the same file without
+	// Shared) cannot access the database until the store is closed. In
+	// write-ahead log mode, EXCLUSIVE also avoids the use of shared memory.
+	LockingMode string
+
+	// If set, the journal mode: "DELETE", "TRUNCATE", "PERSIST", "MEMORY",
+	// "WAL", "WAL2", or "OFF" (case does not matter). New reports an error if
+	// the database does not accept the mode; for example, an in-memory
+	// database cannot use WAL, and WAL2 requires a build of SQLite that
+	// supports it, which the default driver does not. By default, the mode is
+	// left as it is, normally DELETE for a new database, or as set by the URI.
+	JournalMode string
+
+	// If true, enforce foreign key constraints. The store's own tables do not
+	// use foreign keys, but tables added to the database alongside them may.
+	// SQLite enforces foreign keys only on connections where they are enabled,
+	// so this is applied to every connection in the pool.
+	ForeignKeys bool
+
+	// If positive, the maximum number of bytes of the database file to
+	// access with memory-mapped I/O on each connection. By default, the
+	// database is not memory-mapped.
+	MMapSize int64
+
+	// If nonzero, the size of the page cache for each connection.  A positive
+	// value is a number of pages; a negative value -n requests n KiB of cache.
+	// By default, SQLite uses a cache of about 2 MiB.
+	CacheSize int
+
+	// If set and positive, SQLite checkpoints the write-ahead log
+	// automatically when a commit leaves it at least this many pages long.
+	// If set to zero or a negative value, automatic checkpoints are disabled,
+	// and the log grows until it is checkpointed by [Store.Checkpoint],
+	// background maintenance, or closing the database. If nil, SQLite uses
+	// its default threshold of 1000 pages. This has no effect unless the
+	// database uses write-ahead logging.
+	WALAutoCheckpoint *int
+
+	// How long a connection waits for a lock held by another connection
+	// before reporting that the database is busy. If zero, use 5 seconds; if
+	// negative, do not wait.
+	BusyTimeout time.Duration
+
+	// If true, share a single database handle (and its connection pool)
+	// among all the stores in the process opened with Shared for the same
+	// driver and URI. The connection and pool options of the first such store
+	// apply to all of them, and the handle is closed when the last of them is
+	// closed.
+	//
+	// Stores that share a database file should use the same table options
+	// and encodings. Write-ahead logging (for example, with the modernc
+	// driver, "_pragma=journal_mode(wal)" in the URI) allows reads to proceed
+	// concurrently with a write from another store or process.
+	Shared bool
+
+	// The number of times to retry a transaction that fails because the
+	// database is busy or locked, after BusyTimeout (if any) has elapsed. If
+	// zero, use 3; if negative, do not retry. Note that a retried transaction
+	// calls any callback running within it again, for example the function
+	// passed to [KV.WithTx].
+	BusyRetries int
+
+	// The delay before the first retry of a busy transaction. Each
+	// subsequent retry waits twice as long as the last. If <= 0, use 10ms.
+	BusyRetryDelay time.Duration
+
+	// If positive, New retries setting up the database while it is busy or
+	// locked, for example because another process has not yet released its
+	// lock, for up to this long (or until the context passed to NewContext
+	// ends), with backoff as for BusyRetryDelay. Other failures, such as an
+	// invalid path or an unsupported JournalMode, are reported at once. By
+	// default, New fails if the database is still busy after BusyTimeout and
+	// BusyRetries are exhausted.
+	OpenRetryTimeout time.Duration
+}
+
+// A KeyCodec encodes keys for storage in the database. Its methods must be
+// safe for concurrent use by multiple goroutines.
+//
+// Keys are listed in the order of their encodings, which need not be the
+// order of the keys themselves.
+type KeyCodec interface {
+	// EncodeKey returns the encoding of key. Distinct keys must have
+	// distinct encodings.
+	EncodeKey(key string) []byte
+
+	// DecodeKey returns the key encoded by ekey. If the encoding cannot be
+	// reversed (for example, if keys are hashed), DecodeKey should report
+	// [errors.ErrUnsupported], and List and other methods that report keys
+	// from the store will report the encoded form of each key instead, and
+	// interpret their starting keys in the same form. Any other error is
+	// reported to the caller.
+	DecodeKey(ekey []byte) (string, error)
+}
+
+// A KeyEncoding specifies how keys are stored in the database.
+//
+// The encoding is not recorded in the database, and a store must always be
+// opened with the encoding its tables were written with: Keys stored with
+// one encoding are not found, or are listed incorrectly, under another.  To
+// change the encoding of existing data, copy it to a new store (see
+// [KV.CopyTo]).
+type KeyEncoding int
+
+const (
+	// HexKeys stores each key as a string of hexadecimal digits, twice the
+	// length of the key.
+	HexKeys KeyEncoding = iota
+
+	// RawKeys stores each key as a BLOB of its bytes. This halves the space
+	// used by keys and their index compared to HexKeys, and preserves their
+	// order.
+	RawKeys
+
+	// TextKeys stores each key as TEXT, which is easier to inspect with other
+	// SQLite tools. Keys must be valid UTF-8 unless AllowBinaryKeys is set
+	// (see [Options]). Valid UTF-8 keys are stored and ordered as with
+	// RawKeys.
+	TextKeys
+)
+
+func (o *Options) keyCodec() KeyCodec {
+	if o == nil {
+		return nil
+	}
+	return o.KeyCodec
+}
+
+func (o *Options) keyEncoding() KeyEncoding {
+	if o == nil {
+		return HexKeys
+	}
+	return o.KeyEncoding
+}
+
+// Metrics is the interface to a collector of operation metrics for a store.
+// Its methods must be safe for concurrent use by multiple goroutines.
+type Metrics interface {
+	// ObserveOp records the completion of the named operation (for example
+	// "get" or "put") on the specified table, after the elapsed duration.
+	// The err value is the error reported by the operation, or nil.
+	ObserveOp(op, table string, elapsed time.Duration, err error)
+}
+
+// DefaultOptions returns a new Options with the fields whose zero values
+// stand for defaults populated with those defaults. Passing the result to
+// [New] has the same effect as passing nil.
+func DefaultOptions() *Options {
+	return &Options{
+		Driver:         "sqlite",
+		PoolSize:       runtime.NumCPU(),
+		BusyTimeout:    5 * time.Second,
+		BusyRetries:    3,
+		BusyRetryDelay: 10 * time.Millisecond,
+		OverflowSize:   64 << 10,
+		KeyEncoding:    HexKeys,
+	}
+}
+
+// Validate reports an error if o cannot be used to construct a store, for
+// example because it names an unregistered driver or an invalid mode. A nil
+// *Options is valid. [New] calls Validate before opening the database.
+func (o *Options) Validate() error {
+	if o == nil {
+		return nil
+	}
+	if name := o.driverName(); !slices.Contains(sql.Drivers(), name) {
+		return fmt.Errorf("unknown driver %q", name)
+	}
+	if o.Table != "" && !isSafeIdent(o.Table) {
+		return fmt.Errorf("invalid table name %q", o.Table)
+	}
+	if o.KeyCodec == nil && (o.KeyEncoding < HexKeys || o.KeyEncoding > TextKeys) {
+		return fmt.Errorf("invalid key encoding %d", o.KeyEncoding)
+	}
+	if o.EncryptionKey != nil {
+		if _, err := NewAESCodec(o.EncryptionKey, nil); err != nil {
+			return fmt.Errorf("invalid encryption key: %w", err)
+		}
+	}
+	if _, err := o.namespaceCodecs(); err != nil {
+		return err
+	}
+	if _, err := o.connInit(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (o *Options) errorCoder() ErrorCoder {
+	if o == nil || o.ErrorCoder == nil {
+		return codeMethod{}
+	}
+	return o.ErrorCoder
+}
+
+func (o *Options) driverName() string {
+	if o == nil || o.Driver == "" {
+		return "sqlite"
+	}
+	return o.Driver
+}
+
+func (o *Options) codec() Codec {
+	if o == nil {
+		return SnappyCodec
+	} else if o.Codec != nil {
+		return o.Codec
+	} else if o.Uncompressed {
+		return NoCodec
+	}
+	return SnappyCodec
+}
+
+// namespaceCodecs returns the codecs of NamespaceCodecs, or nil if there are
+// none.
+func (o *Options) namespaceCodecs() (map[dbkey.Prefix]tableCodec, error) {
+	if o == nil || len(o.NamespaceCodecs) == 0 {
+		return nil, nil
+	} else if _, ok := builtinCodecID(o.codec()); !ok || o.EncryptionKey != nil {
+		return nil, fmt.Errorf("namespace codecs: %w", errors.ErrUnsupported)
+	}
+	out := make(map[dbkey.Prefix]tableCodec, len(o.NamespaceCodecs))
+	for ns, name := range o.NamespaceCodecs {
+		tc, err := namedTableCodec(name)
+		if err != nil {
+			return nil, fmt.Errorf("namespace %q: %w", ns, err)
+		}
+		out[ns] = tc
+	}
+	return out, nil
+}
+
+// connInit returns the statements to execute on each new connection.
+func (o *Options) connInit() ([]string, error) {
+	init := []string{fmt.Sprintf(`pragma busy_timeout = %d`, o.busyTimeout().Milliseconds())}
+	if o == nil {
+		return init, nil
+	}
+	if n := o.PageSize; n > 0 {
+		if n < 512 || n > 65536 || n&(n-1) != 0 {
+			return nil, fmt.Errorf("invalid page size %d", n)
+		}
+		init = append(init, fmt.Sprintf(`pragma page_size = %d`, n))
+	}
+	if o.MMapSize > 0 {
+		init = append(init, fmt.Sprintf(`pragma mmap_size = %d`, o.MMapSize))
+	}
+	if o.CacheSize != 0 {
+		init = append(init, fmt.Sprintf(`pragma cache_size = %d`, o.CacheSize))
+	}
+	if o.WALAutoCheckpoint != nil {
+		init = append(init, fmt.Sprintf(`pragma wal_autocheckpoint = %d`, max(*o.WALAutoCheckpoint, 0)))
+	}
+	if o.Overflow != "" {
+		init = append(init, fmt.Sprintf(`attach database '%s' as overflow`, strings.ReplaceAll(o.Overflow, "'", "''")))
+	}
+	if o.ForeignKeys {
+		init = append(init, `pragma foreign_keys = ON`)
+	}
+	for _, p := range []struct {
+		name, value string
+		modes       []string
+	}{
+		{"secure_delete", o.SecureDelete, []string{"OFF", "ON", "FAST"}},
+		{"temp_store", o.TempStore, []string{"DEFAULT", "FILE", "MEMORY"}},
+		{"auto_vacuum", o.AutoVacuum, []string{"NONE", "FULL", "INCREMENTAL"}},
+		{"locking_mode", o.LockingMode, []string{"NORMAL", "EXCLUSIVE"}},
+		{"journal_mode", o.JournalMode, []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "WAL2", "OFF"}},
+	} {
+		if p.value == "" {
+			continue
+		}
+		mode := strings.ToUpper(p.value)
+		if !slices.Contains(p.modes, mode) {
+			return nil, fmt.Errorf("invalid %s mode %q", strings.ReplaceAll(p.name, "_", "-"), p.value)
+		}
+		init = append(init, fmt.Sprintf(`pragma %s = %s`, p.name, mode))
+	}
+	return init, nil
+}
+
+func (o *Options) overflowSize() int {
+	if o == nil || o.Overflow == "" {
+		return 0
+	} else if o.OverflowSize <= 0 {
+		return 64 << 10
+	}
+	return o.OverflowSize
+}
+
+func (o *Options) busyRetries() int {
+	if o == nil || o.BusyRetries == 0 {
+		return 3
+	}
+	return max(o.BusyRetries, 0)
+}
+
+func (o *Options) onChange() func(op, key string) {
+	if o == nil {
+		return nil
+	}
+	return o.OnChange
+}
+
+func (o *Options) openRetryTimeout() time.Duration {
+	if o == nil {
+		return 0
+	}
+	return o.OpenRetryTimeout
+}
+
+func (o *Options) busyRetryDelay() time.Duration {
+	if o == nil || o.BusyRetryDelay <= 0 {
+		return 10 * time.Millisecond
+	}
+	return o.BusyRetryDelay
+}
+
+func (o *Options) busyTimeout() time.Duration {
+	if o == nil || o.BusyTimeout == 0 {
+		return 5 * time.Second
+	}
+	return max(o.BusyTimeout, 0)
+}
+
+func (o *Options) metrics() Metrics {
+	if o == nil {
+		return nil
+	}
+	return o.Metrics
+}
+
+func (o *Options) tracer() trace.Tracer {
+	if o == nil || o.TracerProvider == nil {
+		return nil
+	}
+	return o.TracerProvider.Tracer("github.com/creachadair/sqlitestore")
+}
+
+func (o *Options) defaultTimeout() time.Duration {
+	if o == nil || o.DefaultTimeout < 0 {
+		return 0
+	}
+	return o.DefaultTimeout
+}
+
+func (o *Options) slowThreshold() time.Duration {
+	if o == nil || o.SlowThreshold < 0 {
+		return 0
+	}
+	return o.SlowThreshold
+}
+
+func (o *Options) logger() *slog.Logger {
+	if o == nil || o.Logger == nil {
+		return slog.Default()
+	}
+	return o.Logger
+}
+
+func (o *Options) tableName() string {
+	if o == nil {
+		return ""
+	}
+	return o.Table
+}
+
+func (o *Options) maxKeys() int64 {
+	if o == nil || o.MaxKeys < 0 {
+		return 0
+	}
+	return o.MaxKeys
+}
+
+func (o *Options) maxBytes() int64 {
+	if o == nil || o.MaxBytes < 0 {
+		return 0
+	}
+	return o.MaxBytes
+}
+
+func (o *Options) vacuumOnClose() bool {
+	if o == nil {
+		return true
+	} else if o.VacuumOnClose != nil {
+		return *o.VacuumOnClose
+	}
+	return !o.NoVacuum
+}
+
+func (o *Options) checkpointOnClose() bool {
+	return o == nil || o.CheckpointOnClose == nil || *o.CheckpointOnClose
+}
+
+func (o *Options) poolSize() int {
+	if o != nil && strings.EqualFold(o.LockingMode, "EXCLUSIVE") {
+		return 1 // see LockingMode
+	} else if o == nil || o.PoolSize <= 0 {
+		return runtime.NumCPU()
+	}
+	return o.PoolSize
+}
+
+// warmPool opens n connections to db at once, so that each is initialized,
+// and then returns them to the pool.
+func warmPool(ctx context.Context, db *sql.DB, n int) error {
+	conns := make([]*sql.Conn, 0, n)
+	defer func() {
+		for _, c := range conns {
+			c.Close()
+		}
+	}()
+	for range n {
+		c, err := db.Conn(ctx)
+		if err != nil {
+			return fmt.Errorf("warm pool: %w", err)
+		}
+		conns = append(conns, c)
+	}
+	return nil
+}
+
+// isSafeIdent reports whether s is a plain SQL identifier, consisting only of
+// ASCII letters, digits, and underscores, and not beginning with a digit.
+func isSafeIdent(s string) bool {
+	for i, c := range s {
+		switch {
+		case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
+
+// quoteIdent returns s as a quoted SQL identifier, escaping embedded quotes.
+func quoteIdent(s string) string { return `"` + strings.ReplaceAll(s, `"`, `""`) + `"` }
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksum computes the checksum of a value stored with verification.
+func checksum(data []byte) uint32 { return crc32.Checksum(data, crcTable) }
+
+// encodeKey returns the representation of key stored in the database.
+func (s KV) encodeKey(key string) any {
+	if s.db.keyCodec != nil {
+		if ekey := s.db.keyCodec.EncodeKey(key); ekey != nil {
+			return ekey
+		}
+		return []byte{} // a nil slice is stored as NULL
+	}
+	switch s.db.keys {
+	case RawKeys:
+		return append([]byte{}, key...) // a nil slice is stored as NULL
+	case TextKeys:
+		if utf8.ValidString(key) {
+			return key
+		}
+		return append([]byte{}, key...)
+	}
+	return hexKey(key)
+}
+
+// hexKey returns the hexadecimal encoding of key. It is equivalent to
+// hex.EncodeToString([]byte(key)), but encodes into a buffer on the stack
+// when it fits, so that only the result is allocated.
+func hexKey(key string) string {
+	const digits = "0123456789abcdef"
+	var buf [256]byte
+	dst := buf[:0]
+	if n := hex.EncodedLen(len(key)); n > len(buf) {
+		dst = make([]byte, 0, n)
+	}
+	for i := 0; i < len(key); i++ {
+		dst = append(dst, digits[key[i]>>4], digits[key[i]&0x0f])
+	}
+	return string(dst)
+}
+
+// encodeStart returns the stored representation of a starting key for List.
+// If the key codec cannot decode keys, List reports keys in their encoded
+// form, so start is compared to the encoded keys directly.
+func (s KV) encodeStart(start string) any {
+	if c := s.db.keyCodec; c != nil {
+		if _, err := c.DecodeKey(c.EncodeKey(start)); errors.Is(err, errors.ErrUnsupported) {
+			return append([]byte{}, start...)
+		}
+	}
+	return s.encodeKey(start)
+}
+
+// checkKey reports an error if key cannot be stored in s.
+func (s KV) checkKey(key string) error {
+	if s.db.keyCodec == nil && s.db.keys == TextKeys && !s.db.binaryKeys && !utf8.ValidString(key) {
+		return &blob.KeyError{Key: key, Err: fmt.Errorf("%w: not valid UTF-8", ErrInvalidKey)}
+	}
+	return nil
+}
+
+// decodeKey returns the key represented by ekey in the database.  It may
+// modify the contents of ekey.
+func (s KV) decodeKey(ekey []byte) (string, error) {
+	if s.db.keyCodec != nil {
+		key, err := s.db.keyCodec.DecodeKey(ekey)
+		if errors.Is(err, errors.ErrUnsupported) {
+			return string(ekey), nil
+		} else if err != nil {
+			return "", fmt.Errorf("invalid stored key %q: %w", ekey, err)
+		}
+		return key, nil
+	}
+	if s.db.keys != HexKeys {
+		return string(ekey), nil
+	}
+	n, err := hex.Decode(ekey, ekey)
+	if err != nil {
+		return "", fmt.Errorf("invalid stored key %q: %w", ekey, err)
+	}
+	return string(ekey[:n]), nil
+}
+
+// encodeBlob encodes data with the codec of s. If buf != nil and the codec
+// supports it, the encoding is written into *buf, which is updated to retain
+// the storage for reuse; in that case the result is only valid until *buf is
+// next used.
+func (s KV) encodeBlob(buf *[]byte, data []byte) ([]byte, error) {
+	return encodeWith(s.codec(), buf, data)
+}
+
+// encodeWith encodes data with codec, as encodeBlob does.
+func encodeWith(codec Codec, buf *[]byte, data []byte) ([]byte, error) {
+	if sc, ok := codec.(snappyCodec); ok && buf != nil {
+		return sc.encodeTo(buf, data), nil
+	}
+	enc, err := codec.Encode(data)
+	if err != nil {
+		return nil, err
+	} else if enc == nil {
+		return []byte{}, nil // a nil slice is stored as NULL
+	}
+	return enc, nil
+}
+
+// decodeBlob decodes the stored value data of key, encoded by the codec with
+// the given ID.
+func (s *KV) decodeBlob(key string, codec byte, data []byte) ([]byte, error) {
+	c := s.db.codecByID(codec)
+	if c == nil {
+		return nil, &blob.KeyError{Key: key, Err: fmt.Errorf("%w: unknown codec %d", ErrCorruptValue, codec)}
+	}
+	dec, err := c.Decode(data)
+	if err != nil {
+		return nil, &blob.KeyError{Key: key, Err: fmt.Errorf("%w: %w", ErrCorruptValue, err)}
+	}
+	return dec, nil
+}
+
+// Get implements part of [blob.KV].
+func (s KV) Get(ctx context.Context, key string) (_ []byte, err error) {
+	ctx, op := s.db.begin(ctx, "get", s.tableName)
+	defer op.end(&err)
+
+	touch := s.db.touch && s.db.evicting()
+	if touch {
+		s.db.txmu.Lock()
+		defer s.db.txmu.Unlock()
+	} else {
+		s.db.txmu.RLock()
+		defer s.db.txmu.RUnlock()
+	}
+
+	op.setKey(key)
+	if _, err := s.prepare(ctx, s.getQuery()); err != nil {
+		return nil, fmt.Errorf("get: %w", err)
+	}
+	return withTxValue(ctx, s.db.sqlDB, func(tx *sql.Tx) ([]byte, error) {
+		data, err := s.getTx(ctx, tx, key)
+		op.setSize(len(data))
+		if err == nil && touch {
+			err = s.touchTx(ctx, tx, key)
+		}
+		return data, err
+	})
+}
+
+// GetOr returns the value of key, or dflt if key is not present in s. Any
+// other error from Get is reported to the caller.
+func (s KV) GetOr(ctx context.Context, key string, dflt []byte) ([]byte, error) {
+	data, err := s.Get(ctx, key)
+	if blob.IsKeyNotFound(err) {
+		return dflt, nil
+	}
+	return data, err
+}
+
+func (s KV) getQuery() string {
+	return fmt.Sprintf(`select coalesce(c.value, t.value), %s, t.checksum from %s as t
+  left join %s as c on c.hash = t.ref
+  where t.key = $key and %s`, rowCodec, s.table(), s.db.contentIdent(), liveRow("t"))
+}
+
+// getTx reads and decodes the value of key within tx. It reports
+// [blob.ErrKeyNotFound] if key is not present.
+func (s KV) getTx(ctx context.Context, tx *sql.Tx, key string) ([]byte, error) {
+	st, err := s.stmt(ctx, tx, s.getQuery())
+	if err != nil {
+		return nil, fmt.Errorf("get: %w", err)
+	}
+	var data []byte
+	var codec byte
+	var sum sql.NullInt64
+	if err := st.QueryRowContext(ctx, sql.Named("key", s.encodeKey(key)), nowArg()).Scan(&data, &codec, &sum); errors.Is(err, sql.ErrNoRows) {
+		return nil, blob.KeyNotFound(key)
+	} else if err != nil {
+		return nil, fmt.Errorf("get: %w", err)
+	}
+	return s.decodeValue(key, data, codec, sum)
+}
+
+// decodeValue decodes the stored value data of key, encoded by the codec with
+// the given ID, and verifies it against the stored checksum sum if
+// verification is enabled.
+func (s KV) decodeValue(key string, data []byte, codec byte, sum sql.NullInt64) ([]byte, error) {
+	dec, err := s.decodeBlob(key, codec, data)
+	if err != nil {
+		return nil, err
+	}
+	if s.db.verify && sum.Valid && uint32(sum.Int64) != checksum(dec) {
+		return nil, &blob.KeyError{Key: key, Err: fmt.Errorf("%w: checksum mismatch", ErrCorruptValue)}
+	}
+	return dec, nil
+}
+
+// Stat implements part of [blob.KV].
+func (s KV) Stat(ctx context.Context, keys ...string) (_ blob.StatMap, err error) {
+	ctx, op := s.db.begin(ctx, "stat", s.tableName)
+	defer op.end(&err)
+
+	s.db.txmu.RLock()
+	defer s.db.txmu.RUnlock()
+
+	return withTxValue(ctx, s.db.sqlDB, func(tx *sql.Tx) (blob.StatMap, error) {
+		return s.statTx(ctx, tx, keys)
+	})
+}
+
+// statIndex returns an index clause for queries that find keys and their
+// sizes, which directs them to the covering index if it is enabled, or "".
+// Such queries must select only from the columns of that index (key, vsize,
+// expires_at), so that they need not read the rows.
+func (s KV) statIndex() string {
+	if s.db.covering {
+		// The planner prefers the unique index on key, which is not covering.
+		return "indexed by " + quoteIdent(s.tableName+"_stat")
+	}
+	return "" // let the planner choose
+}
+
+// statTx reports the sizes of those keys present in s, within tx.
+func (s KV) statTx(ctx context.Context, tx *sql.Tx, keys []string) (blob.StatMap, error) {
+	index := s.statIndex()
+	out := make(blob.StatMap)
+	now := nowArg()
+	for chunk := range slices.Chunk(keys, statChunkSize) {
+		// Look up all the keys in the chunk with a single query.
+		params := make([]string, len(chunk))
+		args := make([]any, len(chunk), len(chunk)+1)
+		for i, key := range chunk {
+			params[i] = fmt.Sprintf("$k%d", i)
+			args[i] = sql.Named(fmt.Sprintf("k%d", i), s.encodeKey(key))
+		}
+		query := fmt.Sprintf(`select key, vsize from %s as t %s where key in (%s) and %s`,
+			s.table(), index, strings.Join(params, ", "), liveRow("t"))
+		rows, err := tx.QueryContext(ctx, query, append(args, now)...)
+		if err != nil {
+			return nil, fmt.Errorf("stat: %w", err)
+		}
+		for rows.Next() {
+			var ekey []byte
+			var size int64
+			if err := rows.Scan(&ekey, &size); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("stat: %w", err)
+			}
+			key, err := s.decodeKey(ekey)
+			if err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("stat: %w", err)
+			}
+			out[key] = blob.Stat{Size: size}
+		}
+		if err := rows.Close(); err != nil {
+			return nil, fmt.Errorf("stat: %w", err)
+		}
+	}
+	return out, nil
+}
+
+// Contains reports whether key is present in s. It is equivalent to checking
+// the result of Stat for a single key, but cheaper. It reads only the key and
+// expiration time, so with CoveringIndex it is answered from the index;
+// otherwise it reads the stored row of key, if present.
+func (s KV) Contains(ctx context.Context, key string) (_ bool, err error) {
+	ctx, op := s.db.begin(ctx, "contains", s.tableName)
+	defer op.end(&err)
+
+	s.db.txmu.RLock()
+	defer s.db.txmu.RUnlock()
+
+	op.setKey(key)
+	query := fmt.Sprintf(`select exists (select 1 from %s as t %s where key = $key and %s)`,
+		s.table(), s.statIndex(), liveRow("t"))
+	st, err := s.prepare(ctx, query)
+	if err != nil {
+		return false, fmt.Errorf("contains: %w", err)
+	}
+	return withTxValue(ctx, s.db.sqlDB, func(tx *sql.Tx) (bool, error) {
+		var ok bool
+		if err := tx.StmtContext(ctx, st).QueryRowContext(ctx, sql.Named("key", s.encodeKey(key)), nowArg()).Scan(&ok); err != nil {
+			return false, fmt.Errorf("contains: %w", err)
+		}
+		return ok, nil
+	})
+}
+
+// MissingKeys returns the subset of keys that are not present in s, in the
+// order they were given. If all the keys are present, it returns nil. It is
+// equivalent to [blob.SyncKeys], but finds the missing keys directly rather
+// than computing the complement of a Stat.
+func (s KV) MissingKeys(ctx context.Context, keys ...string) (_ []string, err error) {
+	ctx, op := s.db.begin(ctx, "missingkeys", s.tableName)
+	defer op.end(&err)
+
+	s.db.txmu.RLock()
+	defer s.db.txmu.RUnlock()
+
+	return withTxValue(ctx, s.db.sqlDB, func(tx *sql.Tx) ([]string, error) {
+		var missing []string
+		now := nowArg()
+		for base := 0; base < len(keys); base += statChunkSize {
+			chunk := keys[base:min(base+statChunkSize, len(keys))]
+
+			// Report the positions of the absent keys, so that the result does
+			// not depend on decoding the stored keys.
+			rows := make([]string, len(chunk))
+			args := make([]any, len(chunk), len(chunk)+1)
+			for i, key := range chunk {
+				rows[i] = fmt.Sprintf("(%d, $k%d)", i, i)
+				args[i] = sql.Named(fmt.Sprintf("k%d", i), s.encodeKey(key))
+			}
+			query := fmt.Sprintf(`with v(i, k) as (values %s)
+  select i from v where not exists (select 1 from %s as t %s where t.key = v.k and %s) order by i`,
+				strings.Join(rows, ", "), s.table(), s.statIndex(), liveRow("t"))
+			qr, err := tx.QueryContext(ctx, query, append(args, now)...)
+			if err != nil {
+				return nil, fmt.Errorf("missingkeys: %w", err)
+			}
+			for qr.Next() {
+				var i int
+				if err := qr.Scan(&i); err != nil {
+					qr.Close()
+					return nil, fmt.Errorf("missingkeys: %w", err)
+				}
+				missing = append(missing, chunk[i])
+			}
+			if err := qr.Close(); err != nil {
+				return nil, fmt.Errorf("missingkeys: %w", err)
+			}
+		}
+		return missing, nil
+	})
+}
+
+// statChunkSize is the maximum number of keys Stat looks up with a single
+// query, to remain well within the SQLite limit on query parameters.
+const statChunkSize = 500
+
+// Created reports the time at which key was first written. Replacing the
+// value of a key does not change its creation time.  If key was written
+// before creation times were recorded, Created returns the zero time.  It
+// reports [blob.ErrKeyNotFound] if key is not present.
+func (s KV) Created(ctx context.Context, key string) (_ time.Time, err error) {
+	ctx, op := s.db.begin(ctx, "created", s.tableName)
+	defer op.end(&err)
+
+	s.db.txmu.RLock()
+	defer s.db.txmu.RUnlock()
+
+	op.setKey(key)
+	query := fmt.Sprintf(`select created_at from %s as t where key = $key and %s`, s.table(), liveRow("t"))
+	var created sql.NullInt64
+	if err := s.db.db.QueryRowContext(ctx, query, sql.Named("key", s.encodeKey(key)), nowArg()).Scan(&created); errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, blob.KeyNotFound(key)
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("created: %w", err)
+	} else if !created.Valid {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, created.Int64), nil
+}
+
+// Put implements part of [blob.KV].
+func (s KV) Put(ctx context.Context, opts blob.PutOptions) (err error) {
+	ctx, op := s.db.begin(ctx, "put", s.tableName)
+	defer op.end(&err)
+	var cs []change
+	defer s.db.changed(&cs, &err) // after the lock is released
+
+	s.db.txmu.Lock()
+	defer s.db.txmu.Unlock()
+
+	op.setKey(opts.Key)
+	op.setSize(len(opts.Data))
+	if _, err := s.prepare(ctx, s.putQuery(opts.Replace)); err != nil {
+		return fmt.Errorf("put: %w", err)
+	}
+	var added bool
+	var evicted []string
+	if err := withTxErr(ctx, s.db.sqlDB, func(tx *sql.Tx) (err error) {
+		added, err = s.putTx(ctx, tx, opts.Key, opts.Data, opts.Replace, 0)
+		if err == nil {
+			evicted, err = s.evictTx(ctx, tx)
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+	s.db.addLen(s.tableName, value.Cond[int64](added, 1, 0)-int64(len(evicted)))
+	cs = withDeletes([]change{{"put", opts.Key}}, evicted)
+	return nil
+}
+
+func (s KV) putQuery(replace bool) string {
+	verb := value.Cond(replace, "replace", "insert")
+	return fmt.Sprintf(`%s into %s (key, value, vsize, checksum, ref, expires_at, accessed_at, created_at, codec)
+  values ($key, $value, $vsize, $checksum, $ref, $expires, $now, coalesce($created, $now), $codec)`,
+		verb, s.table())
+}
+
+// putTx encodes and writes data for key within tx. If replace is false and
+// key is already present (and not expired), it reports [blob.ErrKeyExists].
+// If expires != 0, it is the expiration time in Unix nanoseconds.  It reports
+// whether key was newly added; the caller is responsible for updating the
+// cached length.
+func (s KV) putTx(ctx context.Context, tx *sql.Tx, key string, data []byte, replace bool, expires int64) (bool, error) {
+	buf := s.db.getBuf()
+	defer s.db.putBuf(buf) // the value is not used after the insert
+	value, err := s.encodeBlob(buf, data)
+	if err != nil {
+		return false, fmt.Errorf("put: %w", err)
+	}
+	return s.putEncodedTx(ctx, tx, key, len(data), data, value, s.codecID(), replace, expires)
+}
+
+// inContentTable reports whether a value whose encoding has length n is
+// stored in the content table (see [Options.Dedup] and [Options.Overflow]).
+func (s KV) inContentTable(n int) bool {
+	return s.db.dedup || (s.db.overflow > 0 && n >= s.db.overflow)
+}
+
+// needsDecoded reports whether writing a value whose encoding has length n
+// requires the decoded value, for its checksum or content hash.
+func (s KV) needsDecoded(n int) bool { return s.db.verify || s.inContentTable(n) }
+
+// putEncodedTx writes data for key within tx as putTx does, given value, the
+// encoding of data by the codec with the given ID, and size, the length of
+// data. The caller may pass nil for data unless s.needsDecoded(len(value)).
+func (s KV) putEncodedTx(ctx context.Context, tx *sql.Tx, key string, size int, data, value []byte, codec byte, replace bool, expires int64) (bool, error) {
+	if err := s.checkKey(key); err != nil {
+		return false, err
+	}
+	added, oldRef, created := true, []byte(nil), sql.NullInt64{}
+	if replace {
+		// Replacing a value preserves its creation time.
+		ok, ref, ctime, err := s.lookupRow(ctx, tx, key)
+		if err != nil {
+			return false, fmt.Errorf("put: %w", err)
+		}
+		added, oldRef, created = !ok, ref, ctime
+	}
+	st, err := s.stmt(ctx, tx, s.putQuery(replace))
+	if err != nil {
+		return false, fmt.Errorf("put: %w", err)
+	}
+	var sum any // NULL unless verification is enabled
+	if s.db.verify {
+		sum = int64(checksum(data))
+	}
+	var ref []byte
+	if s.inContentTable(len(value)) {
+		ref, err = s.db.retain(ctx, tx, contentHash(codec, data), value)
+		if err != nil {
+			return false, fmt.Errorf("put: %w", err)
+		}
+		value = []byte{} // the value is stored in the content table
+	}
+	args := []any{
+		sql.Named("key", s.encodeKey(key)),
+		sql.Named("value", value),
+		sql.Named("vsize", size),
+		sql.Named("checksum", sum),
+		sql.Named("ref", ref),
+		sql.Named("expires", sql.NullInt64{Int64: expires, Valid: expires != 0}),
+		sql.Named("created", created),
+		sql.Named("codec", codec),
+		nowArg(),
+	}
+	_, err = st.ExecContext(ctx, args...)
+	if s.db.isUniqueViolation(err) {
+		// An expired row does not count as present, so remove it and retry.
+		if ok, perr := s.purgeKeyTx(ctx, tx, key); perr != nil {
+			return false, fmt.Errorf("put: %w", perr)
+		} else if !ok {
+			return false, blob.KeyExists(key)
+		}
+		_, err = st.ExecContext(ctx, args...)
+		added = false // the expired row was already counted
+	}
+	if err != nil {
+		return false, fmt.Errorf("put: %w", err)
+	}
+	if oldRef != nil {
+		if err := s.db.release(ctx, tx, oldRef); err != nil {
+			return false, fmt.Errorf("put: %w", err)
+		}
+	}
+	return added, nil
+}
+
+// lookupRow reports whether key is present in the table of s within tx, and
+// if so returns its content reference (or nil if it does not have one) and
+// its creation time (or NULL if it does not have one or has expired).
+func (s KV) lookupRow(ctx context.Context, tx *sql.Tx, key string) (bool, []byte, sql.NullInt64, error) {
+	query := fmt.Sprintf(`select ref, case when %s then created_at end from %s as t where key = $key`,
+		liveRow("t"), s.table())
+	var ref []byte
+	var created sql.NullInt64
+	err := tx.QueryRowContext(ctx, query, sql.Named("key", s.encodeKey(key)), nowArg()).Scan(&ref, &created)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil, created, nil
+	}
+	return err == nil, ref, created, err
+}
+
+// PutIfAbsent writes data as the value of key if key is not already present,
+// and reports whether it did so. Unlike Put without Replace, a key that is
+// already present is not reported as an error.
+func (s KV) PutIfAbsent(ctx context.Context, key string, data []byte) (added bool, err error) {
+	ctx, op := s.db.begin(ctx, "putifabsent", s.tableName)
+	defer op.end(&err)
+	var cs []change
+	defer s.db.changed(&cs, &err) // after the lock is released
+
+	s.db.txmu.Lock()
+	defer s.db.txmu.Unlock()
+
+	op.setKey(key)
+	op.setSize(len(data))
+	var evicted []string
+	err = withTxErr(ctx, s.db.sqlDB, func(tx *sql.Tx) (err error) {
+		added, err = s.putTx(ctx, tx, key, data, false, 0)
+		if err == nil {
+			evicted, err = s.evictTx(ctx, tx)
+		}
+		return err
+	})
+	if blob.IsKeyExists(err) {
+		return false, nil // the transaction was rolled back
+	} else if err != nil {
+		return false, err
+	}
+	s.db.addLen(s.tableName, value.Cond[int64](added, 1, 0)-int64(len(evicted)))
+	cs = withDeletes([]change{{"put", key}}, evicted)
+	return true, nil
+}
+
+// ReplaceGet atomically replaces the value of key with data, and returns the
+// previous value. If key was not previously present, it is added, and
+// ReplaceGet returns nil, false.
+func (s KV) ReplaceGet(ctx context.Context, key string, data []byte) (old []byte, existed bool, err error) {
+	ctx, op := s.db.begin(ctx, "replaceget", s.tableName)
+	defer op.end(&err)
+	var cs []change
+	defer s.db.changed(&cs, &err) // after the lock is released
+
+	s.db.txmu.Lock()
+	defer s.db.txmu.Unlock()
+
+	op.setKey(key)
+	op.setSize(len(data))
+	var added bool
+	var evicted []string
+	if err := withTxErr(ctx, s.db.sqlDB, func(tx *sql.Tx) error {
+		v, err := s.getTx(ctx, tx, key)
+		if err == nil {
+			old, existed = v, true
+		} else if !blob.IsKeyNotFound(err) {
+			return err
+		}
+		// An expired row is not reported as existing, but it is replaced in
+		// place, so only putTx knows whether a row was added.
+		added, err = s.putTx(ctx, tx, key, data, true, 0)
+		if err != nil {
+			return err
+		}
+		evicted, err = s.evictTx(ctx, tx)
+		return err
+	}); err != nil {
+		return nil, false, err
+	}
+	s.db.addLen(s.tableName, value.Cond[int64](added, 1, 0)-int64(len(evicted)))
+	cs = withDeletes([]change{{"put", key}}, evicted)
+	return old, existed, nil
+}
+
+// Append atomically appends data to the value of key, adding key with value
+// data if it is not present. The complete value is read and rewritten, so the
+// cost of Append is proportional to the size of the resulting value.
+func (s KV) Append(ctx context.Context, key string, data []byte) (err error) {
+	ctx, op := s.db.begin(ctx, "append", s.tableName)
+	defer op.end(&err)
+	var cs []change
+	defer s.db.changed(&cs, &err) // after the lock is released
+
+	s.db.txmu.Lock()
+	defer s.db.txmu.Unlock()
+
+	op.setKey(key)
+	var added bool
+	var evicted []string
+	if err := withTxErr(ctx, s.db.sqlDB, func(tx *sql.Tx) error {
+		cur, err := s.getTx(ctx, tx, key)
+		if err != nil && !blob.IsKeyNotFound(err) {
+			return err
+		}
+		op.setSize(len(cur) + len(data))
+		added, err = s.putTx(ctx, tx, key, append(cur, data...), true, 0)
+		if err != nil {
+			return err
+		}
+		evicted, err = s.evictTx(ctx, tx)
+		return err
+	}); err != nil {
+		return err
+	}
+	s.db.addLen(s.tableName, value.Cond[int64](added, 1, 0)-int64(len(evicted)))
+	cs = withDeletes([]change{{"put", key}}, evicted)
+	return nil
+}
+
+// CompareAndSwap atomically replaces the value of key with newData, if its
+// current value is equal to expected, and reports whether the swap occurred.
+// If expected == nil, the swap occurs only if key is not present.  Note that
+// a non-nil empty expected value matches a present, empty value.
+func (s KV) CompareAndSwap(ctx context.Context, key string, expected, newData []byte) (swapped bool, err error) {
+	ctx, op := s.db.begin(ctx, "cas", s.tableName)
+	defer op.end(&err)
+	var cs []change
+	defer s.db.changed(&cs, &err) // after the lock is released
+
+	s.db.txmu.Lock()
+	defer s.db.txmu.Unlock()
+
+	op.setKey(key)
+	op.setSize(len(newData))
+	var added bool
+	var evicted []string
+	if err := withTxErr(ctx, s.db.sqlDB, func(tx *sql.Tx) error {
+		swapped, added = false, false // reset in case of retry
+		cur, err := s.getTx(ctx, tx, key)
+		if blob.IsKeyNotFound(err) {
+			if expected != nil {
+				return nil
+			}
+		} else if err != nil {
+			return err
+		} else if expected == nil || !bytes.Equal(cur, expected) {
+			return nil
+		}
+		added, err = s.putTx(ctx, tx, key, newData, true, 0)
+		if err != nil {
+			return err
+		}
+		swapped = true
+		evicted, err = s.evictTx(ctx, tx)
+		return err
+	}); err != nil {
+		return false, err
+	}
+	s.db.addLen(s.tableName, value.Cond[int64](added, 1, 0)-int64(len(evicted)))
+	if swapped {
+		cs = withDeletes([]change{{"put", key}}, evicted)
+	}
+	return swapped, nil
+}
+
+// Delete implements part of [blob.KV].
+func (s KV) Delete(ctx context.Context, key string) (err error) {
+	ctx, op := s.db.begin(ctx, "delete", s.tableName)
+	defer op.end(&err)
+	cs := []change{{"delete", key}}
+	defer s.db.changed(&cs, &err) // after the lock is released
+
+	s.db.txmu.Lock()
+	defer s.db.txmu.Unlock()
+
+	op.setKey(key)
+	if _, err := s.prepare(ctx, s.deleteQuery()); err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	if err := withTxErr(ctx, s.db.sqlDB, func(tx *sql.Tx) error {
+		return s.deleteTx(ctx, tx, key)
+	}); err != nil {
+		return err
+	}
+	s.db.addLen(s.tableName, -1)
+	return nil
+}
+
+func (s KV) deleteQuery() string {
+	return fmt.Sprintf(`delete from %[1]s where key = $key and %[2]s returning ref`, s.table(), liveRow(s.table()))
+}
+
+// deleteTx removes key within tx, and releases its content reference if it
+// has one. It reports [blob.ErrKeyNotFound] if key is not present or has
+// expired. The caller is responsible for updating the cached length.
+func (s KV) deleteTx(ctx context.Context, tx *sql.Tx, key string) error {
+	st, err := s.stmt(ctx, tx, s.deleteQuery())
+	if err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	var ref []byte
+	if err := st.QueryRowContext(ctx, sql.Named("key", s.encodeKey(key)), nowArg()).Scan(&ref); errors.Is(err, sql.ErrNoRows) {
+		return blob.KeyNotFound(key)
+	} else if err != nil {
+		return fmt.Errorf("delete: %w", err)
+	}
+	if ref != nil {
+		if err := s.db.release(ctx, tx, ref); err != nil {
+			return fmt.Errorf("delete: %w", err)
+		}
+	}
+	return nil
+}
+
+// deleteRowTx removes the row whose stored key is ekey within tx, whether or
+// not it has expired, and releases its content reference if it has one. It
+// is not an error if there is no such row. The caller is responsible for
+// updating the cached length.
+func (s KV) deleteRowTx(ctx context.Context, tx *sql.Tx, ekey any) error {
+	var ref []byte
+	err := tx.QueryRowContext(ctx, fmt.Sprintf(`delete from %s where key = $key returning ref`, s.table()),
+		sql.Named("key", ekey)).Scan(&ref)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if ref != nil {
+		return s.db.release(ctx, tx, ref)
+	}
+	return nil
+}
+
+// Move atomically changes the key of the value stored under from to to,
+// without reading or rewriting the value, which keeps its expiration,
+// access, and creation times. If from is not present in s, Move reports
+// [blob.ErrKeyNotFound]. If to is already present, Move replaces its value if
+// replace is true, and otherwise reports [blob.ErrKeyExists]. Moving a key to
+// itself has no effect, apart from checking that the key is present.
+func (s KV) Move(ctx context.Context, from, to string, replace bool) (err error) {
+	ctx, op := s.db.begin(ctx, "move", s.tableName)
+	defer op.end(&err)
+	var cs []change
+	defer s.db.changed(&cs, &err) // after the lock is released
+
+	if err := s.checkKey(to); err != nil {
+		return err
+	}
+
+	s.db.txmu.Lock()
+	defer s.db.txmu.Unlock()
+
+	op.setKey(from)
+	var removed bool // whether an existing row for to was deleted
+	if err := withTxErr(ctx, s.db.sqlDB, func(tx *sql.Tx) error {
+		removed = false // reset in case of retry
+		if ok, live, err := s.rowState(ctx, tx, from); err != nil {
+			return fmt.Errorf("move: %w", err)
+		} else if !ok || !live {
+			return blob.KeyNotFound(from)
+		} else if from == to {
+			return nil
+		}
+
+		// An expired row for to is replaced regardless, since it is absent.
+		if ok, live, err := s.rowState(ctx, tx, to); err != nil {
+			return fmt.Errorf("move: %w", err)
+		} else if ok && live && !replace {
+			return blob.KeyExists(to)
+		} else if ok {
+			if err := s.deleteRowTx(ctx, tx, s.encodeKey(to)); err != nil {
+				return fmt.Errorf("move: %w", err)
+			}
+			removed = true
+		}
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(`update %s set key = $to where key = $from`, s.table()),
+			sql.Named("to", s.encodeKey(to)), sql.Named("from", s.encodeKey(from)))
+		if err != nil {
+			return fmt.Errorf("move: %w", err)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if removed {
+		s.db.addLen(s.tableName, -1)
+	}
+	if from != to {
+		cs = []change{{"delete", from}, {"put", to}}
+	}
+	return nil
+}
+
+// rowState reports whether key has a row in the table of s within tx, and if
+// so, whether the row is live (that is, has not expired).
+func (s KV) rowState(ctx context.Context, tx *sql.Tx, key string) (ok, live bool, _ error) {
+	err := tx.QueryRowContext(ctx, fmt.Sprintf(`select %s from %s as t where key = $key`, liveRow("t"), s.table()),
+		sql.Named("key", s.encodeKey(key)), nowArg()).Scan(&live)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, false, nil
+	}
+	return err == nil, live, err
+}
+
+// List implements part of [blob.KV].
+func (s KV) List(ctx context.Context, start string, f func(string) error) (err error) {
+	ctx, op := s.db.begin(ctx, "list", s.tableName)
+	defer op.end(&err)
+
+	s.db.txmu.RLock()
+	defer s.db.txmu.RUnlock()
+
+	if _, err := s.prepare(ctx, s.listQuery()); err != nil {
+		return fmt.Errorf("list: %w", err)
+	}
+	// Since f may have side-effects, do not retry the transaction.
+	return runTx(ctx, s.db.sqlDB, func(tx *sql.Tx) error {
+		return s.listTx(ctx, tx, start, f)
+	})
+}
+
+func (s KV) listQuery() string {
+	return fmt.Sprintf(`select key from %s as t where key >= $start and %s order by key`, s.table(), liveRow("t"))
+}
+
+// listTx calls f with each key in s greater than or equal to start, in order,
+// within tx, as [KV.List] does.
+func (s KV) listTx(ctx context.Context, tx *sql.Tx, start string, f func(string) error) error {
+	st, err := s.stmt(ctx, tx, s.listQuery())
+	if err != nil {
+		return fmt.Errorf("list: %w", err)
+	}
+	rows, err := st.QueryContext(ctx, sql.Named("start", s.encodeStart(start)), nowArg())
+	if err != nil {
+		return fmt.Errorf("list: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key []byte
+		if err := rows.Scan(&key); err != nil {
+			return fmt.Errorf("list: %w", err)
+		}
+		skey, err := s.decodeKey(key)
+		if err != nil {
+			return fmt.Errorf("list: %w", err)
+		}
+		if err := f(skey); errors.Is(err, blob.ErrStopListing) {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+	return rows.Close()
+}
+
+// Keys returns a slice of all the keys in s greater than or equal to start,
+// in lexicographic order. Since all the keys are held in memory at once,
+// Keys is intended for small tables; for large tables, use List instead.
+func (s KV) Keys(ctx context.Context, start string) ([]string, error) {
+	var keys []string
+	if err := s.List(ctx, start, func(key string) error {
+		keys = append(keys, key)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Page returns up to limit keys of s in the order of List, beginning after
+// the position recorded by cursor, or at the first key if cursor == "". It
+// also returns a cursor for the next page, or "" if there are no more keys.
+//
+// Cursors are opaque strings, suitable for handing to the clients of a
+// stateless API. A cursor records the last key of its page, so it remains
+// valid, and pages do not repeat or skip keys, if keys are added to or
+// deleted from s between calls; keys added before the cursor are not seen.
+func (s KV) Page(ctx context.Context, cursor string, limit int) (keys []string, next string, err error) {
+	ctx, op := s.db.begin(ctx, "page", s.tableName)
+	defer op.end(&err)
+
+	if limit <= 0 {
+		return nil, "", fmt.Errorf("page: invalid limit %d", limit)
+	}
+	rel, after := ">=", ""
+	if cursor != "" {
+		last, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, "", fmt.Errorf("page: invalid cursor: %w", err)
+		} else if len(last) == 0 || last[0] != cursorVersion {
+			return nil, "", errors.New("page: invalid cursor")
+		}
+		rel, after = ">", string(last[1:])
+	}
+
+	s.db.txmu.RLock()
+	defer s.db.txmu.RUnlock()
+
+	// Read one key past the limit, to tell whether another page follows.
+	query := fmt.Sprintf(`select key from %s as t where key %s $start and %s order by key limit $limit`,
+		s.table(), rel, liveRow("t"))
+	keys, err = withTxValue(ctx, s.db.sqlDB, func(tx *sql.Tx) ([]string, error) {
+		rows, err := tx.QueryContext(ctx, query, sql.Named("start", s.encodeStart(after)),
+			sql.Named("limit", limit+1), nowArg())
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		var keys []string
+		for rows.Next() {
+			var ekey []byte
+			if err := rows.Scan(&ekey); err != nil {
+				return nil, err
+			}
+			key, err := s.decodeKey(ekey)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+		return keys, rows.Close()
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("page: %w", err)
+	}
+	if len(keys) > limit {
+		keys = keys[:limit]
+		next = base64.RawURLEncoding.EncodeToString(append([]byte{cursorVersion}, keys[limit-1]...))
+	}
+	return keys, next, nil
+}
+
+// cursorVersion is the first byte of each cursor reported by Page, before the
+// key it records. It ensures that a cursor is never empty, even for the
+// empty key.
+const cursorVersion = 1
+
+// scanTx calls f with each key and its decoded value in lexicographic order,
+// beginning with the first key greater than or equal to start, within tx.
+// If f reports an error, scanning stops and scanTx returns that error.
+func (s KV) scanTx(ctx context.Context, tx *sql.Tx, start string, f func(key string, data []byte) error) error {
+	_, err := s.scanFromTx(ctx, tx, ">=", s.encodeStart(start), f)
+	return err
+}
+
+// scanFromTx calls f with each key and its decoded value in the order of
+// their stored representations, beginning with the first key whose stored
+// representation compares to from by rel (">=" or ">"), within tx. It
+// reports the stored representation of the last key passed to f, or nil if
+// there were none, so that a later scan can resume after it. If f reports an
+// error, scanning stops and scanFromTx returns that error.
+func (s KV) scanFromTx(ctx context.Context, tx *sql.Tx, rel string, from any, f func(key string, data []byte) error) (last any, _ error) {
+	query := fmt.Sprintf(`select t.key, coalesce(c.value, t.value), %s, t.checksum from %s as t
+  left join %s as c on c.hash = t.ref
+  where t.key %s $from and %s order by t.key`, rowCodec, s.table(), s.db.contentIdent(), rel, liveRow("t"))
+	rows, err := tx.QueryContext(ctx, query, sql.Named("from", from), nowArg())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var skey any // as stored
+		var data []byte
+		var codec byte
+		var sum sql.NullInt64
+		if err := rows.Scan(&skey, &data, &codec, &sum); err != nil {
+			return last, err
+		}
+		key, err := s.decodeKey(storedBytes(skey))
+		if err != nil {
+			return last, err
+		}
+		value, err := s.decodeValue(key, data, codec, sum)
+		if err != nil {
+			return last, err
+		}
+		last = storedKey(skey)
+		if err := f(key, value); err != nil {
+			return last, err
+		}
+	}
+	return last, rows.Close()
+}
+
+// storedKey returns skey, a key as read from the database, in a form that can
+// be bound as a query argument to match it. The driver reports an empty blob
+// as nil, which would otherwise be bound as NULL.
+func storedKey(skey any) any {
+	if b, ok := skey.([]byte); ok && b == nil {
+		return []byte{}
+	}
+	return skey
+}
+
+// storedBytes returns the bytes of a key as stored in the database, which
+// may be text or a blob.
+func storedBytes(skey any) []byte {
+	if str, ok := skey.(string); ok {
+		return []byte(str)
+	}
+	b, _ := skey.([]byte)
+	return b
+}
+
+// Len implements part of [blob.KV]. Keys that have expired (see
+// [KV.PutTTL]) are counted until they are purged.
+func (s KV) Len(ctx context.Context) (_ int64, err error) {
+	ctx, op := s.db.begin(ctx, "len", s.tableName)
+	defer op.end(&err)
+
+	s.db.txmu.RLock()
+	defer s.db.txmu.RUnlock()
+
+	if n, ok := s.db.lens[s.tableName]; ok {
+		return n, nil
+	}
+	query := fmt.Sprintf(`select count(*) from %s`, s.table())
+	st, err := s.prepare(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("len: %w", err)
+	}
+	return withTxValue(ctx, s.db.sqlDB, func(tx *sql.Tx) (int64, error) {
+		row := tx.StmtContext(ctx, st).QueryRowContext(ctx)
+		var nr int64
+		if err := row.Scan(&nr); err != nil {
+			return 0, err
+		}
+		return nr, nil
+	})
+}
+
+// CountPrefix reports the number of keys in s that begin with prefix, without
+// listing them. As with [KV.Len], keys that have expired are counted until
+// they are purged, and an empty prefix counts all the keys in s. If s uses a
+// [KeyCodec], the stored order of keys is unknown, so CountPrefix lists all
+// the keys (as reported by [KV.List]) and counts those with the prefix.
+func (s KV) CountPrefix(ctx context.Context, prefix string) (_ int64, err error) {
+	if prefix == "" {
+		return s.Len(ctx)
+	} else if s.db.keyCodec != nil {
+		var n int64
+		if err := s.List(ctx, "", func(key string) error {
+			if strings.HasPrefix(key, prefix) {
+				n++
+			}
+			return nil
+		}); err != nil {
+			return 0, fmt.Errorf("count prefix: %w", err)
+		}
+		return n, nil
+	}
+	ctx, op := s.db.begin(ctx, "countprefix", s.tableName)
+	defer op.end(&err)
+
+	s.db.txmu.RLock()
+	defer s.db.txmu.RUnlock()
+
+	return withTxValue(ctx, s.db.sqlDB, func(tx *sql.Tx) (int64, error) {
+		var total int64
+		for _, r := range s.prefixRanges(prefix) {
+			query := fmt.Sprintf(`select count(*) from %s where key >= $lo and typeof(key) = $type`, s.table())
+			args := []any{sql.Named("lo", r.lo), sql.Named("type", r.kind)}
+			if r.hi != nil {
+				query += ` and key < $hi`
+				args = append(args, sql.Named("hi", r.hi))
+			}
+			var n int64
+			if err := tx.QueryRowContext(ctx, query, args...).Scan(&n); err != nil {
+				return 0, fmt.Errorf("count prefix: %w", err)
+			}
+			total += n
+		}
+		return total, nil
+	})
+}
+
+// SizePrefix reports the total size in bytes of the values of the keys in s
+// that begin with prefix, both before encoding (logical, as reported by
+// [KV.Size]) and as stored (stored). A value shared by several keys (see
+// [Options.Dedup]) is counted once for each of them. As with
+// [KV.CountPrefix], values of expired keys are counted until they are purged,
+// an empty prefix reports the totals for all of s, and if s uses a
+// [KeyCodec], SizePrefix reads all the keys and sums those with the prefix.
+func (s KV) SizePrefix(ctx context.Context, prefix string) (logical, stored int64, err error) {
+	ctx, op := s.db.begin(ctx, "sizeprefix", s.tableName)
+	defer op.end(&err)
+
+	s.db.txmu.RLock()
+	defer s.db.txmu.RUnlock()
+
+	from := fmt.Sprintf(`from %s as t left join %s as c on c.hash = t.ref`, s.table(), s.db.contentIdent())
+	const storedSize = `coalesce(length(coalesce(c.value, t.value)), 0)`
+	err = withTxErr(ctx, s.db.sqlDB, func(tx *sql.Tx) error {
+		logical, stored = 0, 0 // reset in case of retry
+		if prefix != "" && s.db.keyCodec != nil {
+			rows, err := tx.QueryContext(ctx, `select t.key, t.vsize, `+storedSize+` `+from)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+			for rows.Next() {
+				var ekey []byte
+				var vsize, size int64
+				if err := rows.Scan(&ekey, &vsize, &size); err != nil {
+					return err
+				}
+				key, err := s.decodeKey(ekey)
+				if err != nil {
+					return err
+				}
+				if strings.HasPrefix(key, prefix) {
+					logical += vsize
+					stored += size
+				}
+			}
+			return rows.Close()
+		}
+
+		query := `select coalesce(sum(t.vsize), 0), coalesce(sum(` + storedSize + `), 0) ` + from
+		ranges := []keyRange{{}} // an empty prefix selects all keys
+		if prefix != "" {
+			ranges = s.prefixRanges(prefix)
+		}
+		for _, r := range ranges {
+			q, args := query, []any(nil)
+			if r.kind != "" {
+				q += ` where t.key >= $lo and typeof(t.key) = $type`
+				args = append(args, sql.Named("lo", r.lo), sql.Named("type", r.kind))
+				if r.hi != nil {
+					q += ` and t.key < $hi`
+					args = append(args, sql.Named("hi", r.hi))
+				}
+			}
+			var l, n int64
+			if err := tx.QueryRowContext(ctx, q, args...).Scan(&l, &n); err != nil {
+				return err
+			}
+			logical += l
+			stored += n
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("size prefix: %w", err)
+	}
+	return logical, stored, nil
+}
+
+// A keyRange is a range of stored keys of one type (as reported by the SQL
+// typeof function), from lo inclusive to hi exclusive. If hi == nil, the
+// range has no upper bound.
+type keyRange struct {
+	kind   string
+	lo, hi any
+}
+
+// prefixRanges returns the ranges of stored keys that hold the keys
+// beginning with prefix, which must be non-empty. It does not handle key
+// codecs, which need not preserve the order of keys.
+func (s KV) prefixRanges(prefix string) []keyRange {
+	hi := prefixSuccessor([]byte(prefix))
+	switch s.db.keys {
+	case RawKeys:
+		return []keyRange{blobRange(prefix, hi)}
+	case TextKeys:
+		out := []keyRange{{kind: "text", lo: prefix}}
+		if hi != nil {
+			out[0].hi = string(hi)
+		}
+		if s.db.binaryKeys {
+			out = append(out, blobRange(prefix, hi))
+		}
+		return out
+	}
+	// Hex digits sort in the same order as the bytes they encode, and any
+	// hex string with a given prefix sorts below the prefix followed by "g".
+	hp := hexKey(prefix)
+	return []keyRange{{kind: "text", lo: hp, hi: hp + "g"}}
+}
+
+func blobRange(prefix string, hi []byte) keyRange {
+	r := keyRange{kind: "blob", lo: []byte(prefix)}
+	if hi != nil {
+		r.hi = hi
+	}
+	return r
+}
+
+// prefixSuccessor returns the least byte string greater than all the strings
+// beginning with p, or nil if there is none because p consists entirely of
+// 0xff bytes.
+func prefixSuccessor(p []byte) []byte {
+	for i := len(p) - 1; i >= 0; i-- {
+		if p[i] != 0xff {
+			succ := append([]byte{}, p[:i+1]...)
+			succ[i]++
+			return succ
+		}
+	}
+	return nil
+}
+
+// Resync recomputes the cached key count reported by Len, if the FastLen
+// option is enabled. Call Resync if the table of s may have been modified by
+// another process.
+func (s KV) Resync(ctx context.Context) error {
+	if !s.db.fastLen {
+		return nil
+	}
+	s.db.txmu.Lock()
+	defer s.db.txmu.Unlock()
+	return withTxErr(ctx, s.db.sqlDB, func(tx *sql.Tx) error {
+		return s.db.countRows(ctx, tx, s.tableName)
+	})
+}
+
+// Size reports the total size in bytes of the values stored in s, prior to
+// compression.
+func (s KV) Size(ctx context.Context) (_ int64, err error) {
+	ctx, op := s.db.begin(ctx, "size", s.tableName)
+	defer op.end(&err)
+
+	s.db.txmu.RLock()
+	defer s.db.txmu.RUnlock()
+
+	query := fmt.Sprintf(`select coalesce(sum(vsize), 0) from %s`, s.table())
+	st, err := s.prepare(ctx, query)
+	if err != nil {
+		return 0, fmt.Errorf("size: %w", err)
+	}
+	return withTxValue(ctx, s.db.sqlDB, func(tx *sql.Tx) (int64, error) {
+		row := tx.StmtContext(ctx, st).QueryRowContext(ctx)
+		var size int64
+		if err := row.Scan(&size); err != nil {
+			return 0, err
+		}
+		return size, nil
+	})
+}
+
+// A stmtCache caches prepared statements, grouped by table name.
+// Callers must hold the txmu of the monitor while using a statement from the
+// cache, and must hold it exclusively to close statements.
+//
+// How much this saves depends on the driver: Drivers that retain compiled
+// statements avoid re-parsing the SQL on each call, but some (including the
+// default modernc.org/sqlite driver as of v1.34) compile on each execution.
+// Even so, reusing a statement saves the cost of preparing it through
+// database/sql, roughly 10% of a small Get with the default driver (see
+// BenchmarkStmtCache).
+type stmtCache struct {
+	μ     sync.Mutex
+	stmts map[string]map[string]*sql.Stmt // table → query → stmt
+}
+
+func newStmtCache() *stmtCache {
+	return &stmtCache{stmts: make(map[string]map[string]*sql.Stmt)}
+}
+
+// prepare returns a prepared statement for query on table, preparing it on
+// db if it is not already cached.
+func (c *stmtCache) prepare(ctx context.Context, db *sql.DB, table, query string) (*sql.Stmt, error) {
+	c.μ.Lock()
+	defer c.μ.Unlock()
+	if st, ok := c.stmts[table][query]; ok {
+		return st, nil
+	}
+	st, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if c.stmts[table] == nil {
+		c.stmts[table] = make(map[string]*sql.Stmt)
+	}
+	c.stmts[table][query] = st
+	return st, nil
+}
+
+// lookup returns the cached statement for query on table, or nil.
+func (c *stmtCache) lookup(table, query string) *sql.Stmt {
+	c.μ.Lock()
+	defer c.μ.Unlock()
+	return c.stmts[table][query]
+}
+
+// closeTable closes and discards all the statements cached for table.
+func (c *stmtCache) closeTable(table string) error {
+	c.μ.Lock()
+	defer c.μ.Unlock()
+	var errs []error
+	for _, st := range c.stmts[table] {
+		errs = append(errs, st.Close())
+	}
+	delete(c.stmts, table)
+	return errors.Join(errs...)
+}
+
+// closeAll closes and discards all the statements in the cache.
+func (c *stmtCache) closeAll() error {
+	c.μ.Lock()
+	defer c.μ.Unlock()
+	var errs []error
+	for _, tab := range c.stmts {
+		for _, st := range tab {
+			errs = append(errs, st.Close())
+		}
+	}
+	clear(c.stmts)
+	return errors.Join(errs...)
+}
+
+func withTxValue[T any](ctx context.Context, d *sqlDB, f func(*sql.Tx) (T, error)) (T, error) {
+	var v T
+	err := withTxErr(ctx, d, func(tx *sql.Tx) (err error) {
+		v, err = f(tx)
+		return err
+	})
+	return v, err
+}
+
+// withTxErr calls f in a transaction on d, and commits the transaction if f
+// succeeds. If the transaction fails because the database is busy or locked,
+// it is retried, calling f again, up to the retry limit of d, with
+// exponential backoff between attempts.
+func withTxErr(ctx context.Context, d *sqlDB, f func(*sql.Tx) error) error {
+	delay := d.retryDelay
+	for i := 0; ; i++ {
+		err := runTx(ctx, d, f)
+		if i >= d.retries || !d.isBusy(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+			delay *= 2
+		}
+	}
+}
+
+// runTx calls f in a transaction on d, and commits the transaction if f
+// succeeds. Errors from the database are classified by [sqlDB.classifyError].
+func runTx(ctx context.Context, d *sqlDB, f func(*sql.Tx) error) error {
+	return d.classifyError(inTx(ctx, d.db, f))
 }
 
-func withTxErr(ctx context.Context, db *sql.DB, f func(*sql.Tx) error) error {
+// inTx calls f in a transaction on db, and commits the transaction if f
+// succeeds.
+func inTx(ctx context.Context, db *sql.DB, f func(*sql.Tx) error) error {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
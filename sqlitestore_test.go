@@ -3,11 +3,40 @@
 package sqlitestore_test
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"math/rand/v2"
+	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/ffs/blob/memstore"
 	"github.com/creachadair/ffs/blob/storetest"
+	"github.com/creachadair/ffs/storage/dbkey"
+	"github.com/creachadair/mds/value"
 	"github.com/creachadair/sqlitestore"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 func TestStore(t *testing.T) {
@@ -23,6 +52,18 @@ func TestStore(t *testing.T) {
 		storetest.Run(t, db)
 	})
 
+	t.Run("Exclusive", func(t *testing.T) {
+		url := "file:" + filepath.Join(t.TempDir(), "test.db")
+		db, err := sqlitestore.New(url, &sqlitestore.Options{
+			PoolSize:    4, // overridden
+			LockingMode: "exclusive",
+		})
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		storetest.Run(t, db)
+	})
+
 	t.Run("Compressed", func(t *testing.T) {
 		url := "file:" + filepath.Join(t.TempDir(), "test.db")
 		db, err := sqlitestore.New(url, &sqlitestore.Options{
@@ -34,4 +75,4839 @@ func TestStore(t *testing.T) {
 		}
 		storetest.Run(t, db)
 	})
+
+	t.Run("Dedup", func(t *testing.T) {
+		url := "file:" + filepath.Join(t.TempDir(), "test.db")
+		db, err := sqlitestore.New(url, &sqlitestore.Options{
+			PoolSize: 4,
+			Dedup:    true,
+		})
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		storetest.Run(t, db)
+	})
+
+	t.Run("FastLen", func(t *testing.T) {
+		url := "file:" + filepath.Join(t.TempDir(), "test.db")
+		db, err := sqlitestore.New(url, &sqlitestore.Options{
+			PoolSize: 4,
+			FastLen:  true,
+		})
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		storetest.Run(t, db)
+	})
+	t.Run("RawKeys", func(t *testing.T) {
+		url := "file:" + filepath.Join(t.TempDir(), "test.db")
+		db, err := sqlitestore.New(url, &sqlitestore.Options{
+			PoolSize:    4,
+			KeyEncoding: sqlitestore.RawKeys,
+		})
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		storetest.Run(t, db)
+	})
+
+	t.Run("TextKeys", func(t *testing.T) {
+		url := "file:" + filepath.Join(t.TempDir(), "test.db")
+		db, err := sqlitestore.New(url, &sqlitestore.Options{
+			PoolSize:        4,
+			KeyEncoding:     sqlitestore.TextKeys,
+			AllowBinaryKeys: true,
+		})
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		storetest.Run(t, db)
+	})
+
+	t.Run("WithoutRowID", func(t *testing.T) {
+		url := "file:" + filepath.Join(t.TempDir(), "test.db")
+		db, err := sqlitestore.New(url, &sqlitestore.Options{
+			PoolSize:     4,
+			WithoutRowID: true,
+		})
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		storetest.Run(t, db)
+	})
+
+	t.Run("CoveringIndex", func(t *testing.T) {
+		url := "file:" + filepath.Join(t.TempDir(), "test.db")
+		db, err := sqlitestore.New(url, &sqlitestore.Options{
+			PoolSize:      4,
+			CoveringIndex: true,
+		})
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		storetest.Run(t, db)
+	})
+}
+
+// newTestStore constructs a store in a temporary file with the given options.
+// The store is closed when the test ends. It returns the store and its URL.
+func newTestStore(t *testing.T, opts *sqlitestore.Options) (sqlitestore.Store, string) {
+	t.Helper()
+	url := "file:" + filepath.Join(t.TempDir(), "test.db")
+	s, err := sqlitestore.New(url, opts)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	t.Cleanup(func() { s.Close(context.Background()) })
+	return s, url
+}
+
+// mustKV returns the named KV from s, or fails t.
+func mustKV(t *testing.T, s sqlitestore.Store, name string) sqlitestore.KV {
+	t.Helper()
+	kv, err := s.KV(context.Background(), name)
+	if err != nil {
+		t.Fatalf("KV %q failed: %v", name, err)
+	}
+	return kv.(sqlitestore.KV)
+}
+
+// openRaw opens a separate database handle on url for direct manipulation.
+func openRaw(t *testing.T, url string) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", url)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// hasTable reports whether db contains a table with the given name.
+func hasTable(t *testing.T, db *sql.DB, name string) bool {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(`select count(*) from sqlite_master where type = 'table' and name = $name`,
+		sql.Named("name", name)).Scan(&n); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	return n != 0
+}
+
+func TestInvalidStoredKey(t *testing.T) {
+	ctx := context.Background()
+	s, url := newTestStore(t, nil)
+	kv := mustKV(t, s, "test")
+
+	// Write a row whose key is not valid hex directly into the table.
+	db := openRaw(t, url)
+	tab := dbkey.Prefix("").Keyspace("test").String()
+	if _, err := db.Exec(fmt.Sprintf(`insert into "%s" (key, value, vsize) values ('xyzzy', x'', 0)`, tab)); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	err := kv.List(ctx, "", func(string) error { return nil })
+	if err == nil {
+		t.Error("List: got nil error, want invalid key")
+	} else {
+		t.Logf("List: got expected error: %v", err)
+	}
+}
+
+func TestCorruptValue(t *testing.T) {
+	ctx := context.Background()
+	s, url := newTestStore(t, nil)
+	kv := mustKV(t, s, "test")
+
+	// Write a row whose value is not valid Snappy data.
+	db := openRaw(t, url)
+	tab := dbkey.Prefix("").Keyspace("test").String()
+	if _, err := db.Exec(fmt.Sprintf(`insert into "%s" (key, value, vsize) values ($key, $value, 5)`, tab),
+		sql.Named("key", hex.EncodeToString([]byte("bad"))),
+		sql.Named("value", []byte("\xff\xff\xff\xff\xff")),
+	); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+
+	got, err := kv.Get(ctx, "bad")
+	if !errors.Is(err, sqlitestore.ErrCorruptValue) {
+		t.Fatalf("Get: got (%q, %v), want %v", got, err, sqlitestore.ErrCorruptValue)
+	}
+	var kerr *blob.KeyError
+	if !errors.As(err, &kerr) || kerr.Key != "bad" {
+		t.Errorf("Get: error %v does not identify key %q", err, "bad")
+	}
+}
+
+func TestTableName(t *testing.T) {
+	t.Run("Valid", func(t *testing.T) {
+		ctx := context.Background()
+		s, url := newTestStore(t, &sqlitestore.Options{Table: "blobs"})
+		kv := mustKV(t, s, "test")
+		if err := kv.Put(ctx, blob.PutOptions{Key: "k", Data: []byte("v")}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		tab := "blobs_" + dbkey.Prefix("").Keyspace("test").String()
+		if !hasTable(t, openRaw(t, url), tab) {
+			t.Errorf("Table %q not found", tab)
+		}
+	})
+
+	for _, bad := range []string{
+		`x" (key BLOB); drop table "y`,
+		"has space",
+		"9lives",
+		"semi;colon",
+	} {
+		url := "file:" + filepath.Join(t.TempDir(), "test.db")
+		if s, err := sqlitestore.New(url, &sqlitestore.Options{Table: bad}); err == nil {
+			s.Close(context.Background())
+			t.Errorf("New(Table=%q): got nil error, want invalid table name", bad)
+		}
+	}
+}
+
+func TestOpener(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	t.Run("Table", func(t *testing.T) {
+		path := filepath.Join(dir, "table.db")
+		s, err := sqlitestore.Opener(ctx, "file:"+path+"?table=blobs&poolsize=2")
+		if err != nil {
+			t.Fatalf("Opener failed: %v", err)
+		}
+		defer s.Close(ctx)
+		kv, err := s.KV(ctx, "test")
+		if err != nil {
+			t.Fatalf("KV failed: %v", err)
+		}
+		if err := kv.Put(ctx, blob.PutOptions{Key: "k", Data: []byte("v")}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		tab := "blobs_" + dbkey.Prefix("").Keyspace("test").String()
+		if !hasTable(t, openRaw(t, "file:"+path), tab) {
+			t.Errorf("Table %q not found", tab)
+		}
+	})
+
+	t.Run("Compress", func(t *testing.T) {
+		for i, c := range []string{"true", "false", "snappy", "gzip", "none", "SNAPPY", "0"} {
+			path := filepath.Join(dir, fmt.Sprintf("compress-%d.db", i))
+			s, err := sqlitestore.Opener(ctx, "file:"+path+"?compress="+c)
+			if err != nil {
+				t.Errorf("Opener(compress=%s) failed: %v", c, err)
+				continue
+			}
+			s.Close(ctx)
+		}
+		for _, c := range []string{"zip", "maybe"} {
+			path := filepath.Join(dir, "bad-compress.db")
+			if s, err := sqlitestore.Opener(ctx, "file:"+path+"?compress="+c); err == nil {
+				s.Close(ctx)
+				t.Errorf("Opener(compress=%s): got nil error, want unknown codec", c)
+			}
+		}
+	})
+
+	t.Run("Tuning", func(t *testing.T) {
+		path := filepath.Join(dir, "tuning.db")
+		s, err := sqlitestore.Opener(ctx, "file:"+path+"?mmapsize=1048576&cachesize=-4000")
+		if err != nil {
+			t.Fatalf("Opener failed: %v", err)
+		}
+		defer s.Close(ctx)
+		kv, err := s.KV(ctx, "test")
+		if err != nil {
+			t.Fatalf("KV failed: %v", err)
+		}
+		putAll(t, kv, testData)
+
+		// The options are applied to every connection in the pool.
+		rec := recordConns()
+		rec.mu.Lock()
+		rec.conns = nil
+		rec.mu.Unlock()
+		rs, err := sqlitestore.New("file:"+path, &sqlitestore.Options{
+			Driver:       "sqlite-recorder",
+			PoolSize:     4,
+			MaxIdleConns: 4, // keep all the connections open for inspection
+			MMapSize:     1048576,
+			CacheSize:    -4000,
+		})
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		defer rs.Close(ctx)
+		const numReaders = 3
+		holdConns(ctx, mustKV(t, rs, "test"), numReaders)
+
+		rec.mu.Lock()
+		conns := slices.Clone(rec.conns)
+		rec.mu.Unlock()
+		if len(conns) < numReaders {
+			t.Fatalf("Opened %d connections, want at least %d", len(conns), numReaders)
+		}
+		for i, conn := range conns {
+			if v := queryInt(t, conn, `pragma mmap_size`); v != 1048576 {
+				t.Errorf("Connection %d: mmap_size = %d, want 1048576", i, v)
+			}
+			if v := queryInt(t, conn, `pragma cache_size`); v != -4000 {
+				t.Errorf("Connection %d: cache_size = %d, want -4000", i, v)
+			}
+		}
+
+		for _, q := range []string{"mmapsize=big", "cachesize=1.5"} {
+			if s, err := sqlitestore.Opener(ctx, "file:"+path+"?"+q); err == nil {
+				s.Close(ctx)
+				t.Errorf("Opener(%s): got nil error, want invalid", q)
+			}
+		}
+	})
+
+	t.Run("BadTable", func(t *testing.T) {
+		path := filepath.Join(dir, "bad.db")
+		if s, err := sqlitestore.Opener(ctx, "file:"+path+"?table=a%22b"); err == nil {
+			s.Close(ctx)
+			t.Error("Opener: got nil error, want invalid table name")
+		}
+	})
+}
+
+func TestPing(t *testing.T) {
+	s, _ := newTestStore(t, nil)
+	if err := s.Ping(context.Background()); err != nil {
+		t.Errorf("Ping: unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Ping(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Ping: got %v, want %v", err, context.Canceled)
+	}
+}
+
+// testTracer is a trace.TracerProvider that records spans in memory.
+type testTracer struct {
+	noop.TracerProvider
+	spans []*testSpan
+}
+
+func (t *testTracer) Tracer(string, ...trace.TracerOption) trace.Tracer { return testTracerImpl{t: t} }
+
+type testTracerImpl struct {
+	noop.Tracer
+	t *testTracer
+}
+
+func (t testTracerImpl) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	cfg := trace.NewSpanStartConfig(opts...)
+	span := &testSpan{name: name, attrs: cfg.Attributes()}
+	t.t.spans = append(t.t.spans, span)
+	return ctx, span
+}
+
+type testSpan struct {
+	noop.Span
+	name  string
+	attrs []attribute.KeyValue
+	err   error
+	ended bool
+}
+
+func (s *testSpan) SetAttributes(kv ...attribute.KeyValue)        { s.attrs = append(s.attrs, kv...) }
+func (s *testSpan) RecordError(err error, _ ...trace.EventOption) { s.err = err }
+func (s *testSpan) End(...trace.SpanEndOption)                    { s.ended = true }
+
+func (s *testSpan) attr(key string) string {
+	for _, kv := range s.attrs {
+		if string(kv.Key) == key {
+			return kv.Value.Emit()
+		}
+	}
+	return ""
+}
+
+func TestTracing(t *testing.T) {
+	ctx := context.Background()
+	tp := new(testTracer)
+	s, _ := newTestStore(t, &sqlitestore.Options{TracerProvider: tp, TraceKeys: true})
+	kv := mustKV(t, s, "test")
+
+	if err := kv.Put(ctx, blob.PutOptions{Key: "k", Data: []byte("value")}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, err := kv.Get(ctx, "nonesuch"); !blob.IsKeyNotFound(err) {
+		t.Fatalf("Get: got %v, want %v", err, blob.ErrKeyNotFound)
+	}
+
+	if len(tp.spans) != 2 {
+		t.Fatalf("Got %d spans, want 2", len(tp.spans))
+	}
+	put, get := tp.spans[0], tp.spans[1]
+	if put.name != "sqlitestore.put" || !put.ended || put.err != nil {
+		t.Errorf("Put span: got %q ended=%v err=%v", put.name, put.ended, put.err)
+	}
+	if got := put.attr("sqlitestore.key"); got != "k" {
+		t.Errorf("Put span key: got %q, want %q", got, "k")
+	}
+	if got := put.attr("sqlitestore.size"); got != "5" {
+		t.Errorf("Put span size: got %q, want %q", got, "5")
+	}
+	if get.name != "sqlitestore.get" || !get.ended || !blob.IsKeyNotFound(get.err) {
+		t.Errorf("Get span: got %q ended=%v err=%v", get.name, get.ended, get.err)
+	}
+}
+
+func TestSlowLog(t *testing.T) {
+	ctx := context.Background()
+	for _, logKeys := range []bool{false, true} {
+		var buf bytes.Buffer
+		s, _ := newTestStore(t, &sqlitestore.Options{
+			SlowThreshold: time.Nanosecond, // everything is slow
+			Logger:        slog.New(slog.NewTextHandler(&buf, nil)),
+			LogKeys:       logKeys,
+		})
+		kv := mustKV(t, s, "test")
+		if err := kv.Put(ctx, blob.PutOptions{Key: "secret-key", Data: []byte("v")}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		log := buf.String()
+		if !strings.Contains(log, "op=put") {
+			t.Errorf("Log does not mention the put operation:\n%s", log)
+		}
+		if got := strings.Contains(log, "secret-key"); got != logKeys {
+			t.Errorf("LogKeys=%v: key logged=%v, want %v:\n%s", logKeys, got, logKeys, log)
+		}
+	}
+}
+
+func BenchmarkGet(b *testing.B) {
+	ctx := context.Background()
+	url := "file:" + filepath.Join(b.TempDir(), "bench.db")
+	s, err := sqlitestore.New(url, nil)
+	if err != nil {
+		b.Fatalf("New failed: %v", err)
+	}
+	defer s.Close(ctx)
+	kv, err := s.KV(ctx, "bench")
+	if err != nil {
+		b.Fatalf("KV failed: %v", err)
+	}
+	const numKeys = 100
+	for i := range numKeys {
+		if err := kv.Put(ctx, blob.PutOptions{
+			Key:  fmt.Sprintf("key-%d", i),
+			Data: []byte(fmt.Sprintf("value-%d", i)),
+		}); err != nil {
+			b.Fatalf("Put failed: %v", err)
+		}
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		if _, err := kv.Get(ctx, fmt.Sprintf("key-%d", i%numKeys)); err != nil {
+			b.Fatalf("Get failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkStmtCache compares reads using the cached prepared statements of
+// a KV with reads that prepare their statements each time, by discarding the
+// cache with Close before each read.
+func BenchmarkStmtCache(b *testing.B) {
+	ctx := context.Background()
+	s, err := sqlitestore.New("file:"+filepath.Join(b.TempDir(), "bench.db"), nil)
+	if err != nil {
+		b.Fatalf("New failed: %v", err)
+	}
+	defer s.Close(ctx)
+	kv, err := s.KV(ctx, "bench")
+	if err != nil {
+		b.Fatalf("KV failed: %v", err)
+	}
+	const numKeys = 100
+	keys := make([]string, numKeys)
+	for i := range numKeys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		if err := kv.Put(ctx, blob.PutOptions{Key: keys[i], Data: []byte(keys[i])}); err != nil {
+			b.Fatalf("Put failed: %v", err)
+		}
+	}
+	for _, cached := range []bool{true, false} {
+		b.Run(fmt.Sprintf("cached=%v", cached), func(b *testing.B) {
+			for i := range b.N {
+				if !cached {
+					kv.(blob.Closer).Close(ctx)
+				}
+				if _, err := kv.Get(ctx, keys[i%numKeys]); err != nil {
+					b.Fatalf("Get failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkPut(b *testing.B) {
+	ctx := context.Background()
+	url := "file:" + filepath.Join(b.TempDir(), "bench.db")
+	s, err := sqlitestore.New(url, nil)
+	if err != nil {
+		b.Fatalf("New failed: %v", err)
+	}
+	defer s.Close(ctx)
+	kv, err := s.KV(ctx, "bench")
+	if err != nil {
+		b.Fatalf("KV failed: %v", err)
+	}
+	const numKeys = 100
+	value := bytes.Repeat([]byte("value "), 1<<10)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := range b.N {
+		if err := kv.Put(ctx, blob.PutOptions{
+			Key: fmt.Sprintf("key-%d", i%numKeys), Data: value, Replace: true,
+		}); err != nil {
+			b.Fatalf("Put failed: %v", err)
+		}
+	}
+}
+
+func BenchmarkKeyEncoding(b *testing.B) {
+	ctx := context.Background()
+	for _, enc := range []sqlitestore.KeyEncoding{sqlitestore.HexKeys, sqlitestore.TextKeys} {
+		b.Run(fmt.Sprintf("Encoding=%d", enc), func(b *testing.B) {
+			url := "file:" + filepath.Join(b.TempDir(), "bench.db")
+			s, err := sqlitestore.New(url, &sqlitestore.Options{KeyEncoding: enc})
+			if err != nil {
+				b.Fatalf("New failed: %v", err)
+			}
+			defer s.Close(ctx)
+			kv, err := s.KV(ctx, "bench")
+			if err != nil {
+				b.Fatalf("KV failed: %v", err)
+			}
+			key := strings.Repeat("hot-key/", 8)
+			if err := kv.Put(ctx, blob.PutOptions{Key: key, Data: []byte("value")}); err != nil {
+				b.Fatalf("Put failed: %v", err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for range b.N {
+				if _, err := kv.Get(ctx, key); err != nil {
+					b.Fatalf("Get failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkBatchGet(b *testing.B) {
+	ctx := context.Background()
+	url := "file:" + filepath.Join(b.TempDir(), "bench.db")
+	s, err := sqlitestore.New(url, &sqlitestore.Options{Uncompressed: true})
+	if err != nil {
+		b.Fatalf("New failed: %v", err)
+	}
+	bkv, err := s.KV(ctx, "bench")
+	if err != nil {
+		b.Fatalf("KV failed: %v", err)
+	}
+	kv := bkv.(sqlitestore.KV)
+	const numKeys = 5000
+	keys := make([]string, numKeys)
+	puts := make([]blob.PutOptions, numKeys)
+	value := bytes.Repeat([]byte("v"), 4<<10)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key-%d", i)
+		puts[i] = blob.PutOptions{Key: keys[i], Data: value}
+	}
+	if err := kv.BatchPut(ctx, puts); err != nil {
+		b.Fatalf("BatchPut failed: %v", err)
+	}
+	s.Close(ctx)
+	rand.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+
+	for _, poolSize := range []int{1, 4} {
+		b.Run(fmt.Sprintf("PoolSize=%d", poolSize), func(b *testing.B) {
+			for range b.N {
+				// Reopen the store each time, so the page cache is cold.
+				b.StopTimer()
+				s, err := sqlitestore.New(url, &sqlitestore.Options{
+					PoolSize: poolSize, Uncompressed: true, NoVacuum: true,
+				})
+				if err != nil {
+					b.Fatalf("New failed: %v", err)
+				}
+				kv, err := s.KV(ctx, "bench")
+				if err != nil {
+					b.Fatalf("KV failed: %v", err)
+				}
+				b.StartTimer()
+				if got, err := kv.(sqlitestore.KV).BatchGet(ctx, keys...); err != nil {
+					b.Fatalf("BatchGet failed: %v", err)
+				} else if len(got) != numKeys {
+					b.Fatalf("BatchGet: got %d values, want %d", len(got), numKeys)
+				}
+				b.StopTimer()
+				s.Close(ctx)
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func BenchmarkStat(b *testing.B) {
+	ctx := context.Background()
+	for _, covering := range []bool{false, true} {
+		b.Run(fmt.Sprintf("Covering=%v", covering), func(b *testing.B) {
+			url := "file:" + filepath.Join(b.TempDir(), "bench.db")
+			s, err := sqlitestore.New(url, &sqlitestore.Options{CoveringIndex: covering, Uncompressed: true})
+			if err != nil {
+				b.Fatalf("New failed: %v", err)
+			}
+			defer s.Close(ctx)
+			kv, err := s.KV(ctx, "bench")
+			if err != nil {
+				b.Fatalf("KV failed: %v", err)
+			}
+			const numKeys = 1000
+			keys := make([]string, numKeys)
+			value := bytes.Repeat([]byte("v"), 16<<10)
+			for i := range keys {
+				keys[i] = fmt.Sprintf("key-%d", i)
+				if err := kv.Put(ctx, blob.PutOptions{Key: keys[i], Data: value}); err != nil {
+					b.Fatalf("Put failed: %v", err)
+				}
+			}
+			b.ResetTimer()
+			for range b.N {
+				if _, err := kv.Stat(ctx, keys...); err != nil {
+					b.Fatalf("Stat failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRowID(b *testing.B) {
+	ctx := context.Background()
+	for _, size := range []int{100, 64 << 10} {
+		value := bytes.Repeat([]byte("v"), size)
+		for _, withoutRowID := range []bool{false, true} {
+			url := "file:" + filepath.Join(b.TempDir(), "bench.db")
+			s, err := sqlitestore.New(url, &sqlitestore.Options{WithoutRowID: withoutRowID, Uncompressed: true})
+			if err != nil {
+				b.Fatalf("New failed: %v", err)
+			}
+			defer s.Close(ctx)
+			kv, err := s.KV(ctx, "bench")
+			if err != nil {
+				b.Fatalf("KV failed: %v", err)
+			}
+			const numKeys = 1000
+			name := fmt.Sprintf("Size=%d/WithoutRowID=%v", size, withoutRowID)
+			b.Run(name+"/Put", func(b *testing.B) {
+				for i := range b.N {
+					if err := kv.Put(ctx, blob.PutOptions{
+						Key: fmt.Sprintf("key-%d", i%numKeys), Data: value, Replace: true,
+					}); err != nil {
+						b.Fatalf("Put failed: %v", err)
+					}
+				}
+			})
+			b.Run(name+"/Get", func(b *testing.B) {
+				for i := range b.N {
+					if _, err := kv.Get(ctx, fmt.Sprintf("key-%d", i%numKeys)); err != nil && !blob.IsKeyNotFound(err) {
+						b.Fatalf("Get failed: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestFastLen(t *testing.T) {
+	ctx := context.Background()
+	s, url := newTestStore(t, &sqlitestore.Options{FastLen: true})
+	kv := mustKV(t, s, "test")
+
+	checkLen := func(want int64) {
+		t.Helper()
+		if got, err := kv.Len(ctx); err != nil {
+			t.Fatalf("Len failed: %v", err)
+		} else if got != want {
+			t.Errorf("Len: got %d, want %d", got, want)
+		}
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := kv.Put(ctx, blob.PutOptions{Key: key, Data: []byte(key)}); err != nil {
+			t.Fatalf("Put %q failed: %v", key, err)
+		}
+	}
+	checkLen(3)
+	if err := kv.Put(ctx, blob.PutOptions{Key: "a", Data: []byte("A"), Replace: true}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	checkLen(3)
+	if err := kv.Delete(ctx, "b"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	checkLen(2)
+
+	// Modify the table behind the store's back, and verify that Resync
+	// picks up the change.
+	tab := dbkey.Prefix("").Keyspace("test").String()
+	if _, err := openRaw(t, url).Exec(fmt.Sprintf(`delete from "%s"`, tab)); err != nil {
+		t.Fatalf("Delete rows failed: %v", err)
+	}
+	checkLen(2)
+	if err := kv.Resync(ctx); err != nil {
+		t.Fatalf("Resync failed: %v", err)
+	}
+	checkLen(0)
+}
+
+func TestFastLenExpired(t *testing.T) {
+	// Writing a key whose value has expired replaces its row in place, so the
+	// cached length must not count it again.
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name  string
+		write func(sqlitestore.KV) error
+	}{
+		{"ReplaceGet", func(kv sqlitestore.KV) error {
+			_, _, err := kv.ReplaceGet(ctx, "k", []byte("new"))
+			return err
+		}},
+		{"Append", func(kv sqlitestore.KV) error {
+			return kv.Append(ctx, "k", []byte("new"))
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _ := newTestStore(t, &sqlitestore.Options{FastLen: true})
+			kv := mustKV(t, s, "test")
+			if err := kv.PutTTL(ctx, blob.PutOptions{Key: "k", Data: []byte("old")}, time.Nanosecond); err != nil {
+				t.Fatalf("PutTTL failed: %v", err)
+			}
+			time.Sleep(time.Millisecond)
+			if err := tc.write(kv); err != nil {
+				t.Fatalf("%s failed: %v", tc.name, err)
+			}
+			if n, err := kv.Len(ctx); err != nil || n != 1 {
+				t.Errorf("Len: got (%d, %v), want (1, nil)", n, err)
+			}
+			if err := kv.Resync(ctx); err != nil {
+				t.Fatalf("Resync failed: %v", err)
+			} else if n, err := kv.Len(ctx); err != nil || n != 1 {
+				t.Errorf("Len after Resync: got (%d, %v), want (1, nil)", n, err)
+			}
+		})
+	}
+}
+
+func TestReplaceGet(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, &sqlitestore.Options{FastLen: true})
+	kv := mustKV(t, s, "test")
+
+	old, existed, err := kv.ReplaceGet(ctx, "k", []byte("first"))
+	if err != nil || existed || old != nil {
+		t.Errorf("ReplaceGet new: got (%q, %v, %v), want (nil, false, nil)", old, existed, err)
+	}
+	old, existed, err = kv.ReplaceGet(ctx, "k", []byte("second"))
+	if err != nil || !existed || string(old) != "first" {
+		t.Errorf("ReplaceGet: got (%q, %v, %v), want (first, true, nil)", old, existed, err)
+	}
+	if got, err := kv.Get(ctx, "k"); err != nil || string(got) != "second" {
+		t.Errorf("Get: got (%q, %v), want (second, nil)", got, err)
+	}
+	if n, err := kv.Len(ctx); err != nil || n != 1 {
+		t.Errorf("Len: got (%d, %v), want (1, nil)", n, err)
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, &sqlitestore.Options{FastLen: true})
+	kv := mustKV(t, s, "test")
+
+	tests := []struct {
+		expected, newData string
+		absent            bool // expected is nil
+		want              bool
+	}{
+		{"", "one", true, true},        // absent → insert
+		{"", "two", true, false},       // present, expected absent
+		{"wrong", "two", false, false}, // mismatch
+		{"one", "two", false, true},    // match
+		{"two", "", false, true},       // match, store empty
+		{"", "three", false, true},     // match empty
+	}
+	for i, tc := range tests {
+		var expected []byte
+		if !tc.absent {
+			expected = []byte(tc.expected)
+		}
+		got, err := kv.CompareAndSwap(ctx, "k", expected, []byte(tc.newData))
+		if err != nil {
+			t.Fatalf("CAS %d failed: %v", i, err)
+		} else if got != tc.want {
+			t.Errorf("CAS %d (%q → %q): got %v, want %v", i, tc.expected, tc.newData, got, tc.want)
+		}
+	}
+	if got, err := kv.Get(ctx, "k"); err != nil || string(got) != "three" {
+		t.Errorf("Get: got (%q, %v), want (three, nil)", got, err)
+	}
+	if n, err := kv.Len(ctx); err != nil || n != 1 {
+		t.Errorf("Len: got (%d, %v), want (1, nil)", n, err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	s, url := newTestStore(t, &sqlitestore.Options{Verify: true, Uncompressed: true})
+	db := openRaw(t, url)
+
+	// Create a table with the old schema, lacking a checksum column, and
+	// verify that it is upgraded when the KV is opened.
+	tab := dbkey.Prefix("").Keyspace("test").String()
+	if _, err := db.Exec(fmt.Sprintf(`create table "%s" (
+  key BLOB unique not null,
+  value BLOB not null,
+  vsize INTEGER not null
+)`, tab)); err != nil {
+		t.Fatalf("Create table failed: %v", err)
+	}
+	if _, err := db.Exec(fmt.Sprintf(`insert into "%s" (key, value, vsize) values ($key, 'old', 3)`, tab),
+		sql.Named("key", hex.EncodeToString([]byte("old")))); err != nil {
+		t.Fatalf("Insert failed: %v", err)
+	}
+	kv := mustKV(t, s, "test")
+
+	// The old row has no checksum, and is not verified.
+	if got, err := kv.Get(ctx, "old"); err != nil || string(got) != "old" {
+		t.Errorf("Get old: got (%q, %v), want (old, nil)", got, err)
+	}
+
+	if err := kv.Put(ctx, blob.PutOptions{Key: "new", Data: []byte("good data")}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if got, err := kv.Get(ctx, "new"); err != nil || string(got) != "good data" {
+		t.Errorf("Get new: got (%q, %v), want (good data, nil)", got, err)
+	}
+
+	// Corrupt the stored value without updating its checksum.
+	if _, err := db.Exec(fmt.Sprintf(`update "%s" set value = 'bad data' where key = $key`, tab),
+		sql.Named("key", hex.EncodeToString([]byte("new")))); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if got, err := kv.Get(ctx, "new"); !errors.Is(err, sqlitestore.ErrCorruptValue) {
+		t.Errorf("Get corrupt: got (%q, %v), want %v", got, err, sqlitestore.ErrCorruptValue)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	ctx := context.Background()
+	s, url := newTestStore(t, &sqlitestore.Options{Dedup: true})
+	db := openRaw(t, url)
+	kv1 := mustKV(t, s, "one")
+	kv2 := mustKV(t, s, "two")
+
+	checkContent := func(want int) {
+		t.Helper()
+		var n int
+		if err := db.QueryRow(`select count(*) from blob_content`).Scan(&n); err != nil {
+			t.Fatalf("Query failed: %v", err)
+		} else if n != want {
+			t.Errorf("Content rows: got %d, want %d", n, want)
+		}
+	}
+	put := func(kv sqlitestore.KV, key, data string) {
+		t.Helper()
+		if err := kv.Put(ctx, blob.PutOptions{Key: key, Data: []byte(data), Replace: true}); err != nil {
+			t.Fatalf("Put %q failed: %v", key, err)
+		}
+	}
+
+	put(kv1, "a", "shared")
+	put(kv1, "b", "shared")
+	put(kv2, "a", "shared")
+	checkContent(1)
+
+	put(kv1, "a", "different")
+	checkContent(2)
+	if got, err := kv1.Get(ctx, "a"); err != nil || string(got) != "different" {
+		t.Errorf("Get: got (%q, %v), want (different, nil)", got, err)
+	}
+	if got, err := kv2.Get(ctx, "a"); err != nil || string(got) != "shared" {
+		t.Errorf("Get: got (%q, %v), want (shared, nil)", got, err)
+	}
+
+	for _, del := range []struct {
+		kv  sqlitestore.KV
+		key string
+	}{{kv1, "a"}, {kv1, "b"}, {kv2, "a"}} {
+		if err := del.kv.Delete(ctx, del.key); err != nil {
+			t.Fatalf("Delete %q failed: %v", del.key, err)
+		}
+	}
+	checkContent(0)
+}
+
+func TestGetReader(t *testing.T) {
+	ctx := context.Background()
+	value := bytes.Repeat([]byte("0123456789abcdef"), 160000) // > 2MB
+	for _, tc := range []struct {
+		name string
+		opts *sqlitestore.Options
+	}{
+		{"Compressed", &sqlitestore.Options{Verify: true}},
+		{"Uncompressed", &sqlitestore.Options{Verify: true, Uncompressed: true}},
+		{"Dedup", &sqlitestore.Options{Uncompressed: true, Dedup: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _ := newTestStore(t, tc.opts)
+			kv := mustKV(t, s, "test")
+			if err := kv.Put(ctx, blob.PutOptions{Key: "big", Data: value}); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+			if err := kv.Put(ctx, blob.PutOptions{Key: "empty"}); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+
+			for key, want := range map[string][]byte{"big": value, "empty": nil} {
+				r, size, err := kv.GetReader(ctx, key)
+				if err != nil {
+					t.Fatalf("GetReader %q failed: %v", key, err)
+				}
+				got, err := io.ReadAll(r)
+				if err != nil {
+					t.Errorf("Read %q failed: %v", key, err)
+				}
+				if err := r.Close(); err != nil {
+					t.Errorf("Close %q failed: %v", key, err)
+				}
+				if size != int64(len(want)) || !bytes.Equal(got, want) {
+					t.Errorf("GetReader %q: got %d bytes (size %d), want %d", key, len(got), size, len(want))
+				}
+			}
+			if _, _, err := kv.GetReader(ctx, "nonesuch"); !blob.IsKeyNotFound(err) {
+				t.Errorf("GetReader missing: got %v, want %v", err, blob.ErrKeyNotFound)
+			}
+
+			// Closing the reader releases the store for writing.
+			if err := kv.Delete(ctx, "big"); err != nil {
+				t.Errorf("Delete failed: %v", err)
+			}
+		})
+	}
+
+	t.Run("Concurrent", func(t *testing.T) {
+		s, _ := newTestStore(t, &sqlitestore.Options{Uncompressed: true, JournalMode: "wal", PoolSize: 1})
+		kv := mustKV(t, s, "test")
+		if err := kv.Put(ctx, blob.PutOptions{Key: "big", Data: value}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		r, _, err := kv.GetReader(ctx, "big")
+		if err != nil {
+			t.Fatalf("GetReader failed: %v", err)
+		}
+		defer r.Close()
+
+		// An open reader does not block writes, even from the same goroutine
+		// with a pool of one connection, and reads the value as of its start.
+		if err := kv.Put(ctx, blob.PutOptions{Key: "big", Data: []byte("new"), Replace: true}); err != nil {
+			t.Fatalf("Put while reading failed: %v", err)
+		}
+		if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, value) {
+			t.Errorf("Read: got %d bytes, %v; want %d", len(got), err, len(value))
+		}
+		if got, err := kv.Get(ctx, "big"); err != nil || string(got) != "new" {
+			t.Errorf("Get: got %q, %v; want %q", got, err, "new")
+		}
+	})
+}
+
+func TestBackup(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, nil)
+	kv := mustKV(t, s, "test")
+	for i := range 1000 {
+		if err := kv.Put(ctx, blob.PutOptions{
+			Key:  fmt.Sprintf("key-%04d", i),
+			Data: bytes.Repeat([]byte{byte(i)}, 1000),
+		}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	dest := filepath.Join(t.TempDir(), "backup.db")
+	var calls, lastDone, lastTotal int
+	if err := s.Backup(ctx, dest, func(done, total int) {
+		calls++
+		lastDone, lastTotal = done, total
+	}); err != nil {
+		t.Fatalf("Backup failed: %v", err)
+	}
+	if calls == 0 || lastDone != lastTotal {
+		t.Errorf("Progress: %d calls, last (%d, %d)", calls, lastDone, lastTotal)
+	}
+
+	b, err := sqlitestore.New("file:"+dest, nil)
+	if err != nil {
+		t.Fatalf("Open backup failed: %v", err)
+	}
+	defer b.Close(ctx)
+	bkv, err := b.KV(ctx, "test")
+	if err != nil {
+		t.Fatalf("KV failed: %v", err)
+	}
+	if n, err := bkv.Len(ctx); err != nil || n != 1000 {
+		t.Errorf("Backup Len: got (%d, %v), want (1000, nil)", n, err)
+	}
+	if got, err := bkv.Get(ctx, "key-0123"); err != nil || !bytes.Equal(got, bytes.Repeat([]byte{123}, 1000)) {
+		t.Errorf("Backup Get: got (%d bytes, %v)", len(got), err)
+	}
+}
+
+// putAll writes the given key-value pairs to kv, or fails t.
+func putAll(t *testing.T, kv blob.KV, pairs map[string]string) {
+	t.Helper()
+	for key, val := range pairs {
+		if err := kv.Put(context.Background(), blob.PutOptions{Key: key, Data: []byte(val), Replace: true}); err != nil {
+			t.Fatalf("Put %q failed: %v", key, err)
+		}
+	}
+}
+
+// checkContents verifies that kv contains exactly the given key-value pairs.
+func checkContents(t *testing.T, kv blob.KV, want map[string]string) {
+	t.Helper()
+	ctx := context.Background()
+	var keys []string
+	if err := kv.List(ctx, "", func(key string) error {
+		keys = append(keys, key)
+		return nil
+	}); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	got := make(map[string]string)
+	for _, key := range keys {
+		data, err := kv.Get(ctx, key)
+		if err != nil {
+			t.Fatalf("Get %q failed: %v", key, err)
+		}
+		got[key] = string(data)
+	}
+	if len(got) != len(want) {
+		t.Errorf("Got %d keys, want %d", len(got), len(want))
+	}
+	for key, val := range want {
+		if g, ok := got[key]; !ok {
+			t.Errorf("Key %q not found", key)
+		} else if g != val {
+			t.Errorf("Key %q: got %q, want %q", key, g, val)
+		}
+	}
+}
+
+var testData = map[string]string{
+	"":           "empty key",
+	"apple":      "red",
+	"banana":     "yellow",
+	"cherry/pit": "",
+	"\x00\xff":   "binary key",
+}
+
+func TestTar(t *testing.T) {
+	ctx := context.Background()
+	src, _ := newTestStore(t, nil)
+	skv := mustKV(t, src, "test")
+	putAll(t, skv, testData)
+
+	var buf bytes.Buffer
+	if err := skv.ExportTar(ctx, &buf); err != nil {
+		t.Fatalf("ExportTar failed: %v", err)
+	}
+
+	dst, _ := newTestStore(t, &sqlitestore.Options{Uncompressed: true})
+	dkv := mustKV(t, dst, "other")
+	if err := dkv.ImportTar(ctx, bytes.NewReader(buf.Bytes()), false); err != nil {
+		t.Fatalf("ImportTar failed: %v", err)
+	}
+	checkContents(t, dkv, testData)
+
+	if err := dkv.ImportTar(ctx, bytes.NewReader(buf.Bytes()), false); !blob.IsKeyExists(err) {
+		t.Errorf("ImportTar again: got %v, want %v", err, blob.ErrKeyExists)
+	}
+	if err := dkv.ImportTar(ctx, bytes.NewReader(buf.Bytes()), true); err != nil {
+		t.Errorf("ImportTar replace: unexpected error: %v", err)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	ctx := context.Background()
+	src, _ := newTestStore(t, nil)
+	skv := mustKV(t, src, "test")
+	putAll(t, skv, testData)
+
+	var buf bytes.Buffer
+	if err := skv.ExportJSON(ctx, &buf); err != nil {
+		t.Fatalf("ExportJSON failed: %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != len(testData) {
+		t.Errorf("ExportJSON: got %d lines, want %d", len(lines), len(testData))
+	}
+	if want := `{"key":"apple","value":"cmVk"}`; !slices.Contains(lines, want) {
+		t.Errorf("ExportJSON: output %q does not contain %q", lines, want)
+	}
+
+	dst, _ := newTestStore(t, &sqlitestore.Options{Uncompressed: true})
+	dkv := mustKV(t, dst, "other")
+	if err := dkv.ImportJSON(ctx, bytes.NewReader(buf.Bytes()), false); err != nil {
+		t.Fatalf("ImportJSON failed: %v", err)
+	}
+	checkContents(t, dkv, testData)
+
+	if err := dkv.ImportJSON(ctx, bytes.NewReader(buf.Bytes()), false); !blob.IsKeyExists(err) {
+		t.Errorf("ImportJSON again: got %v, want %v", err, blob.ErrKeyExists)
+	}
+	if err := dkv.ImportJSON(ctx, strings.NewReader(`{"key":"x","value":"!"}`), true); err == nil {
+		t.Error("ImportJSON invalid base64: got nil, want error")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+	s, err := sqlitestore.New("file:"+path+"?_pragma=journal_mode(wal)", nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close(ctx)
+	kv := mustKV(t, s, "test")
+	putAll(t, kv, testData)
+
+	snap, err := kv.Snapshot(ctx)
+	if err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	var _ blob.KV = snap
+
+	// Writes to the store do not block, and are not visible in the snapshot.
+	putAll(t, kv, map[string]string{"apple": "green", "durian": "smelly"})
+	if err := kv.Delete(ctx, "banana"); err != nil {
+		t.Fatalf("Delete banana: %v", err)
+	}
+	checkContents(t, snap, testData)
+
+	// Writes through the snapshot are rejected.
+	if err := snap.Put(ctx, blob.PutOptions{Key: "x", Data: []byte("y")}); !errors.Is(err, sqlitestore.ErrReadOnly) {
+		t.Errorf("Put: got %v, want %v", err, sqlitestore.ErrReadOnly)
+	}
+	if err := snap.Delete(ctx, "apple"); !errors.Is(err, sqlitestore.ErrReadOnly) {
+		t.Errorf("Delete: got %v, want %v", err, sqlitestore.ErrReadOnly)
+	}
+
+	if err := snap.Release(); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if err := snap.Release(); err != nil {
+		t.Errorf("Release again: %v", err)
+	}
+	if _, err := snap.Get(ctx, "apple"); err == nil {
+		t.Error("Get after Release: got nil, want error")
+	}
+}
+
+func TestImportFS(t *testing.T) {
+	ctx := context.Background()
+	fsys := fstest.MapFS{
+		"a.txt":     {Data: []byte("apple")},
+		"b/c.txt":   {Data: []byte("cherry")},
+		"b/d/e.txt": {Data: []byte("elderberry")},
+		"empty":     {Data: nil},
+	}
+	want := map[string]string{
+		"a.txt": "apple", "b/c.txt": "cherry", "b/d/e.txt": "elderberry", "empty": "",
+	}
+	s, _ := newTestStore(t, &sqlitestore.Options{Dedup: true, FastLen: true})
+	kv := mustKV(t, s, "test")
+
+	opts := &sqlitestore.ImportFSOptions{BatchSize: 2}
+	if err := kv.ImportFS(ctx, fsys, opts); err != nil {
+		t.Fatalf("ImportFS failed: %v", err)
+	}
+	checkContents(t, kv, want)
+
+	// Without Replace, existing keys are reported.
+	if err := kv.ImportFS(ctx, fsys, opts); !blob.IsKeyExists(err) {
+		t.Errorf("ImportFS again: got %v, want %v", err, blob.ErrKeyExists)
+	}
+
+	// An error handler can skip the failures.
+	var failed []string
+	opts.OnError = func(path string, err error) error {
+		failed = append(failed, path)
+		return nil
+	}
+	fsys["f.txt"] = &fstest.MapFile{Data: []byte("fig")}
+	if err := kv.ImportFS(ctx, fsys, opts); err != nil {
+		t.Errorf("ImportFS with OnError: unexpected error: %v", err)
+	}
+	if len(failed) != len(want) {
+		t.Errorf("OnError: got %d failures, want %d", len(failed), len(want))
+	}
+	want["f.txt"] = "fig"
+	checkContents(t, kv, want)
+	if n, err := kv.Len(ctx); err != nil || n != int64(len(want)) {
+		t.Errorf("Len: got %d, %v; want %d", n, err, len(want))
+	}
+
+	opts.Replace = true
+	fsys["a.txt"] = &fstest.MapFile{Data: []byte("apricot")}
+	if err := kv.ImportFS(ctx, fsys, opts); err != nil {
+		t.Errorf("ImportFS replace: unexpected error: %v", err)
+	}
+	want["a.txt"] = "apricot"
+	checkContents(t, kv, want)
+}
+
+func TestCopyTo(t *testing.T) {
+	ctx := context.Background()
+	src, _ := newTestStore(t, nil)
+	skv := mustKV(t, src, "test")
+	putAll(t, skv, testData)
+
+	t.Run("BatchPut", func(t *testing.T) {
+		dst, _ := newTestStore(t, &sqlitestore.Options{FastLen: true})
+		dkv := mustKV(t, dst, "copy")
+		if err := dkv.Put(ctx, blob.PutOptions{Key: "apple", Data: []byte("green")}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		var got sqlitestore.Progress
+		opts := &sqlitestore.CopyOptions{
+			BatchSize: 2,
+			Progress:  func(p sqlitestore.Progress) { got = p },
+		}
+		if err := skv.CopyTo(ctx, dkv, opts); err != nil {
+			t.Fatalf("CopyTo failed: %v", err)
+		}
+		n := int64(len(testData))
+		if want := (sqlitestore.Progress{Processed: n, Skipped: 1, Total: n}); got != want {
+			t.Errorf("Progress: got %+v, want %+v", got, want)
+		}
+		want := maps.Clone(testData)
+		want["apple"] = "green"
+		checkContents(t, dkv, want)
+
+		opts.Replace = true
+		if err := skv.CopyTo(ctx, dkv, opts); err != nil {
+			t.Fatalf("CopyTo replace failed: %v", err)
+		}
+		checkContents(t, dkv, testData)
+		if n, err := dkv.Len(ctx); err != nil || n != int64(len(testData)) {
+			t.Errorf("Len: got %d, %v; want %d", n, err, len(testData))
+		}
+	})
+
+	t.Run("Put", func(t *testing.T) {
+		dst := memstore.NewKV()
+		if err := skv.CopyTo(ctx, dst, nil); err != nil {
+			t.Fatalf("CopyTo failed: %v", err)
+		}
+		checkContents(t, dst, testData)
+	})
+}
+
+func TestPutTTL(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, &sqlitestore.Options{Dedup: true})
+	kv := mustKV(t, s, "test")
+
+	mustPutTTL := func(key, val string, replace bool, ttl time.Duration) {
+		t.Helper()
+		if err := kv.PutTTL(ctx, blob.PutOptions{Key: key, Data: []byte(val), Replace: replace}, ttl); err != nil {
+			t.Fatalf("PutTTL %q failed: %v", key, err)
+		}
+	}
+	mustPutTTL("short", "gone", false, time.Millisecond)
+	mustPutTTL("long", "here", false, time.Hour)
+	mustPutTTL("never", "here", false, 0)
+	time.Sleep(5 * time.Millisecond)
+
+	if _, err := kv.Get(ctx, "short"); !blob.IsKeyNotFound(err) {
+		t.Errorf("Get expired: got %v, want %v", err, blob.ErrKeyNotFound)
+	}
+	if st, err := kv.Stat(ctx, "short", "long"); err != nil {
+		t.Errorf("Stat failed: %v", err)
+	} else if st.Has("short") || !st.Has("long") {
+		t.Errorf("Stat: got %v, want only long", st)
+	}
+	checkContents(t, kv, map[string]string{"long": "here", "never": "here"})
+
+	if err := kv.PutTTL(ctx, blob.PutOptions{Key: "x"}, -time.Second); err == nil {
+		t.Error("PutTTL with negative TTL: got nil, want error")
+	}
+
+	// An expired key does not prevent insertion.
+	mustPutTTL("short", "again", false, time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+
+	// An expired key is not present for Delete, and its row remains until it
+	// is purged.
+	if err := kv.Delete(ctx, "short"); !blob.IsKeyNotFound(err) {
+		t.Errorf("Delete expired: got %v, want %v", err, blob.ErrKeyNotFound)
+	}
+	if n, err := kv.Len(ctx); err != nil || n != 3 {
+		t.Errorf("Len: got %d, %v; want 3", n, err)
+	}
+	if n, err := kv.PurgeExpired(ctx); err != nil || n != 1 {
+		t.Errorf("PurgeExpired: got %d, %v; want 1", n, err)
+	}
+	if n, err := kv.Len(ctx); err != nil || n != 2 {
+		t.Errorf("Len: got %d, %v; want 2", n, err)
+	}
+	if err := kv.Put(ctx, blob.PutOptions{Key: "long", Data: []byte("here"), Replace: true}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if n, err := kv.PurgeExpired(ctx); err != nil || n != 0 {
+		t.Errorf("PurgeExpired: got %d, %v; want 0", n, err)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	ctx := context.Background()
+	put := func(t *testing.T, kv blob.KV, key, val string) {
+		t.Helper()
+		if err := kv.Put(ctx, blob.PutOptions{Key: key, Data: []byte(val), Replace: true}); err != nil {
+			t.Fatalf("Put %q failed: %v", key, err)
+		}
+	}
+
+	t.Run("MaxKeys", func(t *testing.T) {
+		s, _ := newTestStore(t, &sqlitestore.Options{MaxKeys: 3, TouchOnGet: true, FastLen: true})
+		kv := mustKV(t, s, "test")
+		for _, key := range []string{"a", "b", "c", "d"} {
+			put(t, kv, key, key)
+		}
+		checkContents(t, kv, map[string]string{"b": "b", "c": "c", "d": "d"})
+
+		// Reading b makes c the least-recently used.
+		if _, err := kv.Get(ctx, "b"); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		put(t, kv, "e", "e")
+		checkContents(t, kv, map[string]string{"b": "b", "d": "d", "e": "e"})
+		if n, err := kv.Len(ctx); err != nil || n != 3 {
+			t.Errorf("Len: got %d, %v; want 3", n, err)
+		}
+	})
+
+	t.Run("MaxBytes", func(t *testing.T) {
+		s, _ := newTestStore(t, &sqlitestore.Options{MaxBytes: 10, Dedup: true})
+		kv := mustKV(t, s, "test")
+		put(t, kv, "a", "1234")
+		put(t, kv, "b", "1234")
+		put(t, kv, "c", "12")
+		checkContents(t, kv, map[string]string{"a": "1234", "b": "1234", "c": "12"})
+		put(t, kv, "d", "123")
+		checkContents(t, kv, map[string]string{"b": "1234", "c": "12", "d": "123"})
+		put(t, kv, "e", "12345678901")
+		checkContents(t, kv, map[string]string{})
+	})
+
+	t.Run("KeyCodec", func(t *testing.T) {
+		// Victims are deleted by their stored keys, which a lossy codec cannot
+		// reproduce from the keys it reports.
+		s, _ := newTestStore(t, &sqlitestore.Options{MaxKeys: 3, KeyCodec: hashKeys{}})
+		kv := mustKV(t, s, "test")
+		for _, key := range []string{"a", "b", "c", "d", "e"} {
+			put(t, kv, key, key)
+		}
+		if n, err := kv.Len(ctx); err != nil || n != 3 {
+			t.Errorf("Len: got %d, %v; want 3", n, err)
+		}
+		for _, key := range []string{"c", "d", "e"} {
+			if got, err := kv.Get(ctx, key); err != nil || string(got) != key {
+				t.Errorf("Get %q: got (%q, %v), want %q", key, got, err, key)
+			}
+		}
+	})
+}
+
+func TestCreated(t *testing.T) {
+	ctx := context.Background()
+	s, url := newTestStore(t, nil)
+
+	// A row written before creation times were recorded has the zero time.
+	tab := dbkey.Prefix("").Keyspace("test").String()
+	if _, err := openRaw(t, url).Exec(fmt.Sprintf(`create table "%s" (
+  key BLOB unique not null,
+  value BLOB not null,
+  vsize INTEGER not null
+)`, tab)); err != nil {
+		t.Fatalf("Create table failed: %v", err)
+	}
+	kv := mustKV(t, s, "test")
+	if err := kv.Put(ctx, blob.PutOptions{Key: "old", Data: []byte("old")}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, err := openRaw(t, url).Exec(fmt.Sprintf(`update "%s" set created_at = NULL`, tab)); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if got, err := kv.Created(ctx, "old"); err != nil || !got.IsZero() {
+		t.Errorf("Created old: got (%v, %v), want zero", got, err)
+	}
+
+	before := time.Now()
+	if err := kv.Put(ctx, blob.PutOptions{Key: "new", Data: []byte("new")}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	created, err := kv.Created(ctx, "new")
+	if err != nil {
+		t.Fatalf("Created failed: %v", err)
+	} else if created.Before(before) || created.After(time.Now()) {
+		t.Errorf("Created: got %v, want after %v", created, before)
+	}
+
+	// Replacing the value does not change the creation time.
+	if err := kv.Put(ctx, blob.PutOptions{Key: "new", Data: []byte("newer"), Replace: true}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if got, err := kv.Created(ctx, "new"); err != nil || !got.Equal(created) {
+		t.Errorf("Created after replace: got (%v, %v), want %v", got, err, created)
+	}
+
+	if _, err := kv.Created(ctx, "nonesuch"); !blob.IsKeyNotFound(err) {
+		t.Errorf("Created missing: got %v, want %v", err, blob.ErrKeyNotFound)
+	}
+}
+
+// opCounter is a sqlitestore.Metrics that counts operations by name.
+type opCounter struct {
+	mu  sync.Mutex
+	ops map[string]int
+}
+
+func (c *opCounter) ObserveOp(op, _ string, _ time.Duration, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		c.ops[op]++
+	}
+}
+
+func (c *opCounter) count(op string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ops[op]
+}
+
+func TestMaintenance(t *testing.T) {
+	ctx := context.Background()
+	m := &opCounter{ops: make(map[string]int)}
+	s, _ := newTestStore(t, &sqlitestore.Options{Metrics: m, MaintenanceInterval: time.Millisecond})
+	putAll(t, mustKV(t, s, "test"), testData)
+
+	for deadline := time.Now().Add(5 * time.Second); m.count("maintain") < 2; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for maintenance")
+		}
+	}
+
+	// After the store is closed, maintenance stops.
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	n := m.count("maintain")
+	time.Sleep(10 * time.Millisecond)
+	if got := m.count("maintain"); got != n {
+		t.Errorf("Maintenance after close: got %d runs, want %d", got, n)
+	}
+}
+
+func TestExpvar(t *testing.T) {
+	ctx := context.Background()
+	m := new(expvar.Map).Init()
+	s, _ := newTestStore(t, &sqlitestore.Options{Expvar: m, FastLen: true})
+	kv := mustKV(t, s, "test")
+	putAll(t, kv, testData)
+	if _, err := kv.Get(ctx, "apple"); err != nil {
+		t.Fatalf("Get apple: %v", err)
+	}
+	if _, err := kv.Get(ctx, "nonesuch"); !blob.IsKeyNotFound(err) {
+		t.Fatalf("Get nonesuch: got %v, want %v", err, blob.ErrKeyNotFound)
+	}
+	read := len(testData["apple"])
+	if _, err := kv.BatchGet(ctx, "apple", "banana", "pear"); err != nil {
+		t.Fatalf("BatchGet: %v", err)
+	}
+	read += len(testData["apple"]) + len(testData["banana"]) // pear is missing
+	raw, _, err := kv.GetRaw(ctx, "banana")
+	if err != nil {
+		t.Fatalf("GetRaw: %v", err)
+	}
+	read += len(raw)
+
+	var written int
+	for _, v := range testData {
+		written += len(v)
+	}
+	for _, tc := range []struct {
+		name string
+		v    expvar.Var
+		want string
+	}{
+		{"ops.put", m.Get("ops").(*expvar.Map).Get("put"), fmt.Sprint(len(testData))},
+		{"ops.get", m.Get("ops").(*expvar.Map).Get("get"), "2"},
+		{"errors.get", m.Get("errors").(*expvar.Map).Get("get"), "1"},
+		{"ops.batchget", m.Get("ops").(*expvar.Map).Get("batchget"), "1"},
+		{"ops.getraw", m.Get("ops").(*expvar.Map).Get("getraw"), "1"},
+		{"bytes_read", m.Get("bytes_read"), fmt.Sprint(read)},
+		{"bytes_written", m.Get("bytes_written"), fmt.Sprint(written)},
+	} {
+		if tc.v == nil {
+			t.Errorf("%s: not set", tc.name)
+		} else if got := tc.v.String(); got != tc.want {
+			t.Errorf("%s: got %s, want %s", tc.name, got, tc.want)
+		}
+	}
+
+	keys := m.Get("keys").(expvar.Func).Value().(map[string]int64)
+	var nk int64
+	for _, n := range keys {
+		nk += n
+	}
+	if nk != int64(len(testData)) {
+		t.Errorf("keys: got %v, want a total of %d", keys, len(testData))
+	}
+}
+
+func TestChangesetUnsupported(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, nil)
+	kv := mustKV(t, s, "test")
+
+	// The default driver does not support the session extension.
+	called := false
+	if _, err := kv.CaptureChanges(ctx, func(tx *sqlitestore.KVTx) error {
+		called = true
+		return nil
+	}); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("CaptureChanges: got %v, want %v", err, errors.ErrUnsupported)
+	}
+	if called {
+		t.Error("CaptureChanges called fn without a session")
+	}
+	if err := s.ApplyChangeset(ctx, []byte("x")); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("ApplyChangeset: got %v, want %v", err, errors.ErrUnsupported)
+	}
+
+	// The store remains usable afterward.
+	putAll(t, kv, testData)
+	checkContents(t, kv, testData)
+}
+
+func TestNoCheckpoint(t *testing.T) {
+	for _, noCheckpoint := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "test.db")
+		m := &opCounter{ops: make(map[string]int)}
+		s, err := sqlitestore.New("file:"+path+"?_pragma=journal_mode(wal)", &sqlitestore.Options{
+			Metrics:             m,
+			MaintenanceInterval: time.Millisecond,
+			NoCheckpoint:        noCheckpoint,
+		})
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		t.Cleanup(func() { s.Close(context.Background()) })
+		putAll(t, mustKV(t, s, "test"), testData)
+
+		// Wait for a maintenance pass that began after the writes.
+		for deadline, n := time.Now().Add(5*time.Second), m.count("maintain"); m.count("maintain") < n+2; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal("Timed out waiting for maintenance")
+			}
+		}
+		fi, err := os.Stat(path + "-wal")
+		if err != nil {
+			t.Fatalf("Stat WAL: %v", err)
+		}
+		if got := fi.Size() > 0; got != noCheckpoint {
+			t.Errorf("NoCheckpoint=%v: WAL size is %d", noCheckpoint, fi.Size())
+		}
+	}
+}
+
+func TestNoVacuum(t *testing.T) {
+	ctx := context.Background()
+	for _, noVacuum := range []bool{false, true} {
+		s, url := newTestStore(t, &sqlitestore.Options{NoVacuum: noVacuum, Uncompressed: true})
+		kv := mustKV(t, s, "test")
+		big := bytes.Repeat([]byte("x"), 1<<16)
+		for i := range 10 {
+			if err := kv.Put(ctx, blob.PutOptions{Key: fmt.Sprint(i), Data: big}); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+		for i := range 10 {
+			if err := kv.Delete(ctx, fmt.Sprint(i)); err != nil {
+				t.Fatalf("Delete failed: %v", err)
+			}
+		}
+		if err := s.Close(ctx); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+
+		var free int
+		if err := openRaw(t, url).QueryRow(`pragma freelist_count`).Scan(&free); err != nil {
+			t.Fatalf("Query freelist failed: %v", err)
+		}
+		if got := free > 0; got != noVacuum {
+			t.Errorf("NoVacuum=%v: got %d free pages", noVacuum, free)
+		}
+	}
+}
+
+func TestCloseOptions(t *testing.T) {
+	ctx := context.Background()
+	yes, no := true, false
+	tests := []struct {
+		name       string
+		opts       sqlitestore.Options
+		wantWAL    bool // the log survives Close
+		wantVacuum bool
+	}{
+		{"Default", sqlitestore.Options{}, false, true},
+		{"NoVacuum", sqlitestore.Options{NoVacuum: true}, false, false},
+		{"VacuumOnClose", sqlitestore.Options{NoVacuum: true, VacuumOnClose: &yes}, false, true},
+		{"NoVacuumOnClose", sqlitestore.Options{VacuumOnClose: &no}, false, false},
+		{"CheckpointOnClose", sqlitestore.Options{CheckpointOnClose: &yes}, false, true},
+		{"NoCheckpointOnClose", sqlitestore.Options{CheckpointOnClose: &no}, true, true},
+		{"Neither", sqlitestore.Options{CheckpointOnClose: &no, VacuumOnClose: &no}, true, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.db")
+			tc.opts.Uncompressed = true
+			s, err := sqlitestore.New("file:"+path+"?_pragma=journal_mode(wal)", &tc.opts)
+			if err != nil {
+				t.Fatalf("New failed: %v", err)
+			}
+			kv := mustKV(t, s, "test")
+			big := bytes.Repeat([]byte("x"), 1<<16)
+			for i := range 10 {
+				if err := kv.Put(ctx, blob.PutOptions{Key: fmt.Sprint(i), Data: big}); err != nil {
+					t.Fatalf("Put failed: %v", err)
+				}
+			}
+			for i := range 9 {
+				if err := kv.Delete(ctx, fmt.Sprint(i)); err != nil {
+					t.Fatalf("Delete failed: %v", err)
+				}
+			}
+			if err := s.Close(ctx); err != nil {
+				t.Fatalf("Close failed: %v", err)
+			}
+
+			_, err = os.Stat(path + "-wal")
+			if got := err == nil; got != tc.wantWAL {
+				t.Errorf("Log exists after Close: got %v, want %v (%v)", got, tc.wantWAL, err)
+			}
+			db := openRaw(t, "file:"+path)
+			var free int
+			if err := db.QueryRow(`pragma freelist_count`).Scan(&free); err != nil {
+				t.Fatalf("Query freelist failed: %v", err)
+			}
+			if got := free == 0; got != tc.wantVacuum {
+				t.Errorf("Vacuumed: got %v, want %v (%d free pages)", got, tc.wantVacuum, free)
+			}
+			db.Close()
+
+			r, err := sqlitestore.New("file:"+path, &sqlitestore.Options{Uncompressed: true})
+			if err != nil {
+				t.Fatalf("Reopen failed: %v", err)
+			}
+			defer r.Close(ctx)
+			if got, err := mustKV(t, r, "test").Get(ctx, "9"); err != nil || !bytes.Equal(got, big) {
+				t.Errorf("Get after reopen: got %d bytes, %v; want %d bytes", len(got), err, len(big))
+			}
+		})
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	ctx := context.Background()
+	s, url := newTestStore(t, nil)
+	putAll(t, mustKV(t, s, "test"), testData)
+	if err := s.Analyze(ctx); err != nil {
+		t.Fatalf("Analyze failed: %v", err)
+	}
+	if !hasTable(t, openRaw(t, url), "sqlite_stat1") {
+		t.Error("Analyze did not record statistics")
+	}
+}
+
+func TestStatMany(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, nil)
+	kv := mustKV(t, s, "test")
+
+	// Look up more keys than fit in a single query, half of them missing.
+	var keys []string
+	for i := range 1200 {
+		key := fmt.Sprintf("key-%04d", i)
+		keys = append(keys, key)
+		if i%2 == 0 {
+			if err := kv.Put(ctx, blob.PutOptions{Key: key, Data: []byte(key[:i%8])}); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+	}
+	st, err := kv.Stat(ctx, keys...)
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if len(st) != len(keys)/2 {
+		t.Errorf("Stat: got %d keys, want %d", len(st), len(keys)/2)
+	}
+	for i, key := range keys {
+		if got, ok := st[key]; ok != (i%2 == 0) {
+			t.Errorf("Stat %q: got present=%v, want %v", key, ok, i%2 == 0)
+		} else if ok && got.Size != int64(i%8) {
+			t.Errorf("Stat %q: got size %d, want %d", key, got.Size, i%8)
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, nil)
+	kv := mustKV(t, s, "test")
+	putAll(t, kv, testData)
+
+	keys, err := kv.Keys(ctx, "apple")
+	if err != nil {
+		t.Fatalf("Keys failed: %v", err)
+	}
+	if want := []string{"apple", "banana", "cherry/pit"}; !slices.Equal(keys, want) {
+		t.Errorf("Keys: got %q, want %q", keys, want)
+	}
+}
+
+func TestForEach(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, &sqlitestore.Options{PoolSize: 1})
+	kv := mustKV(t, s, "test")
+	want := make(map[string]string)
+	for i := range 250 {
+		want[fmt.Sprintf("key-%03d", i)] = fmt.Sprint(i)
+	}
+	putAll(t, kv, want)
+
+	// The callback may write to the store without deadlocking.
+	got := make(map[string]string)
+	if err := kv.ForEach(ctx, func(key string, data []byte) error {
+		got[key] = string(data)
+		return kv.Put(ctx, blob.PutOptions{Key: key, Data: data, Replace: true})
+	}); err != nil {
+		t.Fatalf("ForEach failed: %v", err)
+	}
+	if !maps.Equal(got, want) {
+		t.Errorf("ForEach: got %d pairs, want %d", len(got), len(want))
+	}
+
+	var n int
+	if err := kv.ForEach(ctx, func(string, []byte) error {
+		n++
+		return value.Cond(n == 3, blob.ErrStopListing, nil)
+	}); err != nil || n != 3 {
+		t.Errorf("ForEach stop: got (%d, %v), want (3, nil)", n, err)
+	}
+	bad := errors.New("bad")
+	if err := kv.ForEach(ctx, func(string, []byte) error { return bad }); err != bad {
+		t.Errorf("ForEach error: got %v, want %v", err, bad)
+	}
+}
+
+func TestScanProgress(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, nil)
+	kv := mustKV(t, s, "test")
+	putAll(t, kv, testData)
+
+	var got []sqlitestore.Progress
+	var keys []string
+	if err := kv.Scan(ctx, &sqlitestore.ScanOptions{
+		Start:         "c",
+		BatchSize:     2,
+		ProgressEvery: 2,
+		Progress:      func(p sqlitestore.Progress) { got = append(got, p) },
+	}, func(key string, _ []byte) error {
+		keys = append(keys, key)
+		return nil
+	}); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+
+	var want []sqlitestore.Progress
+	n, total := int64(len(keys)), int64(len(testData))
+	for i := int64(2); i <= n; i += 2 {
+		want = append(want, sqlitestore.Progress{Processed: i, Total: total})
+	}
+	want = append(want, sqlitestore.Progress{Processed: n, Total: total}) // the final report
+	if !slices.Equal(got, want) {
+		t.Errorf("Progress: got %v, want %v", got, want)
+	}
+	if len(keys) == 0 || keys[0] < "c" {
+		t.Errorf("Scan keys: got %q, want keys from c", keys)
+	}
+}
+
+func TestRawKeys(t *testing.T) {
+	s, url := newTestStore(t, &sqlitestore.Options{KeyEncoding: sqlitestore.RawKeys})
+	kv := mustKV(t, s, "test")
+	putAll(t, kv, testData)
+	checkContents(t, kv, testData)
+
+	// Keys are stored as their own bytes.
+	tab := dbkey.Prefix("").Keyspace("test").String()
+	var key []byte
+	if err := openRaw(t, url).QueryRow(fmt.Sprintf(`select key from "%s" where key = $key`, tab),
+		sql.Named("key", []byte("apple"))).Scan(&key); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if string(key) != "apple" {
+		t.Errorf("Stored key: got %q, want %q", key, "apple")
+	}
+
+	keys, err := kv.Keys(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Keys failed: %v", err)
+	}
+	if !slices.IsSorted(keys) || len(keys) != len(testData) {
+		t.Errorf("Keys: got %q, want %d keys in order", keys, len(testData))
+	}
+}
+
+func TestContains(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, nil)
+	kv := mustKV(t, s, "test")
+	putAll(t, kv, testData)
+	if err := kv.PutTTL(ctx, blob.PutOptions{Key: "expired", Data: []byte("x")}, time.Nanosecond); err != nil {
+		t.Fatalf("PutTTL failed: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	for _, tc := range []struct {
+		key  string
+		want bool
+	}{
+		{"apple", true}, {"", true}, {"\x00\xff", true}, {"nonesuch", false}, {"expired", false},
+	} {
+		if got, err := kv.Contains(ctx, tc.key); err != nil || got != tc.want {
+			t.Errorf("Contains(%q): got (%v, %v), want %v", tc.key, got, err, tc.want)
+		}
+	}
+}
+
+func TestMissingKeys(t *testing.T) {
+	ctx := context.Background()
+	for _, opts := range []*sqlitestore.Options{
+		nil,
+		{KeyEncoding: sqlitestore.RawKeys},
+		{KeyCodec: hashKeys{}},
+	} {
+		s, _ := newTestStore(t, opts)
+		kv := mustKV(t, s, "test")
+		putAll(t, kv, testData)
+		if err := kv.PutTTL(ctx, blob.PutOptions{Key: "expired", Data: []byte("x")}, time.Nanosecond); err != nil {
+			t.Fatalf("PutTTL failed: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+
+		if got, err := kv.MissingKeys(ctx, "apple", "", "\x00\xff"); err != nil || got != nil {
+			t.Errorf("MissingKeys (all present): got %q, %v; want nil, nil", got, err)
+		}
+
+		// Use enough keys to span several queries.
+		var keys, want []string
+		for i := range 1200 {
+			key := fmt.Sprintf("nonesuch-%d", i)
+			if i%100 == 0 {
+				key = "apple"
+			} else {
+				want = append(want, key)
+			}
+			keys = append(keys, key)
+		}
+		keys = append(keys, "expired", "banana")
+		want = append(want, "expired")
+		got, err := kv.MissingKeys(ctx, keys...)
+		if err != nil {
+			t.Fatalf("MissingKeys failed: %v", err)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("MissingKeys: got %d keys, want %d", len(got), len(want))
+		}
+		if opts != nil && opts.KeyCodec != nil {
+			continue // Stat cannot report keys the codec cannot decode
+		}
+		if sync, err := blob.SyncKeys(ctx, kv, keys); err != nil || !slices.Equal(sync, got) {
+			t.Errorf("SyncKeys: got %d keys, %v; want %d", len(sync), err, len(got))
+		}
+	}
+}
+
+func TestCountPrefix(t *testing.T) {
+	ctx := context.Background()
+	keys := map[string]string{
+		"": "", "a": "", "ab": "", "abc": "", "b": "", "\xff": "", "\xff\xff": "", "\xffa": "",
+	}
+	for _, opts := range []*sqlitestore.Options{
+		nil,
+		{KeyEncoding: sqlitestore.RawKeys},
+		{KeyEncoding: sqlitestore.TextKeys, AllowBinaryKeys: true},
+		{KeyCodec: hashKeys{}},
+	} {
+		s, _ := newTestStore(t, opts)
+		kv := mustKV(t, s, "test")
+		putAll(t, kv, keys)
+		for _, tc := range []struct {
+			prefix string
+			want   int64
+		}{
+			{"", 8}, {"a", 3}, {"ab", 2}, {"abd", 0}, {"\xff", 3}, {"\xff\xff", 1}, {"z", 0},
+		} {
+			if opts != nil && opts.KeyCodec != nil && tc.prefix != "" {
+				continue // hashed keys are reported in encoded form
+			}
+			if n, err := kv.CountPrefix(ctx, tc.prefix); err != nil || n != tc.want {
+				t.Errorf("CountPrefix(%q) [%+v]: got (%d, %v), want %d", tc.prefix, opts, n, err, tc.want)
+			}
+		}
+	}
+}
+
+func TestSizePrefix(t *testing.T) {
+	ctx := context.Background()
+	keys := map[string]string{
+		"a/1": "x", "a/2": "yy", "ab": "zzz", "b/1": "wwww", "\xff": "vvvvv",
+	}
+	for _, opts := range []*sqlitestore.Options{
+		{Uncompressed: true},
+		{Uncompressed: true, KeyEncoding: sqlitestore.RawKeys},
+		{Uncompressed: true, KeyEncoding: sqlitestore.TextKeys, AllowBinaryKeys: true},
+		{Uncompressed: true, Dedup: true},
+		{Uncompressed: true, KeyCodec: hashKeys{}},
+	} {
+		s, _ := newTestStore(t, opts)
+		kv := mustKV(t, s, "test")
+		putAll(t, kv, keys)
+		for _, tc := range []struct {
+			prefix string
+			want   int64
+		}{
+			{"", 15}, {"a", 6}, {"a/", 3}, {"b", 4}, {"\xff", 5}, {"z", 0},
+		} {
+			if opts.KeyCodec != nil && tc.prefix != "" {
+				continue // hashed keys are reported in encoded form
+			}
+			// Values are stored without encoding, so the sizes agree.
+			if l, n, err := kv.SizePrefix(ctx, tc.prefix); err != nil || l != tc.want || n != tc.want {
+				t.Errorf("SizePrefix(%q) [%+v]: got (%d, %d, %v), want %d", tc.prefix, opts, l, n, err, tc.want)
+			}
+		}
+	}
+
+	// Compressed values are smaller as stored.
+	s, _ := newTestStore(t, nil)
+	kv := mustKV(t, s, "test")
+	putAll(t, kv, map[string]string{"big": strings.Repeat("x", 10000), "other": "y"})
+	if l, n, err := kv.SizePrefix(ctx, "b"); err != nil || l != 10000 || n <= 0 || n >= l {
+		t.Errorf("SizePrefix compressed: got (%d, %d, %v), want 10000 and a smaller stored size", l, n, err)
+	}
+}
+
+func TestTextKeys(t *testing.T) {
+	ctx := context.Background()
+	t.Run("Reject", func(t *testing.T) {
+		s, url := newTestStore(t, &sqlitestore.Options{KeyEncoding: sqlitestore.TextKeys})
+		kv := mustKV(t, s, "test")
+		if err := kv.Put(ctx, blob.PutOptions{Key: "\x00\xff", Data: []byte("x")}); !errors.Is(err, sqlitestore.ErrInvalidKey) {
+			t.Errorf("Put invalid key: got %v, want %v", err, sqlitestore.ErrInvalidKey)
+		}
+		if err := kv.Put(ctx, blob.PutOptions{Key: "héllo/wörld", Data: []byte("x")}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+
+		// Keys are stored as readable text.
+		tab := dbkey.Prefix("").Keyspace("test").String()
+		var key, typ string
+		if err := openRaw(t, url).QueryRow(fmt.Sprintf(`select key, typeof(key) from "%s"`, tab)).Scan(&key, &typ); err != nil {
+			t.Fatalf("Query failed: %v", err)
+		}
+		if key != "héllo/wörld" || typ != "text" {
+			t.Errorf("Stored key: got %q (%s), want %q (text)", key, typ, "héllo/wörld")
+		}
+	})
+
+	t.Run("AllowBinary", func(t *testing.T) {
+		s, _ := newTestStore(t, &sqlitestore.Options{KeyEncoding: sqlitestore.TextKeys, AllowBinaryKeys: true})
+		kv := mustKV(t, s, "test")
+		putAll(t, kv, testData)
+		checkContents(t, kv, testData)
+
+		// The binary key sorts after all the text keys.
+		keys, err := kv.Keys(ctx, "")
+		if err != nil {
+			t.Fatalf("Keys failed: %v", err)
+		}
+		if want := []string{"", "apple", "banana", "cherry/pit", "\x00\xff"}; !slices.Equal(keys, want) {
+			t.Errorf("Keys: got %q, want %q", keys, want)
+		}
+	})
+}
+
+// xorCodec is a toy sqlitestore.Codec that flips the bits of each byte, and
+// adds a one-byte tag to detect values it did not encode.
+type xorCodec struct{}
+
+func (xorCodec) Encode(data []byte) ([]byte, error) {
+	out := []byte{'X'}
+	for _, b := range data {
+		out = append(out, ^b)
+	}
+	return out, nil
+}
+
+func (xorCodec) Decode(enc []byte) ([]byte, error) {
+	if len(enc) == 0 || enc[0] != 'X' {
+		return nil, errors.New("missing tag")
+	}
+	out := make([]byte, len(enc)-1)
+	for i, b := range enc[1:] {
+		out[i] = ^b
+	}
+	return out, nil
+}
+
+func TestCodec(t *testing.T) {
+	ctx := context.Background()
+	s, url := newTestStore(t, &sqlitestore.Options{Codec: xorCodec{}})
+	kv := mustKV(t, s, "test")
+	putAll(t, kv, testData)
+	checkContents(t, kv, testData)
+
+	tab := dbkey.Prefix("").Keyspace("test").String()
+	key := hex.EncodeToString([]byte("apple"))
+	var raw []byte
+	db := openRaw(t, url)
+	if err := db.QueryRow(fmt.Sprintf(`select value from "%s" where key = $key`, tab), sql.Named("key", key)).Scan(&raw); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if want, _ := (xorCodec{}).Encode([]byte("red")); !bytes.Equal(raw, want) {
+		t.Errorf("Stored value: got %q, want %q", raw, want)
+	}
+
+	if _, err := db.Exec(fmt.Sprintf(`update "%s" set value = 'junk' where key = $key`, tab), sql.Named("key", key)); err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if _, err := kv.Get(ctx, "apple"); !errors.Is(err, sqlitestore.ErrCorruptValue) {
+		t.Errorf("Get: got %v, want %v", err, sqlitestore.ErrCorruptValue)
+	}
+}
+
+func TestGzipCodec(t *testing.T) {
+	ctx := context.Background()
+	s, url := newTestStore(t, &sqlitestore.Options{Codec: sqlitestore.GzipCodec})
+	kv := mustKV(t, s, "test")
+	putAll(t, kv, testData)
+	checkContents(t, kv, testData)
+
+	// Each stored value is a gzip stream that the standard library can read.
+	tab := dbkey.Prefix("").Keyspace("test").String()
+	rows, err := openRaw(t, url).Query(fmt.Sprintf(`select key, value from "%s"`, tab))
+	if err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var raw []byte
+		if err := rows.Scan(&key, &raw); err != nil {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		kb, _ := hex.DecodeString(key)
+		r, err := gzip.NewReader(bytes.NewReader(raw))
+		if err != nil {
+			t.Errorf("Key %q: not a gzip stream: %v", kb, err)
+			continue
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || string(got) != testData[string(kb)] {
+			t.Errorf("Key %q: got %q, %v; want %q", kb, got, err, testData[string(kb)])
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Rows failed: %v", err)
+	}
+
+	if _, err := sqlitestore.GzipCodec.Decode([]byte("not gzip")); err == nil {
+		t.Error("Decode: got nil error for a value that is not a gzip stream")
+	}
+
+	// A gzip store supports per-namespace codecs.
+	if err := kv.SetCompression(ctx, "snappy"); err != nil {
+		t.Fatalf("SetCompression failed: %v", err)
+	}
+	checkContents(t, kv, testData)
+}
+
+func TestEncryption(t *testing.T) {
+	ctx := context.Background()
+	key := bytes.Repeat([]byte("k"), 32)
+	s, url := newTestStore(t, &sqlitestore.Options{EncryptionKey: key})
+	kv := mustKV(t, s, "test")
+	want := map[string]string{"secret": strings.Repeat("attack at dawn ", 10)}
+	putAll(t, kv, want)
+	checkContents(t, kv, want)
+
+	// The stored value does not contain the plaintext.
+	tab := dbkey.Prefix("").Keyspace("test").String()
+	var raw []byte
+	if err := openRaw(t, url).QueryRow(fmt.Sprintf(`select value from "%s"`, tab)).Scan(&raw); err != nil {
+		t.Fatalf("Query failed: %v", err)
+	}
+	if bytes.Contains(raw, []byte("attack")) {
+		t.Errorf("Stored value contains plaintext: %q", raw)
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Reopening with the wrong key cannot read the value.
+	s2, err := sqlitestore.New(url, &sqlitestore.Options{EncryptionKey: bytes.Repeat([]byte("x"), 32)})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s2.Close(ctx)
+	if _, err := mustKV(t, s2, "test").Get(ctx, "secret"); !errors.Is(err, sqlitestore.ErrCorruptValue) {
+		t.Errorf("Get with wrong key: got %v, want %v", err, sqlitestore.ErrCorruptValue)
+	}
+
+	if _, err := sqlitestore.New(url, &sqlitestore.Options{EncryptionKey: []byte("short")}); err == nil {
+		t.Error("New with invalid key: got nil, want error")
+	}
+}
+
+// hashKeys is a lossy sqlitestore.KeyCodec that stores the SHA-256 digest of
+// each key.
+type hashKeys struct{}
+
+func (hashKeys) EncodeKey(key string) []byte { h := sha256.Sum256([]byte(key)); return h[:] }
+
+func (hashKeys) DecodeKey([]byte) (string, error) { return "", errors.ErrUnsupported }
+
+func TestKeyCodec(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, &sqlitestore.Options{KeyCodec: hashKeys{}})
+	kv := mustKV(t, s, "test")
+	putAll(t, kv, testData)
+
+	for key, val := range testData {
+		if got, err := kv.Get(ctx, key); err != nil || string(got) != val {
+			t.Errorf("Get %q: got (%q, %v), want %q", key, got, err, val)
+		}
+	}
+
+	// Since keys cannot be decoded, List reports their encodings.
+	keys, err := kv.Keys(ctx, "")
+	if err != nil {
+		t.Fatalf("Keys failed: %v", err)
+	}
+	for _, key := range keys {
+		if _, ok := testData[key]; ok {
+			t.Errorf("Keys: unexpected decoded key %q", key)
+		}
+		if len(key) != sha256.Size {
+			t.Errorf("Keys: got %q, want a digest", key)
+		}
+	}
+	if len(keys) != len(testData) {
+		t.Errorf("Keys: got %d, want %d", len(keys), len(testData))
+	}
+}
+
+// reverseKeys is a reversible sqlitestore.KeyCodec that stores each key with
+// its bytes reversed, which does not preserve the order of keys.
+type reverseKeys struct{}
+
+func (reverseKeys) EncodeKey(key string) []byte {
+	ekey := []byte(key)
+	slices.Reverse(ekey)
+	return ekey
+}
+
+func (c reverseKeys) DecodeKey(ekey []byte) (string, error) {
+	return string(c.EncodeKey(string(ekey))), nil
+}
+
+func TestKeyCodecOrder(t *testing.T) {
+	// Scans that proceed in batches resume after the last key stored, so that
+	// they neither repeat nor skip keys when the codec does not preserve order.
+	ctx := context.Background()
+	s, _ := newTestStore(t, &sqlitestore.Options{KeyCodec: reverseKeys{}, Codec: sqlitestore.NoCodec})
+	kv := mustKV(t, s, "test")
+	putAll(t, kv, testData)
+
+	got := make(map[string]string)
+	if err := kv.Scan(ctx, &sqlitestore.ScanOptions{BatchSize: 1}, func(key string, data []byte) error {
+		if _, ok := got[key]; ok {
+			t.Errorf("Scan: repeated key %q", key)
+			return blob.ErrStopListing
+		}
+		got[key] = string(data)
+		return nil
+	}); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if !maps.Equal(got, testData) {
+		t.Errorf("Scan: got %v, want %v", got, testData)
+	}
+
+	dst := memstore.NewKV()
+	if err := kv.CopyTo(ctx, dst, &sqlitestore.CopyOptions{BatchSize: 1}); err != nil {
+		t.Fatalf("CopyTo failed: %v", err)
+	}
+	checkContents(t, dst, testData)
+
+	cctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	n, err := kv.RecompressWith(cctx, sqlitestore.GzipCodec, &sqlitestore.RecompressOptions{
+		BatchSize: 1,
+		Progress: func(p sqlitestore.Progress) {
+			if p.Processed > int64(len(testData)) {
+				cancel()
+			}
+		},
+	})
+	if err != nil || n != len(testData) {
+		t.Errorf("Recompress: got (%d, %v), want (%d, nil)", n, err, len(testData))
+	}
+}
+
+func TestPageSize(t *testing.T) {
+	s, url := newTestStore(t, &sqlitestore.Options{PageSize: 16384, PoolSize: 4})
+	putAll(t, mustKV(t, s, "test"), testData)
+
+	var size int
+	if err := openRaw(t, url).QueryRow(`pragma page_size`).Scan(&size); err != nil {
+		t.Fatalf("Query page size failed: %v", err)
+	}
+	if size != 16384 {
+		t.Errorf("Page size: got %d, want 16384", size)
+	}
+
+	for _, bad := range []int{100, 1000, 131072} {
+		if _, err := sqlitestore.New(url, &sqlitestore.Options{PageSize: bad}); err == nil {
+			t.Errorf("New with page size %d: got nil, want error", bad)
+		}
+	}
+}
+
+func TestIncrementalVacuum(t *testing.T) {
+	ctx := context.Background()
+	s, url := newTestStore(t, &sqlitestore.Options{AutoVacuum: "incremental", Uncompressed: true})
+	kv := mustKV(t, s, "test")
+	db := openRaw(t, url)
+
+	var mode int
+	if err := db.QueryRow(`pragma auto_vacuum`).Scan(&mode); err != nil {
+		t.Fatalf("Query auto_vacuum failed: %v", err)
+	} else if mode != 2 { // incremental
+		t.Errorf("Auto-vacuum mode: got %d, want 2", mode)
+	}
+
+	big := bytes.Repeat([]byte("x"), 1<<16)
+	for i := range 10 {
+		if err := kv.Put(ctx, blob.PutOptions{Key: fmt.Sprint(i), Data: big}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	for i := range 10 {
+		if err := kv.Delete(ctx, fmt.Sprint(i)); err != nil {
+			t.Fatalf("Delete failed: %v", err)
+		}
+	}
+	freePages := func() (n int) {
+		t.Helper()
+		if err := db.QueryRow(`pragma freelist_count`).Scan(&n); err != nil {
+			t.Fatalf("Query freelist failed: %v", err)
+		}
+		return n
+	}
+	before := freePages()
+	if before == 0 {
+		t.Fatal("No free pages after deletion")
+	}
+	if err := s.IncrementalVacuum(ctx, 5); err != nil {
+		t.Fatalf("IncrementalVacuum failed: %v", err)
+	}
+	if got := freePages(); got != before-5 {
+		t.Errorf("Free pages: got %d, want %d", got, before-5)
+	}
+	if err := s.IncrementalVacuum(ctx, 0); err != nil {
+		t.Fatalf("IncrementalVacuum failed: %v", err)
+	}
+	if got := freePages(); got != 0 {
+		t.Errorf("Free pages: got %d, want 0", got)
+	}
+
+	if _, err := sqlitestore.New(url, &sqlitestore.Options{AutoVacuum: "sometimes"}); err == nil {
+		t.Error("New with invalid mode: got nil, want error")
+	}
+}
+
+func TestBuffered(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, &sqlitestore.Options{FastLen: true})
+	kv := mustKV(t, s, "test")
+	buf := kv.Buffered(&sqlitestore.BufferOptions{MaxWrites: 3, Interval: time.Hour})
+	var _ blob.KV = buf
+
+	putAll(t, buf, map[string]string{"a": "1", "b": "2"})
+
+	// Buffered values are visible through the buffer, but not yet stored.
+	if got, err := buf.Get(ctx, "a"); err != nil || string(got) != "1" {
+		t.Errorf("Get a: got (%q, %v), want 1", got, err)
+	}
+	if st, err := buf.Stat(ctx, "a", "b", "c"); err != nil || len(st) != 2 || st["b"].Size != 1 {
+		t.Errorf("Stat: got (%v, %v), want a, b", st, err)
+	}
+	if _, err := kv.Get(ctx, "a"); !blob.IsKeyNotFound(err) {
+		t.Errorf("Get a from KV: got %v, want %v", err, blob.ErrKeyNotFound)
+	}
+	if err := buf.Put(ctx, blob.PutOptions{Key: "a", Data: []byte("x")}); !blob.IsKeyExists(err) {
+		t.Errorf("Put a again: got %v, want %v", err, blob.ErrKeyExists)
+	}
+
+	// Reaching the limit writes the buffer.
+	putAll(t, buf, map[string]string{"c": "3"})
+	checkContents(t, kv, map[string]string{"a": "1", "b": "2", "c": "3"})
+
+	// Delete and Len see buffered writes.
+	putAll(t, buf, map[string]string{"d": "4"})
+	if err := buf.Delete(ctx, "d"); err != nil {
+		t.Errorf("Delete d: unexpected error: %v", err)
+	}
+	putAll(t, buf, map[string]string{"e": "5"})
+	if n, err := buf.Len(ctx); err != nil || n != 4 {
+		t.Errorf("Len: got (%d, %v), want 4", n, err)
+	}
+
+	// Close writes any remaining values.
+	putAll(t, buf, map[string]string{"f": "6"})
+	if err := buf.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	checkContents(t, kv, map[string]string{"a": "1", "b": "2", "c": "3", "e": "5", "f": "6"})
+}
+
+func TestStoreFlush(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, nil)
+
+	// Flush works with nothing buffered.
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	kv1, kv2 := mustKV(t, s, "one"), mustKV(t, s, "two")
+	b1 := kv1.Buffered(&sqlitestore.BufferOptions{Interval: time.Hour})
+	defer b1.Close(ctx)
+	b2 := kv2.Buffered(&sqlitestore.BufferOptions{Interval: time.Hour})
+	defer b2.Close(ctx)
+	putAll(t, b1, map[string]string{"a": "1"})
+	putAll(t, b2, map[string]string{"b": "2"})
+
+	if _, err := kv1.Get(ctx, "a"); !blob.IsKeyNotFound(err) {
+		t.Errorf("Get a before Flush: got %v, want %v", err, blob.ErrKeyNotFound)
+	}
+	if err := s.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	checkContents(t, kv1, map[string]string{"a": "1"})
+	checkContents(t, kv2, map[string]string{"b": "2"})
+}
+
+func TestCloseBuffered(t *testing.T) {
+	ctx := context.Background()
+	url := "file:" + filepath.Join(t.TempDir(), "buffered.db")
+	s, err := sqlitestore.New(url, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	buf := mustKV(t, s, "test").Buffered(&sqlitestore.BufferOptions{Interval: time.Hour})
+	putAll(t, buf, testData)
+
+	// Closing the store writes the values still buffered.
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := buf.Close(ctx); err != nil {
+		t.Errorf("Close buffer after store: unexpected error: %v", err)
+	}
+
+	r, err := sqlitestore.New(url, nil)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer r.Close(ctx)
+	checkContents(t, mustKV(t, r, "test"), testData)
+}
+
+func BenchmarkBuffered(b *testing.B) {
+	ctx := context.Background()
+	url := "file:" + filepath.Join(b.TempDir(), "bench.db")
+	s, err := sqlitestore.New(url, nil)
+	if err != nil {
+		b.Fatalf("New failed: %v", err)
+	}
+	defer s.Close(ctx)
+	kv, err := s.KV(ctx, "bench")
+	if err != nil {
+		b.Fatalf("KV failed: %v", err)
+	}
+	for _, buffered := range []bool{false, true} {
+		b.Run(fmt.Sprintf("Buffered=%v", buffered), func(b *testing.B) {
+			var dst blob.KV = kv
+			if buffered {
+				buf := kv.(sqlitestore.KV).Buffered(nil)
+				defer buf.Close(ctx)
+				dst = buf
+			}
+			for i := range b.N {
+				if err := dst.Put(ctx, blob.PutOptions{
+					Key: fmt.Sprintf("key-%d-%v", i, buffered), Data: []byte("value"), Replace: true,
+				}); err != nil {
+					b.Fatalf("Put failed: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestWithTx(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, &sqlitestore.Options{FastLen: true})
+	kv := mustKV(t, s, "test")
+	putAll(t, kv, map[string]string{"old": "value"})
+
+	// Move a value from one key to another atomically.
+	move := func(tx *sqlitestore.KVTx) error {
+		data, err := tx.Get(ctx, "old")
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(ctx, "old"); err != nil {
+			return err
+		}
+		return tx.Put(ctx, blob.PutOptions{Key: "new", Data: data})
+	}
+	if err := kv.WithTx(ctx, move); err != nil {
+		t.Fatalf("WithTx failed: %v", err)
+	}
+	checkContents(t, kv, map[string]string{"new": "value"})
+
+	// A failed transaction has no effect.
+	putAll(t, kv, map[string]string{"old": "other"})
+	if err := kv.WithTx(ctx, move); !blob.IsKeyExists(err) {
+		t.Errorf("WithTx: got %v, want %v", err, blob.ErrKeyExists)
+	}
+	checkContents(t, kv, map[string]string{"new": "value", "old": "other"})
+	if n, err := kv.Len(ctx); err != nil || n != 2 {
+		t.Errorf("Len: got (%d, %v), want 2", n, err)
+	}
+
+	// The transaction cannot be used after WithTx returns.
+	var saved *sqlitestore.KVTx
+	if err := kv.WithTx(ctx, func(tx *sqlitestore.KVTx) error { saved = tx; return nil }); err != nil {
+		t.Fatalf("WithTx failed: %v", err)
+	}
+	if _, err := saved.Get(ctx, "new"); err == nil {
+		t.Error("Get after WithTx: got nil error, want error")
+	}
+}
+
+func TestShared(t *testing.T) {
+	ctx := context.Background()
+	url := "file:" + filepath.Join(t.TempDir(), "shared.db")
+	opts := &sqlitestore.Options{Shared: true}
+	s1, err := sqlitestore.New(url, opts)
+	if err != nil {
+		t.Fatalf("New 1 failed: %v", err)
+	}
+	defer s1.Close(ctx)
+	s2, err := sqlitestore.New(url, opts)
+	if err != nil {
+		t.Fatalf("New 2 failed: %v", err)
+	}
+	defer s2.Close(ctx)
+
+	putAll(t, mustKV(t, s1, "one"), testData)
+	putAll(t, mustKV(t, s2, "two"), map[string]string{"x": "y"})
+
+	// Closing one store does not close the database for the other.
+	if err := s1.Close(ctx); err != nil {
+		t.Fatalf("Close 1 failed: %v", err)
+	}
+	checkContents(t, mustKV(t, s2, "one"), testData)
+	if err := s2.Close(ctx); err != nil {
+		t.Fatalf("Close 2 failed: %v", err)
+	}
+
+	// Once all are closed, the next New opens a new handle.
+	s3, err := sqlitestore.New(url, opts)
+	if err != nil {
+		t.Fatalf("New 3 failed: %v", err)
+	}
+	defer s3.Close(ctx)
+	checkContents(t, mustKV(t, s3, "two"), map[string]string{"x": "y"})
+}
+
+func TestBusyRetry(t *testing.T) {
+	ctx := context.Background()
+	url := "file:" + filepath.Join(t.TempDir(), "busy.db")
+	s1, err := sqlitestore.New(url, nil)
+	if err != nil {
+		t.Fatalf("New 1 failed: %v", err)
+	}
+	defer s1.Close(ctx)
+	kv1 := mustKV(t, s1, "test")
+
+	// Hold a write transaction on s1 while writing through s2.
+	holdTx := func(d time.Duration) (started, done chan struct{}) {
+		started, done = make(chan struct{}), make(chan struct{})
+		go func() {
+			defer close(done)
+			if err := kv1.WithTx(ctx, func(tx *sqlitestore.KVTx) error {
+				if err := tx.Put(ctx, blob.PutOptions{Key: "held", Data: []byte("x"), Replace: true}); err != nil {
+					return err
+				}
+				close(started)
+				time.Sleep(d)
+				return nil
+			}); err != nil {
+				t.Errorf("WithTx failed: %v", err)
+			}
+		}()
+		return started, done
+	}
+	put := func(opts *sqlitestore.Options) error {
+		s2, err := sqlitestore.New(url, opts)
+		if err != nil {
+			t.Fatalf("New 2 failed: %v", err)
+		}
+		defer s2.Close(ctx)
+		kv2 := mustKV(t, s2, "test")
+		return kv2.Put(ctx, blob.PutOptions{Key: "other", Data: []byte("y"), Replace: true})
+	}
+
+	t.Run("NoRetry", func(t *testing.T) {
+		started, done := holdTx(100 * time.Millisecond)
+		<-started
+		err := put(&sqlitestore.Options{BusyTimeout: -1, BusyRetries: -1})
+		if err == nil {
+			t.Error("Put: got nil, want busy error")
+		}
+		<-done
+	})
+	t.Run("Retry", func(t *testing.T) {
+		started, done := holdTx(50 * time.Millisecond)
+		<-started
+		if err := put(&sqlitestore.Options{
+			BusyTimeout:    -1,
+			BusyRetries:    8,
+			BusyRetryDelay: 5 * time.Millisecond,
+		}); err != nil {
+			t.Errorf("Put: unexpected error: %v", err)
+		}
+		<-done
+	})
+}
+
+func TestErrorClass(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "ro.db")
+	s, err := sqlitestore.New("file:"+path, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	putAll(t, mustKV(t, s, "test"), testData)
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	ro, err := sqlitestore.New("file:"+path+"?mode=ro", &sqlitestore.Options{NoVacuum: true})
+	if err != nil {
+		t.Fatalf("New read-only failed: %v", err)
+	}
+	defer ro.Close(ctx)
+	kv := mustKV(t, ro, "test")
+	checkContents(t, kv, testData)
+
+	err = kv.Put(ctx, blob.PutOptions{Key: "new", Data: []byte("value")})
+	if !errors.Is(err, sqlitestore.ErrReadOnly) {
+		t.Errorf("Put: got %v, want %v", err, sqlitestore.ErrReadOnly)
+	}
+	if errors.Is(err, sqlitestore.ErrDiskFull) || errors.Is(err, sqlitestore.ErrCorrupt) {
+		t.Errorf("Put: error %v has the wrong class", err)
+	}
+	if err := kv.Delete(ctx, "apple"); !errors.Is(err, sqlitestore.ErrReadOnly) {
+		t.Errorf("Delete: got %v, want %v", err, sqlitestore.ErrReadOnly)
+	}
+}
+
+func TestErrorCoder(t *testing.T) {
+	ctx := context.Background()
+
+	// The default coder detects a conflicting key for the default driver.
+	for _, opts := range []*sqlitestore.Options{nil, {WithoutRowID: true}} {
+		s, _ := newTestStore(t, opts)
+		kv := mustKV(t, s, "test")
+		putAll(t, kv, testData)
+		if err := kv.Put(ctx, blob.PutOptions{Key: "apple", Data: []byte("x")}); !blob.IsKeyExists(err) {
+			t.Errorf("Put apple (%+v): got %v, want %v", opts, err, blob.ErrKeyExists)
+		}
+	}
+
+	// A coder that finds no result codes still detects a conflicting key, by
+	// the message of the error.
+	var calls int
+	s, _ := newTestStore(t, &sqlitestore.Options{
+		ErrorCoder: sqlitestore.ErrorCoderFunc(func(err error) (int, bool) {
+			calls++
+			return 0, false
+		}),
+	})
+	kv := mustKV(t, s, "test")
+	putAll(t, kv, testData)
+	err := kv.Put(ctx, blob.PutOptions{Key: "apple", Data: []byte("x")})
+	if !blob.IsKeyExists(err) {
+		t.Errorf("Put apple: got %v, want %v", err, blob.ErrKeyExists)
+	}
+	if calls == 0 {
+		t.Error("ErrorCoder was not called")
+	}
+
+	// A coder that reports the codes of the driver detects it by code.
+	s, _ = newTestStore(t, &sqlitestore.Options{
+		ErrorCoder: sqlitestore.ErrorCoderFunc(func(err error) (int, bool) {
+			var cerr interface{ Code() int }
+			if errors.As(err, &cerr) {
+				return cerr.Code(), true
+			}
+			return 0, false
+		}),
+	})
+	kv = mustKV(t, s, "test")
+	putAll(t, kv, testData)
+	if err := kv.Put(ctx, blob.PutOptions{Key: "apple", Data: []byte("x")}); !blob.IsKeyExists(err) {
+		t.Errorf("Put apple: got %v, want %v", err, blob.ErrKeyExists)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	t.Run("NotADatabase", func(t *testing.T) {
+		path := filepath.Join(dir, "junk.db")
+		if err := os.WriteFile(path, bytes.Repeat([]byte("junk"), 1024), 0600); err != nil {
+			t.Fatalf("Write junk: %v", err)
+		}
+		s, err := sqlitestore.New("file:"+path, &sqlitestore.Options{NoVacuum: true})
+		if err == nil {
+			s.Close(ctx)
+		}
+		if !errors.Is(err, sqlitestore.ErrCorrupt) {
+			t.Errorf("New: got %v, want %v", err, sqlitestore.ErrCorrupt)
+		}
+	})
+
+	t.Run("Intact", func(t *testing.T) {
+		s, _ := newTestStore(t, nil)
+		putAll(t, mustKV(t, s, "test"), testData)
+		dest := filepath.Join(dir, "intact.db")
+		if err := s.Recover(ctx, dest); err != nil {
+			t.Fatalf("Recover failed: %v", err)
+		}
+		r, err := sqlitestore.New("file:"+dest, nil)
+		if err != nil {
+			t.Fatalf("New recovered failed: %v", err)
+		}
+		defer r.Close(ctx)
+		checkContents(t, mustKV(t, r, "test"), testData)
+
+		// Recovering into a non-empty database fails.
+		if err := s.Recover(ctx, dest); err == nil {
+			t.Error("Recover into non-empty database: got nil, want error")
+		}
+	})
+
+	t.Run("Damaged", func(t *testing.T) {
+		path := filepath.Join(dir, "damaged.db")
+		s, err := sqlitestore.New("file:"+path, &sqlitestore.Options{Uncompressed: true})
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		kv := mustKV(t, s, "test")
+		const numKeys = 2000
+		puts := make([]blob.PutOptions, numKeys)
+		for i := range puts {
+			puts[i] = blob.PutOptions{Key: fmt.Sprintf("key-%04d", i), Data: bytes.Repeat([]byte{byte(i)}, 200)}
+		}
+		if err := kv.BatchPut(ctx, puts); err != nil {
+			t.Fatalf("BatchPut failed: %v", err)
+		}
+		if err := s.Close(ctx); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+
+		// Clobber some pages in the middle of the file.
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Read: %v", err)
+		}
+		mid := len(data) / 2
+		copy(data[mid:mid+8192], bytes.Repeat([]byte{0xff}, 8192))
+		if err := os.WriteFile(path, data, 0600); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+
+		d, err := sqlitestore.New("file:"+path, &sqlitestore.Options{Uncompressed: true, NoVacuum: true})
+		if err != nil {
+			t.Fatalf("New damaged failed: %v", err)
+		}
+		defer d.Close(ctx)
+		dest := filepath.Join(dir, "salvaged.db")
+		if err := d.Recover(ctx, dest); !errors.Is(err, sqlitestore.ErrCorrupt) {
+			t.Errorf("Recover: got %v, want %v", err, sqlitestore.ErrCorrupt)
+		}
+
+		r, err := sqlitestore.New("file:"+dest, &sqlitestore.Options{Uncompressed: true})
+		if err != nil {
+			t.Fatalf("New salvaged failed: %v", err)
+		}
+		defer r.Close(ctx)
+		n, err := mustKV(t, r, "test").Len(ctx)
+		if err != nil {
+			t.Fatalf("Len: %v", err)
+		}
+		t.Logf("Salvaged %d of %d keys", n, numKeys)
+		if n == 0 || n >= numKeys {
+			t.Errorf("Salvaged %d keys, want 0 < n < %d", n, numKeys)
+		}
+	})
+}
+
+func TestSchemaVersion(t *testing.T) {
+	ctx := context.Background()
+	url := "file:" + filepath.Join(t.TempDir(), "schema.db")
+	db := openRaw(t, url)
+
+	// A table with the original schema is upgraded when the store is opened.
+	tab := dbkey.Prefix("").Keyspace("test").String()
+	if _, err := db.Exec(fmt.Sprintf(`create table "%s" (
+  key BLOB unique not null,
+  value BLOB not null,
+  vsize INTEGER not null
+)`, tab)); err != nil {
+		t.Fatalf("Create table failed: %v", err)
+	}
+	if _, err := db.Exec(fmt.Sprintf(`insert into "%s" (key, value, vsize) values ($key, $value, 3)`, tab),
+		sql.Named("key", hex.EncodeToString([]byte("k"))), sql.Named("value", []byte("old"))); err != nil {
+		t.Fatalf("Insert row failed: %v", err)
+	}
+	for range 2 { // migration is idempotent
+		s, err := sqlitestore.New(url, &sqlitestore.Options{Codec: sqlitestore.NoCodec})
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		if err := s.Close(ctx); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}
+	var version, ncol int
+	if err := db.QueryRow(`select version from schema_version where name = $name`,
+		sql.Named("name", tab)).Scan(&version); err != nil {
+		t.Fatalf("Read version: %v", err)
+	} else if version != 2 {
+		t.Errorf("Schema version: got %d, want 2", version)
+	}
+	if err := db.QueryRow(`select count(*) from pragma_table_info($tab)`, sql.Named("tab", tab)).Scan(&ncol); err != nil {
+		t.Fatalf("Read columns: %v", err)
+	} else if ncol != 9 {
+		t.Errorf("Columns: got %d, want 9", ncol)
+	}
+
+	// An existing value is recorded as encoded by the codec the store was
+	// opened with, and can be read with a store using another.
+	var codec byte
+	if err := db.QueryRow(fmt.Sprintf(`select codec from "%s"`, tab)).Scan(&codec); err != nil {
+		t.Fatalf("Read codec: %v", err)
+	} else if codec != sqlitestore.CodecNone {
+		t.Errorf("Codec: got %d, want %d", codec, sqlitestore.CodecNone)
+	}
+	s, err := sqlitestore.New(url, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if got, err := mustKV(t, s, "test").Get(ctx, "k"); err != nil || string(got) != "old" {
+		t.Errorf("Get: got (%q, %v), want (old, nil)", got, err)
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// A database with a newer schema is not opened.
+	if _, err := db.Exec(`update schema_version set version = 99`); err != nil {
+		t.Fatalf("Update version: %v", err)
+	}
+	if s, err := sqlitestore.New(url, nil); err == nil {
+		s.Close(ctx)
+		t.Error("New with newer schema: got nil, want error")
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	ctx := context.Background()
+	for _, table := range []string{"", "custom"} {
+		t.Run("Table="+table, func(t *testing.T) {
+			s, _ := newTestStore(t, &sqlitestore.Options{Table: table})
+			if got, err := s.Namespaces(ctx); err != nil || len(got) != 0 {
+				t.Errorf("Namespaces empty: got (%v, %v), want none", got, err)
+			}
+			mustKV(t, s, "one")
+			mustKV(t, s, "two")
+			sub, err := s.Sub(ctx, "sub")
+			if err != nil {
+				t.Fatalf("Sub failed: %v", err)
+			}
+			if _, err := sub.KV(ctx, "three"); err != nil {
+				t.Fatalf("Sub KV failed: %v", err)
+			}
+
+			root := dbkey.Prefix("")
+			want := []dbkey.Prefix{root.Keyspace("one"), root.Keyspace("two"), root.Sub("sub").Keyspace("three")}
+			slices.SortFunc(want, func(a, b dbkey.Prefix) int { return strings.Compare(a.String(), b.String()) })
+			got, err := s.Namespaces(ctx)
+			if err != nil {
+				t.Fatalf("Namespaces failed: %v", err)
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("Namespaces: got %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestNamespacesOverlap(t *testing.T) {
+	// The tables of a store named "a" share a prefix with those of a store
+	// named "a_b" in the same database, but do not belong to it.
+	ctx := context.Background()
+	a, url := newTestStore(t, &sqlitestore.Options{Table: "a"})
+	mustKV(t, a, "one")
+	ab, err := sqlitestore.New(url, &sqlitestore.Options{Table: "a_b"})
+	if err != nil {
+		t.Fatalf("New a_b failed: %v", err)
+	}
+	defer ab.Close(ctx)
+	mustKV(t, ab, "two")
+
+	root := dbkey.Prefix("")
+	for _, tc := range []struct {
+		s    sqlitestore.Store
+		want dbkey.Prefix
+	}{{a, root.Keyspace("one")}, {ab, root.Keyspace("two")}} {
+		if got, err := tc.s.Namespaces(ctx); err != nil || !slices.Equal(got, []dbkey.Prefix{tc.want}) {
+			t.Errorf("Namespaces: got (%v, %v), want [%v]", got, err, tc.want)
+		}
+	}
+
+	// The tables of "a_b" are not versioned by "a", and must not be mistaken
+	// for its unversioned tables.
+	if s, err := sqlitestore.New(url, &sqlitestore.Options{Table: "a", NoCreate: true}); err != nil {
+		t.Errorf("New a with NoCreate failed: %v", err)
+	} else {
+		s.Close(ctx)
+	}
+}
+
+func TestDropNamespace(t *testing.T) {
+	ctx := context.Background()
+	s, url := newTestStore(t, &sqlitestore.Options{Dedup: true, FastLen: true})
+	putAll(t, mustKV(t, s, "keep"), map[string]string{"a": "shared", "b": "kept"})
+	putAll(t, mustKV(t, s, "drop"), map[string]string{"a": "shared", "c": "dropped"})
+
+	drop := dbkey.Prefix("").Keyspace("drop")
+	if err := s.DropNamespace(ctx, drop); err != nil {
+		t.Fatalf("DropNamespace failed: %v", err)
+	}
+	if got, err := s.Namespaces(ctx); err != nil || !slices.Equal(got, []dbkey.Prefix{dbkey.Prefix("").Keyspace("keep")}) {
+		t.Errorf("Namespaces: got (%v, %v), want only keep", got, err)
+	}
+
+	// Content referenced only by the dropped table is released.
+	var n int
+	if err := openRaw(t, url).QueryRow(`select count(*) from blob_content`).Scan(&n); err != nil {
+		t.Fatalf("Count content: %v", err)
+	} else if n != 2 {
+		t.Errorf("Content rows: got %d, want 2", n)
+	}
+	checkContents(t, mustKV(t, s, "keep"), map[string]string{"a": "shared", "b": "kept"})
+
+	// Dropping again, or dropping a namespace that never existed, is OK.
+	if err := s.DropNamespace(ctx, drop); err != nil {
+		t.Errorf("DropNamespace again: %v", err)
+	}
+	if err := s.DropNamespace(ctx, dbkey.Prefix("").Keyspace("nonesuch")); err != nil {
+		t.Errorf("DropNamespace nonesuch: %v", err)
+	}
+
+	// Reopening the namespace yields an empty table.
+	if n, err := mustKV(t, s, "drop").Len(ctx); err != nil || n != 0 {
+		t.Errorf("Len after drop: got (%d, %v), want (0, nil)", n, err)
+	}
+}
+
+func TestRenameNamespace(t *testing.T) {
+	ctx := context.Background()
+	s, url := newTestStore(t, &sqlitestore.Options{FastLen: true, CoveringIndex: true})
+	root := dbkey.Prefix("")
+	putAll(t, mustKV(t, s, "old"), testData)
+	putAll(t, mustKV(t, s, "other"), map[string]string{"x": "y"})
+
+	if err := s.RenameNamespace(ctx, root.Keyspace("old"), root.Keyspace("other")); err == nil {
+		t.Error("Rename onto existing table: got nil, want error")
+	}
+	if err := s.RenameNamespace(ctx, root.Keyspace("nonesuch"), root.Keyspace("new")); err == nil {
+		t.Error("Rename missing table: got nil, want error")
+	}
+	if err := s.RenameNamespace(ctx, root.Keyspace("old"), root.Keyspace("new")); err != nil {
+		t.Fatalf("RenameNamespace failed: %v", err)
+	}
+	checkContents(t, mustKV(t, s, "new"), testData)
+	if n, err := mustKV(t, s, "old").Len(ctx); err != nil || n != 0 {
+		t.Errorf("Len old: got (%d, %v), want (0, nil)", n, err)
+	}
+
+	// The indexes of the table follow it.
+	newTab := root.Keyspace("new").String()
+	var n int
+	if err := openRaw(t, url).QueryRow(`select count(*) from sqlite_schema where type = 'index' and tbl_name = $tab and name like $tab || '\_%' escape '\'`,
+		sql.Named("tab", newTab)).Scan(&n); err != nil {
+		t.Fatalf("Count indexes: %v", err)
+	} else if n != 2 {
+		t.Errorf("Indexes on renamed table: got %d, want 2", n)
+	}
+}
+
+func TestCloneNamespace(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, &sqlitestore.Options{Dedup: true, FastLen: true})
+	root := dbkey.Prefix("")
+	putAll(t, mustKV(t, s, "src"), testData)
+
+	if err := s.CloneNamespace(ctx, root.Keyspace("nonesuch"), root.Keyspace("dst")); err == nil {
+		t.Error("Clone missing table: got nil, want error")
+	}
+	if err := s.CloneNamespace(ctx, root.Keyspace("src"), root.Keyspace("dst")); err != nil {
+		t.Fatalf("CloneNamespace failed: %v", err)
+	}
+	if err := s.CloneNamespace(ctx, root.Keyspace("src"), root.Keyspace("dst")); err == nil {
+		t.Error("Clone onto existing table: got nil, want error")
+	}
+
+	// Changes to the clone do not affect the source, including deleting
+	// values whose content is shared.
+	dst := mustKV(t, s, "dst")
+	checkContents(t, dst, testData)
+	for key := range testData {
+		if err := dst.Delete(ctx, key); err != nil {
+			t.Fatalf("Delete %q: %v", key, err)
+		}
+	}
+	if n, err := dst.Len(ctx); err != nil || n != 0 {
+		t.Errorf("Len clone: got (%d, %v), want (0, nil)", n, err)
+	}
+	checkContents(t, mustKV(t, s, "src"), testData)
+}
+
+func TestOverflow(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	url := "file:" + filepath.Join(dir, "main.db")
+	cold := filepath.Join(dir, "cold.db")
+	opts := &sqlitestore.Options{Overflow: cold, OverflowSize: 100, Uncompressed: true}
+
+	s, err := sqlitestore.New(url, opts)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	want := map[string]string{
+		"small": "tiny value",
+		"large": strings.Repeat("large value ", 20),
+	}
+	putAll(t, mustKV(t, s, "test"), want)
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Only the large value is stored in the overflow database.
+	var n int
+	if err := openRaw(t, "file:"+cold).QueryRow(`select count(*) from blob_content`).Scan(&n); err != nil {
+		t.Fatalf("Count overflow: %v", err)
+	} else if n != 1 {
+		t.Errorf("Overflow values: got %d, want 1", n)
+	}
+	tab := dbkey.Prefix("").Keyspace("test").String()
+	if err := openRaw(t, url).QueryRow(fmt.Sprintf(`select count(*) from "%s" where ref is null`, tab)).Scan(&n); err != nil {
+		t.Fatalf("Count inline: %v", err)
+	} else if n != 1 {
+		t.Errorf("Inline values: got %d, want 1", n)
+	}
+
+	r, err := sqlitestore.New(url, opts)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer r.Close(ctx)
+	kv := mustKV(t, r, "test")
+	checkContents(t, kv, want)
+	if err := kv.Delete(ctx, "large"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := openRaw(t, "file:"+cold).QueryRow(`select count(*) from blob_content`).Scan(&n); err != nil {
+		t.Fatalf("Count overflow: %v", err)
+	} else if n != 0 {
+		t.Errorf("Overflow values after delete: got %d, want 0", n)
+	}
+}
+
+func TestPutIfAbsent(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, &sqlitestore.Options{FastLen: true, Dedup: true})
+	kv := mustKV(t, s, "test")
+
+	if ok, err := kv.PutIfAbsent(ctx, "key", []byte("first")); err != nil || !ok {
+		t.Errorf("PutIfAbsent new: got (%v, %v), want (true, nil)", ok, err)
+	}
+	if ok, err := kv.PutIfAbsent(ctx, "key", []byte("second")); err != nil || ok {
+		t.Errorf("PutIfAbsent existing: got (%v, %v), want (false, nil)", ok, err)
+	}
+	checkContents(t, kv, map[string]string{"key": "first"})
+
+	// An expired key counts as absent.
+	if err := kv.PutTTL(ctx, blob.PutOptions{Key: "brief", Data: []byte("gone")}, time.Nanosecond); err != nil {
+		t.Fatalf("PutTTL failed: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	if ok, err := kv.PutIfAbsent(ctx, "brief", []byte("back")); err != nil || !ok {
+		t.Errorf("PutIfAbsent expired: got (%v, %v), want (true, nil)", ok, err)
+	}
+	checkContents(t, kv, map[string]string{"key": "first", "brief": "back"})
+}
+
+func TestAppend(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, &sqlitestore.Options{FastLen: true})
+	kv := mustKV(t, s, "test")
+
+	// Concurrent appends are not lost.
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := kv.Append(ctx, "log", []byte{'a' + byte(i)}); err != nil {
+				t.Errorf("Append %d: %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+	got, err := kv.Get(ctx, "log")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	slices.Sort(got)
+	if string(got) != "abcdefghij" {
+		t.Errorf("Get: got %q, want all ten appends", got)
+	}
+	if n, err := kv.Len(ctx); err != nil || n != 1 {
+		t.Errorf("Len: got (%d, %v), want (1, nil)", n, err)
+	}
+}
+
+func TestDatabaseStats(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, &sqlitestore.Options{Uncompressed: true})
+	kv := mustKV(t, s, "test")
+	for i := range 50 {
+		if err := kv.Put(ctx, blob.PutOptions{
+			Key:  fmt.Sprintf("key-%d", i),
+			Data: bytes.Repeat([]byte("x"), 4096),
+		}); err != nil {
+			t.Fatalf("Put %d: %v", i, err)
+		}
+	}
+	before, err := s.DatabaseStats(ctx)
+	if err != nil {
+		t.Fatalf("DatabaseStats failed: %v", err)
+	}
+	if before.PageSize <= 0 || before.Size != before.PageSize*before.PageCount {
+		t.Errorf("DatabaseStats: inconsistent sizes %+v", before)
+	}
+
+	// Deleting values leaves free pages to reclaim.
+	for i := range 50 {
+		if err := kv.Delete(ctx, fmt.Sprintf("key-%d", i)); err != nil {
+			t.Fatalf("Delete %d: %v", i, err)
+		}
+	}
+	after, err := s.DatabaseStats(ctx)
+	if err != nil {
+		t.Fatalf("DatabaseStats failed: %v", err)
+	}
+	if after.FreePages <= before.FreePages || after.Reclaimable != after.FreePages*after.PageSize {
+		t.Errorf("DatabaseStats after delete: got %+v, want more free pages than %+v", after, before)
+	}
+}
+
+func TestFileSize(t *testing.T) {
+	ctx := context.Background()
+	s, url := newTestStore(t, &sqlitestore.Options{JournalMode: "wal"})
+	if got, want := s.FilePath(), strings.TrimPrefix(url, "file:"); got != want {
+		t.Errorf("FilePath: got %q, want %q", got, want)
+	}
+	kv := mustKV(t, s, "test")
+	putAll(t, kv, testData)
+
+	size, err := s.FileSize()
+	if err != nil {
+		t.Fatalf("FileSize failed: %v", err)
+	}
+	var want int64
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		fi, err := os.Stat(s.FilePath() + suffix)
+		if err != nil {
+			t.Fatalf("Stat %q: %v", suffix, err)
+		}
+		want += fi.Size()
+	}
+	if size != want {
+		t.Errorf("FileSize: got %d, want %d", size, want)
+	}
+
+	mem, err := sqlitestore.New("file::memory:", nil)
+	if err != nil {
+		t.Fatalf("New in-memory failed: %v", err)
+	}
+	defer mem.Close(ctx)
+	if got := mem.FilePath(); got != "" {
+		t.Errorf("FilePath in-memory: got %q, want empty", got)
+	}
+	if got, err := mem.FileSize(); got != 0 || err != nil {
+		t.Errorf("FileSize in-memory: got %d, %v; want 0, nil", got, err)
+	}
+}
+
+func TestSecureDelete(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "secure.db")
+	s, err := sqlitestore.New("file:"+path, &sqlitestore.Options{
+		SecureDelete: "on",
+		NoVacuum:     true,
+		Uncompressed: true,
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	kv := mustKV(t, s, "test")
+	const secret = "the-secret-value-to-scrub"
+	putAll(t, kv, map[string]string{"secret": secret, "other": "value"})
+	if err := kv.Delete(ctx, "secret"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if bytes.Contains(data, []byte(secret)) {
+		t.Error("Deleted value remains in the database file")
+	}
+
+	if _, err := sqlitestore.New("file:"+path, &sqlitestore.Options{SecureDelete: "sorta"}); err == nil {
+		t.Error("New with invalid secure-delete mode: got nil, want error")
+	}
+}
+
+func TestTempStore(t *testing.T) {
+	ctx := context.Background()
+	s, url := newTestStore(t, &sqlitestore.Options{TempStore: "memory"})
+	kv := mustKV(t, s, "test")
+	putAll(t, kv, testData)
+	checkContents(t, kv, testData)
+	if err := kv.List(ctx, "", func(string) error { return nil }); err != nil {
+		t.Errorf("List failed: %v", err)
+	}
+
+	if _, err := sqlitestore.New(url, &sqlitestore.Options{TempStore: "floppy"}); err == nil {
+		t.Error("New with invalid temp-store mode: got nil, want error")
+	}
+}
+
+func TestLockingMode(t *testing.T) {
+	ctx := context.Background()
+	s, url := newTestStore(t, &sqlitestore.Options{LockingMode: "EXCLUSIVE"})
+	putAll(t, mustKV(t, s, "test"), testData)
+
+	// While s holds the database, another store cannot use it.
+	if o, err := sqlitestore.New(url, &sqlitestore.Options{BusyTimeout: -1, BusyRetries: -1}); err == nil {
+		o.Close(ctx)
+		t.Error("New while locked: got nil, want error")
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	o, err := sqlitestore.New(url, nil)
+	if err != nil {
+		t.Fatalf("New after close failed: %v", err)
+	}
+	defer o.Close(ctx)
+	checkContents(t, mustKV(t, o, "test"), testData)
+
+	if _, err := sqlitestore.New(url, &sqlitestore.Options{LockingMode: "solo"}); err == nil {
+		t.Error("New with invalid locking mode: got nil, want error")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := (*sqlitestore.Options)(nil).Validate(); err != nil {
+		t.Errorf("Validate nil: unexpected error: %v", err)
+	}
+	if err := sqlitestore.DefaultOptions().Validate(); err != nil {
+		t.Errorf("Validate defaults: unexpected error: %v", err)
+	}
+	s, _ := newTestStore(t, sqlitestore.DefaultOptions())
+	putAll(t, mustKV(t, s, "test"), testData)
+
+	for _, tc := range []struct {
+		name string
+		opts sqlitestore.Options
+	}{
+		{"Driver", sqlitestore.Options{Driver: "nonesuch"}},
+		{"Table", sqlitestore.Options{Table: "not-an-ident"}},
+		{"KeyEncoding", sqlitestore.Options{KeyEncoding: 99}},
+		{"EncryptionKey", sqlitestore.Options{EncryptionKey: []byte("short")}},
+		{"PageSize", sqlitestore.Options{PageSize: 1000}},
+		{"JournalMode", sqlitestore.Options{JournalMode: "wall"}},
+		{"TempStore", sqlitestore.Options{TempStore: "disk"}},
+	} {
+		if err := tc.opts.Validate(); err == nil {
+			t.Errorf("Validate with invalid %s: got nil, want error", tc.name)
+		}
+		if _, err := sqlitestore.New("file::memory:", &tc.opts); err == nil {
+			t.Errorf("New with invalid %s: got nil, want error", tc.name)
+		}
+	}
+}
+
+func TestDefaultTimeout(t *testing.T) {
+	s, _ := newTestStore(t, &sqlitestore.Options{DefaultTimeout: time.Nanosecond})
+	kv := mustKV(t, s, "test")
+
+	// Without a deadline of its own, an operation gets the default.
+	err := kv.Put(context.Background(), blob.PutOptions{Key: "a", Data: []byte("b")})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Put: got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// An operation with its own deadline keeps it.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if err := kv.Put(ctx, blob.PutOptions{Key: "a", Data: []byte("b")}); err != nil {
+		t.Errorf("Put with deadline: unexpected error: %v", err)
+	}
+}
+
+func TestJournalMode(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "test.db")
+
+	// An unknown mode is rejected before the database is opened.
+	if _, err := sqlitestore.New("file:"+path, &sqlitestore.Options{JournalMode: "wall"}); err == nil {
+		t.Error("New with invalid journal mode: got nil, want error")
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Database was created for an invalid journal mode: %v", err)
+	}
+
+	// A known mode the database cannot use is rejected after opening.
+	for _, tc := range []struct{ url, mode string }{
+		{"file::memory:", "wal"},
+		{"file:" + path, "wal2"}, // not supported by the default driver
+	} {
+		if s, err := sqlitestore.New(tc.url, &sqlitestore.Options{JournalMode: tc.mode}); err == nil {
+			s.Close(ctx)
+			t.Errorf("New %s with journal mode %q: got nil, want error", tc.url, tc.mode)
+		}
+	}
+
+	s, err := sqlitestore.New("file:"+path, &sqlitestore.Options{JournalMode: "WAL"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close(ctx)
+	putAll(t, mustKV(t, s, "test"), testData)
+	if _, err := os.Stat(path + "-wal"); err != nil {
+		t.Errorf("Stat WAL: %v", err)
+	}
+}
+
+// connRecorder is a [driver.Driver] that records the connections it opens.
+type connRecorder struct {
+	driver.Driver
+
+	mu    sync.Mutex
+	conns []driver.Conn
+}
+
+func (c *connRecorder) Open(name string) (driver.Conn, error) {
+	conn, err := c.Driver.Open(name)
+	if err == nil {
+		c.mu.Lock()
+		c.conns = append(c.conns, conn)
+		c.mu.Unlock()
+	}
+	return conn, err
+}
+
+// recordConns registers a connRecorder for the default driver, under the
+// name "sqlite-recorder".
+var recordConns = sync.OnceValue(func() *connRecorder {
+	db, err := sql.Open("sqlite", "")
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	rec := &connRecorder{Driver: db.Driver()}
+	sql.Register("sqlite-recorder", rec)
+	return rec
+})
+
+// holdConns holds n read transactions on kv open at once, so that the
+// connection pool of its store opens multiple connections. The store should
+// have at least n connections.
+func holdConns(ctx context.Context, kv blob.KV, n int) {
+	var started, wg sync.WaitGroup
+	started.Add(n)
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			first := true
+			kv.List(ctx, "", func(string) error {
+				if first {
+					first = false
+					started.Done()
+					started.Wait()
+				}
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+}
+
+// A queryRecorder is a driver that records the queries prepared on its
+// connections. Its connections expose only the basic driver.Conn methods, so
+// that all queries are prepared.
+type queryRecorder struct {
+	driver.Driver
+
+	mu      sync.Mutex
+	queries []string
+}
+
+func (q *queryRecorder) Open(name string) (driver.Conn, error) {
+	conn, err := q.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return queryConn{Conn: conn, rec: q}, nil
+}
+
+type queryConn struct {
+	driver.Conn
+	rec *queryRecorder
+}
+
+func (c queryConn) Prepare(query string) (driver.Stmt, error) {
+	c.rec.mu.Lock()
+	c.rec.queries = append(c.rec.queries, query)
+	c.rec.mu.Unlock()
+	return c.Conn.Prepare(query)
+}
+
+// recordQueries registers a queryRecorder for the default driver, under the
+// name "sqlite-queries".
+var recordQueries = sync.OnceValue(func() *queryRecorder {
+	db, err := sql.Open("sqlite", "")
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	rec := &queryRecorder{Driver: db.Driver()}
+	sql.Register("sqlite-queries", rec)
+	return rec
+})
+
+// queryInt returns the integer result of a single-valued query on conn.
+func queryInt(t *testing.T, conn driver.Conn, query string) int64 {
+	t.Helper()
+	rows, err := conn.(driver.QueryerContext).QueryContext(context.Background(), query, nil)
+	if err != nil {
+		t.Fatalf("Query %q: %v", query, err)
+	}
+	defer rows.Close()
+	row := make([]driver.Value, 1)
+	if err := rows.Next(row); err != nil {
+		t.Fatalf("Query %q: %v", query, err)
+	}
+	v, ok := row[0].(int64)
+	if !ok {
+		t.Fatalf("Query %q: got %T, want int64", query, row[0])
+	}
+	return v
+}
+
+func TestForeignKeys(t *testing.T) {
+	rec := recordConns()
+	rec.mu.Lock()
+	rec.conns = nil
+	rec.mu.Unlock()
+
+	ctx := context.Background()
+	s, err := sqlitestore.New("file:"+filepath.Join(t.TempDir(), "fk.db"), &sqlitestore.Options{
+		Driver:       "sqlite-recorder",
+		PoolSize:     4,
+		MaxIdleConns: 4, // keep all the connections open for inspection
+		ForeignKeys:  true,
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close(ctx)
+	kv := mustKV(t, s, "test")
+	putAll(t, kv, testData)
+
+	const numReaders = 3
+	holdConns(ctx, kv, numReaders)
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	if len(rec.conns) < numReaders {
+		t.Fatalf("Opened %d connections, want at least %d", len(rec.conns), numReaders)
+	}
+	for i, conn := range rec.conns {
+		if v := queryInt(t, conn, `pragma foreign_keys`); v != 1 {
+			t.Errorf("Connection %d: foreign_keys = %d, want 1", i, v)
+		}
+	}
+}
+
+func TestWALAutoCheckpoint(t *testing.T) {
+	rec := recordConns()
+	ctx := context.Background()
+	for _, tc := range []struct {
+		opt  *int
+		want int64
+	}{{nil, 1000}, {value.Ptr(50), 50}, {value.Ptr(0), 0}, {value.Ptr(-1), 0}} {
+		rec.mu.Lock()
+		rec.conns = nil
+		rec.mu.Unlock()
+
+		path := filepath.Join(t.TempDir(), "test.db")
+		s, err := sqlitestore.New("file:"+path+"?_pragma=journal_mode(wal)", &sqlitestore.Options{
+			Driver:            "sqlite-recorder",
+			WALAutoCheckpoint: tc.opt,
+		})
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		defer s.Close(ctx)
+		putAll(t, mustKV(t, s, "test"), testData)
+
+		rec.mu.Lock()
+		for i, conn := range rec.conns {
+			if v := queryInt(t, conn, `pragma wal_autocheckpoint`); v != tc.want {
+				t.Errorf("WALAutoCheckpoint=%v: connection %d: wal_autocheckpoint = %d, want %d", value.AtMaybe(tc.opt), i, v, tc.want)
+			}
+		}
+		rec.mu.Unlock()
+		if tc.want != 0 {
+			continue
+		}
+
+		// With automatic checkpoints disabled, the log remains until it is
+		// checkpointed explicitly.
+		walSize := func() int64 {
+			fi, err := os.Stat(path + "-wal")
+			if err != nil {
+				t.Fatalf("Stat WAL: %v", err)
+			}
+			return fi.Size()
+		}
+		if n := walSize(); n == 0 {
+			t.Error("WAL is empty before checkpoint")
+		}
+		if err := s.Checkpoint(ctx, "bogus"); err == nil {
+			t.Error("Checkpoint bogus: got nil, want error")
+		}
+		if err := s.Checkpoint(ctx, "truncate"); err != nil {
+			t.Fatalf("Checkpoint failed: %v", err)
+		}
+		if n := walSize(); n != 0 {
+			t.Errorf("WAL size after checkpoint: got %d, want 0", n)
+		}
+	}
+}
+
+func TestCloseContext(t *testing.T) {
+	url := "file:" + filepath.Join(t.TempDir(), "close.db")
+	s, err := sqlitestore.New(url, nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	putAll(t, mustKV(t, s, "test"), testData)
+
+	// Closing with an expired context skips the vacuum, but still closes the
+	// database and reports the context error.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Close(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Close: got %v, want %v", err, context.Canceled)
+	}
+	if err := s.Ping(context.Background()); err == nil {
+		t.Error("Ping after Close: got nil, want error")
+	}
+
+	r, err := sqlitestore.New(url, nil)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer r.Close(context.Background())
+	checkContents(t, mustKV(t, r, "test"), testData)
+}
+
+func TestCloseTwice(t *testing.T) {
+	ctx := context.Background()
+	s, err := sqlitestore.New("file:"+filepath.Join(t.TempDir(), "twice.db"), &sqlitestore.Options{
+		MaintenanceInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	putAll(t, mustKV(t, s, "test"), testData)
+	sub, err := s.Sub(ctx, "sub")
+	if err != nil {
+		t.Fatalf("Sub failed: %v", err)
+	}
+
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("Close 1 failed: %v", err)
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Errorf("Close 2: got %v, want nil", err)
+	}
+	if err := sub.(sqlitestore.Store).Close(ctx); err != nil {
+		t.Errorf("Close sub: got %v, want nil", err)
+	}
+}
+
+func TestWithCache(t *testing.T) {
+	ctx := context.Background()
+	m := &opCounter{ops: make(map[string]int)}
+	s, _ := newTestStore(t, &sqlitestore.Options{Metrics: m})
+	c := sqlitestore.WithCache(mustKV(t, s, "test"), 16)
+
+	putAll(t, c, map[string]string{"a": "apple", "b": "blueberry", "c": "cranberry"})
+	get := func(key, want string) {
+		t.Helper()
+		if got, err := c.Get(ctx, key); err != nil || string(got) != want {
+			t.Errorf("Get %q: got (%q, %v), want %q", key, got, err, want)
+		}
+	}
+
+	// Repeated reads are served from the cache.
+	get("a", "apple")
+	get("a", "apple")
+	if n := m.count("get"); n != 1 {
+		t.Errorf("Store reads: got %d, want 1", n)
+	}
+
+	// Writes invalidate the cache.
+	if err := c.Put(ctx, blob.PutOptions{Key: "a", Data: []byte("apricot"), Replace: true}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	get("a", "apricot")
+	if err := c.Delete(ctx, "a"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := c.Get(ctx, "a"); !blob.IsKeyNotFound(err) {
+		t.Errorf("Get deleted: got %v, want %v", err, blob.ErrKeyNotFound)
+	}
+
+	// The cache holds at most 16 bytes, so reading b and c evicts b.
+	get("b", "blueberry")
+	get("c", "cranberry")
+	before := m.count("get")
+	get("c", "cranberry")
+	get("b", "blueberry")
+	if n := m.count("get") - before; n != 1 {
+		t.Errorf("Store reads after eviction: got %d, want 1", n)
+	}
+}
+
+func TestRecompress(t *testing.T) {
+	ctx := context.Background()
+	url := "file:" + filepath.Join(t.TempDir(), "recompress.db")
+	s, err := sqlitestore.New(url, &sqlitestore.Options{Dedup: true})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	want := maps.Clone(testData)
+	want["shared1"] = "a value stored by reference"
+	want["shared2"] = "a value stored by reference"
+	putAll(t, mustKV(t, s, "test"), want)
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Reopen with a different codec. Each value records its codec, so the
+	// values written with the old codec remain readable alongside new ones.
+	r, err := sqlitestore.New(url, &sqlitestore.Options{Dedup: true, Codec: sqlitestore.GzipCodec})
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer r.Close(ctx)
+	kv := mustKV(t, r, "test")
+	want["zzz"] = "a value written with the new codec"
+	putAll(t, kv, map[string]string{"zzz": want["zzz"]})
+	checkContents(t, kv, want)
+
+	// Rewrite the values in two steps, interrupting the first after one
+	// batch. The values are readable between the steps.
+	cctx, cancel := context.WithCancel(ctx)
+	n1, err := kv.RecompressWith(cctx, sqlitestore.GzipCodec, &sqlitestore.RecompressOptions{
+		BatchSize: 3,
+		Progress:  func(sqlitestore.Progress) { cancel() },
+	})
+	if err == nil || n1 != 3 {
+		t.Fatalf("Recompress interrupted: got (%d, %v), want (3, error)", n1, err)
+	}
+	checkContents(t, kv, want)
+
+	// Resuming skips the values already rewritten.
+	var last sqlitestore.Progress
+	n2, err := kv.RecompressWith(ctx, sqlitestore.GzipCodec, &sqlitestore.RecompressOptions{
+		Progress: func(p sqlitestore.Progress) { last = p },
+	})
+	if err != nil {
+		t.Fatalf("Recompress resumed: %v", err)
+	}
+	if n1+n2 != len(want)-1 {
+		t.Errorf("Recompress: rewrote %d values, want %d", n1+n2, len(want)-1)
+	}
+	nw := int64(len(want))
+	if wp := (sqlitestore.Progress{Processed: nw, Skipped: int64(n1) + 1, Total: nw}); last != wp {
+		t.Errorf("Progress: got %+v, want %+v", last, wp)
+	}
+	checkContents(t, kv, want)
+
+	// Once all the values are rewritten, there is nothing more to do.
+	if n, err := kv.Recompress(ctx, sqlitestore.GzipCodec); err != nil || n != 0 {
+		t.Errorf("Recompress again: got (%d, %v), want (0, nil)", n, err)
+	}
+	checkContents(t, kv, want)
+
+	// The values can be converted back, even though the store uses another
+	// codec, but only to codecs whose values can be identified.
+	if n, err := kv.Recompress(ctx, sqlitestore.SnappyCodec); err != nil || n != len(want) {
+		t.Errorf("Recompress to Snappy: got (%d, %v), want (%d, nil)", n, err, len(want))
+	}
+	checkContents(t, kv, want)
+	if n, err := kv.Recompress(ctx, xorCodec{}); err == nil {
+		t.Errorf("Recompress to a custom codec: got (%d, nil), want error", n)
+	}
+}
+
+func TestCompactEstimate(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, &sqlitestore.Options{Uncompressed: true})
+	kv := mustKV(t, s, "test")
+	for i := range 200 {
+		if err := kv.Put(ctx, blob.PutOptions{
+			Key:  fmt.Sprintf("key-%03d", i),
+			Data: bytes.Repeat([]byte("x"), 1000),
+		}); err != nil {
+			t.Fatalf("Put %d: %v", i, err)
+		}
+	}
+	before, err := s.CompactEstimate(ctx)
+	if err != nil {
+		t.Fatalf("CompactEstimate failed: %v", err)
+	}
+
+	// Deleting every other value frees some pages and leaves others partly
+	// empty, so the estimate should grow, and exceed the freelist alone.
+	for i := 0; i < 200; i += 2 {
+		if err := kv.Delete(ctx, fmt.Sprintf("key-%03d", i)); err != nil {
+			t.Fatalf("Delete %d: %v", i, err)
+		}
+	}
+	after, err := s.CompactEstimate(ctx)
+	if err != nil {
+		t.Fatalf("CompactEstimate failed: %v", err)
+	}
+	st, err := s.DatabaseStats(ctx)
+	if err != nil {
+		t.Fatalf("DatabaseStats failed: %v", err)
+	}
+	if after <= before || after <= st.Reclaimable {
+		t.Errorf("CompactEstimate: got %d, want more than %d and %d", after, before, st.Reclaimable)
+	}
+}
+
+func TestConnPoolOptions(t *testing.T) {
+	rec := recordConns()
+	numConns := func() int {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		return len(rec.conns)
+	}
+	ctx := context.Background()
+
+	// countOpens reports how many connections are opened by n reads on a
+	// store with the given options, pausing before each read.
+	countOpens := func(t *testing.T, opts sqlitestore.Options, n int, pause time.Duration) int {
+		t.Helper()
+		opts.Driver, opts.PoolSize = "sqlite-recorder", 1
+		s, err := sqlitestore.New("file:"+filepath.Join(t.TempDir(), "pool.db"), &opts)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		defer s.Close(ctx)
+		kv := mustKV(t, s, "test")
+		putAll(t, kv, testData)
+
+		before := numConns()
+		for range n {
+			time.Sleep(pause)
+			if _, err := kv.Get(ctx, "apple"); err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+		}
+		return numConns() - before
+	}
+
+	if n := countOpens(t, sqlitestore.Options{}, 5, 5*time.Millisecond); n != 0 {
+		t.Errorf("Default: opened %d connections, want 0", n)
+	}
+	if n := countOpens(t, sqlitestore.Options{MaxIdleConns: -1}, 5, 0); n < 5 {
+		t.Errorf("MaxIdleConns: opened %d connections, want at least 5", n)
+	}
+	if n := countOpens(t, sqlitestore.Options{ConnMaxLifetime: time.Millisecond}, 5, 5*time.Millisecond); n < 5 {
+		t.Errorf("ConnMaxLifetime: opened %d connections, want at least 5", n)
+	}
+
+	// Idle connections are closed in the background, at most once a second.
+	if n := countOpens(t, sqlitestore.Options{ConnMaxIdleTime: time.Millisecond}, 1, 1500*time.Millisecond); n < 1 {
+		t.Errorf("ConnMaxIdleTime: opened %d connections, want at least 1", n)
+	}
+}
+
+func TestWarmPool(t *testing.T) {
+	rec := recordConns()
+	numConns := func() int {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		return len(rec.conns)
+	}
+	rec.mu.Lock()
+	rec.conns = nil
+	rec.mu.Unlock()
+
+	ctx := context.Background()
+	const poolSize = 3
+	s, err := sqlitestore.New("file:"+filepath.Join(t.TempDir(), "warm.db"), &sqlitestore.Options{
+		Driver:   "sqlite-recorder",
+		PoolSize: poolSize,
+		WarmPool: true,
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close(ctx)
+	if n := numConns(); n != poolSize {
+		t.Errorf("After New: opened %d connections, want %d", n, poolSize)
+	}
+
+	// The warmed connections are retained, so ordinary use does not open
+	// any more of them.
+	kv := mustKV(t, s, "test")
+	putAll(t, kv, testData)
+	for key := range testData {
+		if _, err := kv.Get(ctx, key); err != nil {
+			t.Errorf("Get %q: %v", key, err)
+		}
+	}
+	if n := numConns(); n != poolSize {
+		t.Errorf("After use: opened %d connections, want %d", n, poolSize)
+	}
+}
+
+func TestNoCreate(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// A database whose schema has not been provisioned cannot be opened.
+	if s, err := sqlitestore.New("file:"+filepath.Join(dir, "empty.db"), &sqlitestore.Options{NoCreate: true}); err == nil {
+		s.Close(ctx)
+		t.Fatal("New on an empty database: got nil error")
+	} else if !errors.Is(err, sqlitestore.ErrMissingSchema) {
+		t.Errorf("New on an empty database: got %v, want %v", err, sqlitestore.ErrMissingSchema)
+	}
+
+	// Provision the schema for one KV.
+	url := "file:" + filepath.Join(dir, "test.db")
+	s, err := sqlitestore.New(url, &sqlitestore.Options{CoveringIndex: true})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	putAll(t, mustKV(t, s, "test"), testData)
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	rec := recordQueries()
+	rec.mu.Lock()
+	rec.queries = nil
+	rec.mu.Unlock()
+
+	s, err = sqlitestore.New(url, &sqlitestore.Options{
+		Driver:        "sqlite-queries",
+		NoCreate:      true,
+		CoveringIndex: true,
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close(ctx)
+	kv := mustKV(t, s, "test")
+	checkContents(t, kv, testData)
+	if _, err := s.KV(ctx, "other"); !errors.Is(err, sqlitestore.ErrMissingSchema) {
+		t.Errorf("KV other: got %v, want %v", err, sqlitestore.ErrMissingSchema)
+	}
+
+	rec.mu.Lock()
+	queries := slices.Clone(rec.queries)
+	rec.mu.Unlock()
+	for _, q := range queries {
+		if f := strings.Fields(strings.ToLower(q)); len(f) != 0 && (f[0] == "create" || f[0] == "alter") {
+			t.Errorf("Unexpected schema change: %s", q)
+		}
+	}
+}
+
+func TestKVClose(t *testing.T) {
+	rec := recordQueries()
+	ctx := context.Background()
+	s, err := sqlitestore.New("file:"+filepath.Join(t.TempDir(), "close.db"), &sqlitestore.Options{
+		Driver:   "sqlite-queries",
+		PoolSize: 1, // so that cached statements are prepared on one connection
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close(ctx)
+	kv, err := s.KV(ctx, "test")
+	if err != nil {
+		t.Fatalf("KV failed: %v", err)
+	}
+	putAll(t, kv, testData)
+
+	// getPrepares returns the number of statements prepared by a Get.
+	getPrepares := func() int {
+		t.Helper()
+		rec.mu.Lock()
+		rec.queries = nil
+		rec.mu.Unlock()
+		if _, err := kv.Get(ctx, "apple"); err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		return len(rec.queries)
+	}
+	getPrepares() // prime the cache
+	if n := getPrepares(); n != 0 {
+		t.Errorf("Get with cached statements: prepared %d, want 0", n)
+	}
+
+	// Close releases the cached statements, but the KV remains usable.
+	c, ok := kv.(blob.Closer)
+	if !ok {
+		t.Fatalf("KV %T does not implement blob.Closer", kv)
+	}
+	if err := c.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if n := getPrepares(); n == 0 {
+		t.Error("Get after Close: prepared no statements, want some")
+	}
+	if n := getPrepares(); n != 0 {
+		t.Errorf("Get after re-preparing: prepared %d, want 0", n)
+	}
+	checkContents(t, kv, testData)
+}
+
+func TestOnChange(t *testing.T) {
+	ctx := context.Background()
+	var kv sqlitestore.KV
+	var got []string
+	s, _ := newTestStore(t, &sqlitestore.Options{
+		PoolSize: 1,
+		OnChange: func(op, key string) {
+			// The hook runs outside the store's locks, so it may use the store.
+			_, err := kv.Contains(ctx, key)
+			got = append(got, fmt.Sprintf("%s %s %v", op, key, err))
+		},
+	})
+	kv = mustKV(t, s, "test")
+
+	if err := kv.Put(ctx, blob.PutOptions{Key: "a", Data: []byte("1")}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := kv.Put(ctx, blob.PutOptions{Key: "a", Data: []byte("2")}); !blob.IsKeyExists(err) {
+		t.Fatalf("Put existing: got %v, want key exists", err)
+	}
+	if err := kv.Put(ctx, blob.PutOptions{Key: "a", Data: []byte("3"), Replace: true}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if err := kv.Delete(ctx, "a"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := kv.Delete(ctx, "a"); !blob.IsKeyNotFound(err) {
+		t.Fatalf("Delete missing: got %v, want key not found", err)
+	}
+
+	want := []string{"put a <nil>", "put a <nil>", "delete a <nil>"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Changes: got %q, want %q", got, want)
+	}
+}
+
+func TestOnChangeWrites(t *testing.T) {
+	ctx := context.Background()
+	var got []string
+	opts := &sqlitestore.Options{OnChange: func(op, key string) { got = append(got, op+" "+key) }}
+	s, _ := newTestStore(t, opts)
+	kv := mustKV(t, s, "test")
+	check := func(label string, want ...string) {
+		t.Helper()
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: got %q, want %q", label, got, want)
+		}
+		got = nil
+	}
+
+	if _, err := kv.PutIfAbsent(ctx, "a", []byte("1")); err != nil {
+		t.Fatalf("PutIfAbsent failed: %v", err)
+	}
+	if _, err := kv.PutIfAbsent(ctx, "a", []byte("2")); err != nil {
+		t.Fatalf("PutIfAbsent failed: %v", err)
+	}
+	check("PutIfAbsent", "put a")
+
+	if _, _, err := kv.ReplaceGet(ctx, "b", []byte("2")); err != nil {
+		t.Fatalf("ReplaceGet failed: %v", err)
+	}
+	check("ReplaceGet", "put b")
+
+	if _, err := kv.CompareAndSwap(ctx, "c", nil, []byte("3")); err != nil {
+		t.Fatalf("CompareAndSwap failed: %v", err)
+	}
+	if _, err := kv.CompareAndSwap(ctx, "c", []byte("x"), []byte("4")); err != nil {
+		t.Fatalf("CompareAndSwap failed: %v", err)
+	}
+	check("CompareAndSwap", "put c")
+
+	if err := kv.Append(ctx, "a", []byte("1")); err != nil {
+		t.Fatalf("Append failed: %v", err)
+	}
+	check("Append", "put a")
+
+	raw, codec, err := kv.GetRaw(ctx, "a")
+	if err != nil {
+		t.Fatalf("GetRaw failed: %v", err)
+	}
+	if err := kv.PutRaw(ctx, blob.PutOptions{Key: "e", Data: raw}, codec); err != nil {
+		t.Fatalf("PutRaw failed: %v", err)
+	}
+	check("PutRaw", "put e")
+
+	if err := kv.BatchPut(ctx, []blob.PutOptions{{Key: "f"}, {Key: "g"}}); err != nil {
+		t.Fatalf("BatchPut failed: %v", err)
+	}
+	check("BatchPut", "put f", "put g")
+	if n, err := kv.BatchDelete(ctx, "f", "g", "nonesuch"); err != nil || n != 2 {
+		t.Fatalf("BatchDelete: got (%d, %v), want 2", n, err)
+	}
+	check("BatchDelete", "delete f", "delete g")
+
+	buf := kv.Buffered(&sqlitestore.BufferOptions{Interval: time.Hour})
+	putAll(t, buf, map[string]string{"h": "8"})
+	check("Buffered Put")
+	if err := buf.Close(ctx); err != nil {
+		t.Fatalf("Close buffer failed: %v", err)
+	}
+	check("Buffered Close", "put h")
+
+	if err := kv.WithTx(ctx, func(tx *sqlitestore.KVTx) error {
+		if err := tx.Put(ctx, blob.PutOptions{Key: "i"}); err != nil {
+			return err
+		}
+		return tx.Delete(ctx, "h")
+	}); err != nil {
+		t.Fatalf("WithTx failed: %v", err)
+	}
+	errFail := errors.New("fail")
+	if err := kv.WithTx(ctx, func(tx *sqlitestore.KVTx) error {
+		if err := tx.Put(ctx, blob.PutOptions{Key: "j"}); err != nil {
+			return err
+		}
+		return errFail
+	}); !errors.Is(err, errFail) {
+		t.Fatalf("WithTx: got %v, want %v", err, errFail)
+	}
+	check("WithTx", "put i", "delete h")
+
+	if err := kv.PutTTL(ctx, blob.PutOptions{Key: "k"}, time.Millisecond); err != nil {
+		t.Fatalf("PutTTL failed: %v", err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := kv.PurgeExpired(ctx); err != nil {
+		t.Fatalf("PurgeExpired failed: %v", err)
+	}
+	check("PurgeExpired", "put k", "delete k")
+
+	if err := kv.Move(ctx, "i", "m", false); err != nil {
+		t.Fatalf("Move failed: %v", err)
+	}
+	if err := kv.Move(ctx, "m", "m", false); err != nil {
+		t.Fatalf("Move to self failed: %v", err)
+	}
+	if err := kv.Move(ctx, "nonesuch", "m", true); !blob.IsKeyNotFound(err) {
+		t.Fatalf("Move missing: got %v, want %v", err, blob.ErrKeyNotFound)
+	}
+	check("Move", "delete i", "put m")
+
+	// Eviction reports the keys it removes, after the write that caused it.
+	opts.MaxKeys = 1
+	e, _ := newTestStore(t, opts)
+	ekv := mustKV(t, e, "test")
+	putAll(t, ekv, map[string]string{"x": "1"})
+	putAll(t, ekv, map[string]string{"y": "2"})
+	check("Eviction", "put x", "put y", "delete x")
+}
+
+func TestMove(t *testing.T) {
+	ctx := context.Background()
+	for _, opts := range []*sqlitestore.Options{
+		{FastLen: true},
+		{FastLen: true, Dedup: true},
+		{FastLen: true, KeyEncoding: sqlitestore.TextKeys, AllowBinaryKeys: true},
+	} {
+		s, _ := newTestStore(t, opts)
+		kv := mustKV(t, s, "test")
+		putAll(t, kv, testData)
+		want := maps.Clone(testData)
+
+		if err := kv.Move(ctx, "apple", "apricot", false); err != nil {
+			t.Fatalf("Move [%+v] failed: %v", opts, err)
+		}
+		want["apricot"] = want["apple"]
+		delete(want, "apple")
+		checkContents(t, kv, want)
+
+		if err := kv.Move(ctx, "nonesuch", "other", true); !blob.IsKeyNotFound(err) {
+			t.Errorf("Move missing [%+v]: got %v, want key not found", opts, err)
+		}
+		if err := kv.Move(ctx, "apricot", "banana", false); !blob.IsKeyExists(err) {
+			t.Errorf("Move existing [%+v]: got %v, want key exists", opts, err)
+		}
+		if err := kv.Move(ctx, "banana", "banana", false); err != nil {
+			t.Errorf("Move to self [%+v]: %v", opts, err)
+		}
+		if err := kv.Move(ctx, "apricot", "banana", true); err != nil {
+			t.Fatalf("Move replace [%+v] failed: %v", opts, err)
+		}
+		want["banana"] = want["apricot"]
+		delete(want, "apricot")
+		checkContents(t, kv, want)
+		if n, err := kv.Len(ctx); err != nil || n != int64(len(want)) {
+			t.Errorf("Len [%+v]: got %d, %v; want %d", opts, n, err, len(want))
+		}
+
+		// An expired key does not prevent a move, and cannot be moved.
+		if err := kv.PutTTL(ctx, blob.PutOptions{Key: "gone", Data: []byte("x")}, time.Nanosecond); err != nil {
+			t.Fatalf("PutTTL failed: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+		if err := kv.Move(ctx, "gone", "other", false); !blob.IsKeyNotFound(err) {
+			t.Errorf("Move expired [%+v]: got %v, want key not found", opts, err)
+		}
+		if err := kv.Move(ctx, "banana", "gone", false); err != nil {
+			t.Errorf("Move onto expired [%+v]: %v", opts, err)
+		}
+		want["gone"] = want["banana"]
+		delete(want, "banana")
+		checkContents(t, kv, want)
+		if n, err := kv.Len(ctx); err != nil || n != int64(len(want)) {
+			t.Errorf("Len [%+v]: got %d, %v; want %d", opts, n, err, len(want))
+		}
+	}
+}
+
+func TestGetOr(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, nil)
+	kv := mustKV(t, s, "test")
+	putAll(t, kv, testData)
+
+	dflt := []byte("default")
+	for key, want := range testData {
+		if got, err := kv.GetOr(ctx, key, dflt); err != nil || string(got) != want {
+			t.Errorf("GetOr %q: got %q, %v; want %q", key, got, err, want)
+		}
+	}
+	if got, err := kv.GetOr(ctx, "nonesuch", dflt); err != nil || !bytes.Equal(got, dflt) {
+		t.Errorf("GetOr missing: got %q, %v; want %q", got, err, dflt)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if got, err := kv.GetOr(cctx, "nonesuch", dflt); err == nil {
+		t.Errorf("GetOr canceled: got %q, want error", got)
+	}
+}
+
+func TestPage(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name string
+		opts *sqlitestore.Options
+	}{
+		{"Hex", nil},
+		{"Text", &sqlitestore.Options{KeyEncoding: sqlitestore.TextKeys, AllowBinaryKeys: true}},
+		{"KeyCodec", &sqlitestore.Options{KeyCodec: hashKeys{}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _ := newTestStore(t, tc.opts)
+			kv := mustKV(t, s, "test")
+			putAll(t, kv, testData)
+			want, err := kv.Keys(ctx, "")
+			if err != nil {
+				t.Fatalf("Keys failed: %v", err)
+			}
+
+			for _, limit := range []int{1, 2, len(want) - 1, len(want), len(want) + 1} {
+				var got []string
+				var cursor string
+				for pages := 0; ; pages++ {
+					if pages > len(want) {
+						t.Fatalf("Page (limit %d): too many pages", limit)
+					}
+					keys, next, err := kv.Page(ctx, cursor, limit)
+					if err != nil {
+						t.Fatalf("Page (limit %d) failed: %v", limit, err)
+					}
+					if len(keys) > limit {
+						t.Errorf("Page (limit %d): got %d keys", limit, len(keys))
+					}
+					got = append(got, keys...)
+					if next == "" {
+						break
+					}
+					cursor = next
+				}
+				if !slices.Equal(got, want) {
+					t.Errorf("Pages (limit %d): got %q, want %q", limit, got, want)
+				}
+			}
+		})
+	}
+
+	s, _ := newTestStore(t, nil)
+	kv := mustKV(t, s, "test")
+	putAll(t, kv, testData)
+	if _, _, err := kv.Page(ctx, "", 0); err == nil {
+		t.Error("Page(limit 0): got nil error")
+	}
+	if _, _, err := kv.Page(ctx, "!!!", 5); err == nil {
+		t.Error("Page(bad cursor): got nil error")
+	}
+
+	// Deleting the key that ends a page does not affect the next page.
+	first, next, err := kv.Page(ctx, "", 2)
+	if err != nil {
+		t.Fatalf("Page failed: %v", err)
+	}
+	rest, _, err := kv.Page(ctx, next, len(testData))
+	if err != nil {
+		t.Fatalf("Page failed: %v", err)
+	}
+	if err := kv.Delete(ctx, first[len(first)-1]); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if got, _, err := kv.Page(ctx, next, len(testData)); err != nil {
+		t.Fatalf("Page failed: %v", err)
+	} else if !slices.Equal(got, rest) {
+		t.Errorf("Page after delete: got %q, want %q", got, rest)
+	}
+}
+
+func TestOpenRetry(t *testing.T) {
+	ctx := context.Background()
+	s, url := newTestStore(t, nil)
+	s.Close(ctx)
+
+	// Hold an exclusive lock on the database from another handle, so that
+	// opening the store finds it locked.
+	lock := func() *sql.Conn {
+		t.Helper()
+		conn, err := openRaw(t, url).Conn(ctx)
+		if err != nil {
+			t.Fatalf("Conn failed: %v", err)
+		}
+		if _, err := conn.ExecContext(ctx, `begin exclusive`); err != nil {
+			t.Fatalf("Lock failed: %v", err)
+		}
+		return conn
+	}
+	unlock := func(conn *sql.Conn) {
+		if _, err := conn.ExecContext(ctx, `rollback`); err != nil {
+			t.Errorf("Unlock failed: %v", err)
+		}
+		conn.Close()
+	}
+	opts := &sqlitestore.Options{BusyTimeout: -1, BusyRetries: -1}
+
+	t.Run("NoRetry", func(t *testing.T) {
+		conn := lock()
+		defer unlock(conn)
+		if s, err := sqlitestore.New(url, opts); err == nil {
+			s.Close(ctx)
+			t.Fatal("New succeeded on a locked database")
+		}
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		conn := lock()
+		time.AfterFunc(100*time.Millisecond, func() { unlock(conn) })
+		ropts := *opts
+		ropts.OpenRetryTimeout = 10 * time.Second
+		s, err := sqlitestore.New(url, &ropts)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		s.Close(ctx)
+	})
+
+	t.Run("Deadline", func(t *testing.T) {
+		conn := lock()
+		defer unlock(conn)
+		ropts := *opts
+		ropts.OpenRetryTimeout = 10 * time.Second
+		tctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		if s, err := sqlitestore.NewContext(tctx, url, &ropts); err == nil {
+			s.Close(ctx)
+			t.Fatal("NewContext succeeded on a locked database")
+		}
+	})
+
+	t.Run("Permanent", func(t *testing.T) {
+		start := time.Now()
+		s, err := sqlitestore.New("file::memory:", &sqlitestore.Options{
+			JournalMode:      "wal",
+			OpenRetryTimeout: 10 * time.Second,
+		})
+		if err == nil {
+			s.Close(ctx)
+			t.Fatal("New succeeded with an unsupported journal mode")
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("New failed after %v, want no retries", elapsed)
+		}
+	})
+}
+
+func TestEncodeBuffers(t *testing.T) {
+	ctx := context.Background()
+	s, err := sqlitestore.New("file:"+filepath.Join(t.TempDir(), "buf.db"), nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close(ctx)
+	kv := mustKV(t, s, "test")
+
+	// Values of decreasing size reuse the encoding buffer of their
+	// predecessors, and must not see any of their contents.
+	want := make(map[string][]byte)
+	for i, size := range []int{100 << 10, 4 << 10, 10, 0} {
+		key := fmt.Sprintf("key-%d", i)
+		want[key] = bytes.Repeat([]byte{byte('a' + i)}, size)
+		if err := kv.Put(ctx, blob.PutOptions{Key: key, Data: want[key]}); err != nil {
+			t.Fatalf("Put %q: %v", key, err)
+		}
+	}
+	for key, value := range want {
+		got, err := kv.Get(ctx, key)
+		if err != nil {
+			t.Errorf("Get %q: %v", key, err)
+		} else if !bytes.Equal(got, value) {
+			t.Errorf("Get %q: got %d bytes, want %d", key, len(got), len(value))
+		}
+	}
+}
+
+func TestHexKeys(t *testing.T) {
+	ctx := context.Background()
+	s, err := sqlitestore.New("file:"+filepath.Join(t.TempDir(), "hex.db"), nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close(ctx)
+	kv := mustKV(t, s, "test")
+
+	// Keys with every byte value, both short and too long to encode on the
+	// stack, round-trip through the hex encoding.
+	var all []byte
+	for i := range 256 {
+		all = append(all, byte(i))
+	}
+	want := []string{"", "\x00", "\xff", string(all[:100]), string(all), strings.Repeat("k", 1000)}
+	for _, key := range want {
+		if err := kv.Put(ctx, blob.PutOptions{Key: key, Data: []byte(key)}); err != nil {
+			t.Fatalf("Put %q: %v", key, err)
+		}
+	}
+	slices.Sort(want)
+	var got []string
+	if err := kv.List(ctx, "", func(key string) error {
+		got = append(got, key)
+		return nil
+	}); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("List: got %q, want %q", got, want)
+	}
+	for _, key := range want {
+		if data, err := kv.Get(ctx, key); err != nil || string(data) != key {
+			t.Errorf("Get %q: got %q, %v; want %q, nil", key, data, err, key)
+		}
+	}
+}
+
+func TestBatchGet(t *testing.T) {
+	ctx := context.Background()
+	s, err := sqlitestore.New("file:"+filepath.Join(t.TempDir(), "batch.db"), &sqlitestore.Options{PoolSize: 4})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close(ctx)
+	kv := mustKV(t, s, "test")
+
+	want := make(map[string][]byte)
+	keys := []string{"nonesuch"}
+	for i := range 50 {
+		key := fmt.Sprintf("key-%d", i)
+		want[key] = []byte(fmt.Sprintf("value-%d", i))
+		keys = append(keys, key)
+		if err := kv.Put(ctx, blob.PutOptions{Key: key, Data: want[key]}); err != nil {
+			t.Fatalf("Put %q: %v", key, err)
+		}
+	}
+	keys = append(keys, "key-1", "also-missing") // duplicates are harmless
+
+	got, err := kv.BatchGet(ctx, keys...)
+	if err != nil {
+		t.Fatalf("BatchGet failed: %v", err)
+	}
+	if len(got) != len(want) {
+		t.Errorf("BatchGet: got %d values, want %d", len(got), len(want))
+	}
+	for key, value := range want {
+		if !bytes.Equal(got[key], value) {
+			t.Errorf("BatchGet %q: got %q, want %q", key, got[key], value)
+		}
+	}
+
+	if got, err := kv.BatchGet(ctx); err != nil || len(got) != 0 {
+		t.Errorf("BatchGet(): got %v, %v; want empty, nil", got, err)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := kv.BatchGet(cctx, keys...); !errors.Is(err, context.Canceled) {
+		t.Errorf("BatchGet (canceled): got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestRawValues(t *testing.T) {
+	ctx := context.Background()
+	src, _ := newTestStore(t, nil)
+	skv := mustKV(t, src, "test")
+	putAll(t, skv, testData)
+	long := strings.Repeat("compressible ", 100)
+	if err := skv.Put(ctx, blob.PutOptions{Key: "long", Data: []byte(long)}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	raw, codec, err := skv.GetRaw(ctx, "long")
+	if err != nil {
+		t.Fatalf("GetRaw failed: %v", err)
+	} else if len(raw) >= len(long) {
+		t.Errorf("GetRaw: got %d bytes, want compressed (< %d)", len(raw), len(long))
+	} else if codec != sqlitestore.CodecSnappy {
+		t.Errorf("GetRaw: got codec %d, want %d", codec, sqlitestore.CodecSnappy)
+	}
+	if _, _, err := skv.GetRaw(ctx, "nonesuch"); !blob.IsKeyNotFound(err) {
+		t.Errorf("GetRaw(nonesuch): got %v, want %v", err, blob.ErrKeyNotFound)
+	}
+
+	// Copy all the values without re-encoding them, to a store with options
+	// that depend on the decoded value.
+	dst, _ := newTestStore(t, &sqlitestore.Options{Verify: true, Dedup: true})
+	dkv := mustKV(t, dst, "test")
+	for key := range testData {
+		raw, codec, err := skv.GetRaw(ctx, key)
+		if err != nil {
+			t.Fatalf("GetRaw %q: %v", key, err)
+		}
+		if err := dkv.PutRaw(ctx, blob.PutOptions{Key: key, Data: raw}, codec); err != nil {
+			t.Fatalf("PutRaw %q: %v", key, err)
+		}
+	}
+	if err := dkv.PutRaw(ctx, blob.PutOptions{Key: "long", Data: raw}, codec); err != nil {
+		t.Fatalf("PutRaw long: %v", err)
+	}
+	for key, want := range testData {
+		if got, err := dkv.Get(ctx, key); err != nil || string(got) != want {
+			t.Errorf("Get %q: got %q, %v; want %q", key, got, err, want)
+		}
+	}
+	if st, err := dkv.Stat(ctx, "long"); err != nil || st["long"].Size != int64(len(long)) {
+		t.Errorf("Stat long: got %v, %v; want size %d", st, err, len(long))
+	}
+	if err := dkv.PutRaw(ctx, blob.PutOptions{Key: "long", Data: raw}, codec); !errors.Is(err, blob.ErrKeyExists) {
+		t.Errorf("PutRaw (exists): got %v, want %v", err, blob.ErrKeyExists)
+	}
+
+	// The codec is recorded with the value, so a store with another codec
+	// can read it, and reports it as stored.
+	enc, _ := newTestStore(t, &sqlitestore.Options{EncryptionKey: bytes.Repeat([]byte("k"), 32)})
+	ekv := mustKV(t, enc, "test")
+	if err := ekv.PutRaw(ctx, blob.PutOptions{Key: "long", Data: raw}, codec); err != nil {
+		t.Fatalf("PutRaw (other codec): %v", err)
+	}
+	if got, err := ekv.Get(ctx, "long"); err != nil || string(got) != long {
+		t.Errorf("Get long: got %d bytes, %v; want %d", len(got), err, len(long))
+	}
+	if st, err := ekv.Stat(ctx, "long"); err != nil || st["long"].Size != int64(len(long)) {
+		t.Errorf("Stat long: got %v, %v; want size %d", st, err, len(long))
+	}
+	if got, c, err := ekv.GetRaw(ctx, "long"); err != nil || !bytes.Equal(got, raw) || c != codec {
+		t.Errorf("GetRaw long: got %d bytes, codec %d, %v; want %d bytes, codec %d", len(got), c, err, len(raw), codec)
+	}
+	if err := ekv.Put(ctx, blob.PutOptions{Key: "mine", Data: []byte(long)}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, c, err := ekv.GetRaw(ctx, "mine"); err != nil || c != sqlitestore.CodecStore {
+		t.Errorf("GetRaw mine: got codec %d, %v; want %d", c, err, sqlitestore.CodecStore)
+	}
+
+	// An unknown codec is rejected.
+	if err := ekv.PutRaw(ctx, blob.PutOptions{Key: "bogus", Data: raw}, 99); err == nil {
+		t.Error("PutRaw (unknown codec): got nil error")
+	}
+
+	// A value that must be decoded, because the store verifies checksums or
+	// the codec does not record the size, is rejected if it is invalid.
+	junk := []byte("not a valid encoding")
+	if err := dkv.PutRaw(ctx, blob.PutOptions{Key: "junk", Data: junk}, sqlitestore.CodecSnappy); !errors.Is(err, sqlitestore.ErrCorruptValue) {
+		t.Errorf("PutRaw (verify): got %v, want %v", err, sqlitestore.ErrCorruptValue)
+	}
+	if err := skv.PutRaw(ctx, blob.PutOptions{Key: "junk", Data: junk}, sqlitestore.CodecGzip); !errors.Is(err, sqlitestore.ErrCorruptValue) {
+		t.Errorf("PutRaw (gzip): got %v, want %v", err, sqlitestore.ErrCorruptValue)
+	}
+	for _, kv := range []sqlitestore.KV{dkv, skv} {
+		if ok, err := kv.Contains(ctx, "junk"); err != nil || ok {
+			t.Errorf("Contains after rejected PutRaw: got %v, %v; want false", ok, err)
+		}
+	}
+
+	// Otherwise, the value is written as given, and reported when it is read.
+	bad := append(raw[:len(raw):len(raw)], 0xff)
+	if err := skv.PutRaw(ctx, blob.PutOptions{Key: "bad", Data: bad}, codec); err != nil {
+		t.Fatalf("PutRaw (unchecked): %v", err)
+	}
+	if _, err := skv.Get(ctx, "bad"); !errors.Is(err, sqlitestore.ErrCorruptValue) {
+		t.Errorf("Get bad: got %v, want %v", err, sqlitestore.ErrCorruptValue)
+	}
+}
+
+// paramRE matches the named parameters of a query.
+var paramRE = regexp.MustCompile(`\$(\w+)`)
+
+func TestIndexOnlyLookups(t *testing.T) {
+	rec := recordQueries()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "plan.db")
+	s, err := sqlitestore.New("file:"+path, &sqlitestore.Options{
+		Driver:        "sqlite-queries",
+		CoveringIndex: true,
+	})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer s.Close(ctx)
+	kv := mustKV(t, s, "test")
+	putAll(t, kv, testData)
+
+	rec.mu.Lock()
+	rec.queries = nil
+	rec.mu.Unlock()
+	if _, err := kv.Contains(ctx, "apple"); err != nil {
+		t.Fatalf("Contains failed: %v", err)
+	}
+	if _, err := kv.MissingKeys(ctx, "apple", "nonesuch"); err != nil {
+		t.Fatalf("MissingKeys failed: %v", err)
+	}
+	rec.mu.Lock()
+	queries := slices.Clone(rec.queries)
+	rec.mu.Unlock()
+
+	db, err := sql.Open("sqlite", "file:"+path)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer db.Close()
+	var checked int
+	for _, q := range queries {
+		checked++
+		var args []any
+		for _, m := range paramRE.FindAllStringSubmatch(q, -1) {
+			args = append(args, sql.Named(m[1], nil))
+		}
+		rows, err := db.QueryContext(ctx, "explain query plan "+q, args...)
+		if err != nil {
+			t.Fatalf("Explain %q: %v", q, err)
+		}
+		var plan []string
+		for rows.Next() {
+			var id, parent, unused int
+			var detail string
+			if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+				t.Fatalf("Scan plan: %v", err)
+			}
+			plan = append(plan, detail)
+		}
+		rows.Close()
+		// The lookup in the table (t) must be satisfied by the index alone.
+		var covered bool
+		for _, step := range plan {
+			if strings.HasPrefix(step, "SEARCH t ") {
+				covered = strings.Contains(step, "USING COVERING INDEX")
+				break
+			}
+		}
+		if !covered {
+			t.Errorf("Query does not use the covering index:\n%s\nPlan:\n%s", q, strings.Join(plan, "\n"))
+		}
+	}
+	if checked != 2 {
+		t.Errorf("Checked %d queries, want 2: %q", checked, queries)
+	}
+}
+
+func TestNewFS(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	s, err := sqlitestore.New("file:"+filepath.Join(dir, "ref.db"), nil)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	putAll(t, mustKV(t, s, "test"), testData)
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "ref.db"))
+	if err != nil {
+		t.Fatalf("Read database: %v", err)
+	}
+
+	dirFS := os.DirFS(dir)
+	for _, tc := range []struct {
+		fsys fs.FS
+		path string
+	}{
+		{fstest.MapFS{"data/ref.db": {Data: data}}, "data/ref.db"},
+		{dirFS, "ref.db"},
+		{dirFS, "ref.db"}, // the same file system again
+	} {
+		ro, err := sqlitestore.NewFS(tc.fsys, tc.path, nil)
+		if err != nil {
+			t.Fatalf("NewFS %q failed: %v", tc.path, err)
+		}
+		kv := mustKV(t, ro, "test")
+		checkContents(t, kv, testData)
+		if err := kv.Put(ctx, blob.PutOptions{Key: "new", Data: []byte("value")}); !errors.Is(err, sqlitestore.ErrReadOnly) {
+			t.Errorf("Put: got %v, want %v", err, sqlitestore.ErrReadOnly)
+		}
+		if err := kv.Delete(ctx, "apple"); !errors.Is(err, sqlitestore.ErrReadOnly) {
+			t.Errorf("Delete: got %v, want %v", err, sqlitestore.ErrReadOnly)
+		}
+		if err := ro.Close(ctx); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+	}
+
+	if _, err := sqlitestore.NewFS(dirFS, "../ref.db", nil); err == nil {
+		t.Error("NewFS with an invalid path: got nil error")
+	}
+	if _, err := sqlitestore.NewFS(dirFS, "nonesuch.db", nil); err == nil {
+		t.Error("NewFS with a missing file: got nil error")
+	}
+}
+
+func TestSetCompression(t *testing.T) {
+	ctx := context.Background()
+	opts := &sqlitestore.Options{Dedup: true}
+	s, url := newTestStore(t, opts)
+	media, text := mustKV(t, s, "media"), mustKV(t, s, "text")
+
+	// The same value in both tables shares content until the codec of one of
+	// them changes.
+	long := []byte(strings.Repeat("compressible ", 100))
+	for _, kv := range []sqlitestore.KV{media, text} {
+		if err := kv.Put(ctx, blob.PutOptions{Key: "long", Data: long}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	putAll(t, media, testData)
+
+	if err := media.SetCompression(ctx, "bogus"); err == nil {
+		t.Error("SetCompression(bogus): got nil error")
+	}
+	if err := media.SetCompression(ctx, "none"); err != nil {
+		t.Fatalf("SetCompression failed: %v", err)
+	}
+	if err := media.Put(ctx, blob.PutOptions{Key: "new", Data: long}); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	checkCodec := func(kv sqlitestore.KV, key string, compressed bool) {
+		t.Helper()
+		raw, _, err := kv.GetRaw(ctx, key)
+		if err != nil {
+			t.Fatalf("GetRaw %q: %v", key, err)
+		}
+		if got := !bytes.Equal(raw, long); got != compressed {
+			t.Errorf("GetRaw %q: compressed=%v, want %v", key, got, compressed)
+		}
+		if got, err := kv.Get(ctx, key); err != nil || !bytes.Equal(got, long) {
+			t.Errorf("Get %q: got %d bytes, %v; want %d", key, len(got), err, len(long))
+		}
+	}
+	// Values already stored keep their codec until they are recompressed.
+	checkCodec(media, "long", true)
+	checkCodec(media, "new", false)
+	checkCodec(text, "long", true)
+	if n, err := media.Recompress(ctx, sqlitestore.NoCodec); err != nil {
+		t.Fatalf("Recompress failed: %v", err)
+	} else if n != len(testData)+1 {
+		t.Errorf("Recompress: got %d, want %d", n, len(testData)+1)
+	}
+	checkCodec(media, "long", false)
+	checkCodec(text, "long", true)
+	want := maps.Clone(testData)
+	want["long"], want["new"] = string(long), string(long)
+	checkContents(t, mustKV(t, s, "media"), want)
+
+	// The choice is remembered, and follows a clone of the namespace.
+	root := dbkey.Prefix("")
+	if err := s.CloneNamespace(ctx, root.Keyspace("media"), root.Keyspace("copy")); err != nil {
+		t.Fatalf("CloneNamespace failed: %v", err)
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	s2, err := sqlitestore.New(url, opts)
+	if err != nil {
+		t.Fatalf("Reopen failed: %v", err)
+	}
+	defer s2.Close(ctx)
+	checkCodec(mustKV(t, s2, "media"), "long", false)
+	checkCodec(mustKV(t, s2, "copy"), "long", false)
+	checkCodec(mustKV(t, s2, "text"), "long", true)
+
+	enc, _ := newTestStore(t, &sqlitestore.Options{EncryptionKey: bytes.Repeat([]byte("k"), 32)})
+	if err := mustKV(t, enc, "test").SetCompression(ctx, "none"); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("SetCompression (encrypted): got %v, want %v", err, errors.ErrUnsupported)
+	}
+
+	// An empty key stored as an empty blob keeps its codec like any other.
+	for _, dedup := range []bool{false, true} {
+		raw, _ := newTestStore(t, &sqlitestore.Options{KeyEncoding: sqlitestore.RawKeys, Dedup: dedup})
+		rkv := mustKV(t, raw, "test")
+		putAll(t, rkv, testData)
+		if err := rkv.SetCompression(ctx, "none"); err != nil {
+			t.Fatalf("SetCompression (RawKeys, dedup=%v) failed: %v", dedup, err)
+		}
+		if _, codec, err := rkv.GetRaw(ctx, ""); err != nil || codec != sqlitestore.CodecSnappy {
+			t.Errorf("GetRaw empty key (dedup=%v): got codec %d, %v; want %d", dedup, codec, err, sqlitestore.CodecSnappy)
+		}
+		checkContents(t, rkv, testData)
+	}
+}
+
+func TestNamespaceCodecs(t *testing.T) {
+	ctx := context.Background()
+	root := dbkey.Prefix("")
+	opts := &sqlitestore.Options{
+		NamespaceCodecs: map[dbkey.Prefix]string{root.Keyspace("media"): "NONE"},
+	}
+	s, url := newTestStore(t, opts)
+	long := []byte(strings.Repeat("compressible ", 100))
+	checkCodec := func(kv sqlitestore.KV, key string, want byte) {
+		t.Helper()
+		if _, codec, err := kv.GetRaw(ctx, key); err != nil || codec != want {
+			t.Errorf("GetRaw %q: got codec %d, %v; want %d", key, codec, err, want)
+		}
+	}
+	for _, name := range []string{"media", "text"} {
+		if err := mustKV(t, s, name).Put(ctx, blob.PutOptions{Key: "long", Data: long}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+	checkCodec(mustKV(t, s, "media"), "long", sqlitestore.CodecNone)
+	checkCodec(mustKV(t, s, "text"), "long", sqlitestore.CodecSnappy)
+
+	// The choice is recorded, and does not override a later choice.
+	if err := mustKV(t, s, "media").SetCompression(ctx, "gzip"); err != nil {
+		t.Fatalf("SetCompression failed: %v", err)
+	}
+	if err := s.Close(ctx); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	for _, o := range []*sqlitestore.Options{nil, opts} {
+		s2, err := sqlitestore.New(url, o)
+		if err != nil {
+			t.Fatalf("Reopen failed: %v", err)
+		}
+		media := mustKV(t, s2, "media")
+		if err := media.Put(ctx, blob.PutOptions{Key: "new", Data: long, Replace: true}); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+		checkCodec(media, "long", sqlitestore.CodecNone)
+		checkCodec(media, "new", sqlitestore.CodecGzip)
+		if err := s2.Close(ctx); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+	}
+
+	// Invalid choices are rejected.
+	if _, err := sqlitestore.New(url, &sqlitestore.Options{
+		NamespaceCodecs: map[dbkey.Prefix]string{root.Keyspace("media"): "bogus"},
+	}); err == nil {
+		t.Error("New (unknown codec): got nil error")
+	}
+	if _, err := sqlitestore.New(url, &sqlitestore.Options{
+		EncryptionKey:   bytes.Repeat([]byte("k"), 32),
+		NamespaceCodecs: opts.NamespaceCodecs,
+	}); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("New (encrypted): got %v, want %v", err, errors.ErrUnsupported)
+	}
+}
+
+func TestBatchDelete(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newTestStore(t, &sqlitestore.Options{Dedup: true, FastLen: true})
+	kv := mustKV(t, s, "test")
+
+	var keys []string
+	for i := range 1200 {
+		key := fmt.Sprintf("key-%04d", i)
+		keys = append(keys, key)
+		if err := kv.Put(ctx, blob.PutOptions{Key: key, Data: []byte{byte(i % 10)}}); err != nil {
+			t.Fatalf("Put %q: %v", key, err)
+		}
+	}
+	if err := kv.PutTTL(ctx, blob.PutOptions{Key: "expired", Data: []byte("x")}, time.Nanosecond); err != nil {
+		t.Fatalf("PutTTL failed: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+
+	// Delete all but the last key, along with keys that are missing, repeated,
+	// or expired, none of which are counted.
+	del := append(keys[:len(keys)-1:len(keys)-1], "nonesuch", keys[0], "expired")
+	n, err := kv.BatchDelete(ctx, del...)
+	if err != nil {
+		t.Fatalf("BatchDelete failed: %v", err)
+	} else if want := len(keys) - 1; n != want {
+		t.Errorf("BatchDelete: got %d, want %d", n, want)
+	}
+	if n, err := kv.Len(ctx); err != nil || n != 1 {
+		t.Errorf("Len: got %d, %v; want 1", n, err)
+	}
+	last := keys[len(keys)-1]
+	if got, err := kv.Get(ctx, last); err != nil || !bytes.Equal(got, []byte{9}) {
+		t.Errorf("Get %q: got %v, %v; want [9]", last, got, err)
+	}
+
+	// Released content can be stored again.
+	if err := kv.Put(ctx, blob.PutOptions{Key: keys[0], Data: []byte{0}}); err != nil {
+		t.Fatalf("Put after delete: %v", err)
+	}
+	if got, err := kv.Get(ctx, keys[0]); err != nil || !bytes.Equal(got, []byte{0}) {
+		t.Errorf("Get %q: got %v, %v; want [0]", keys[0], got, err)
+	}
+	if n, err := kv.BatchDelete(ctx); err != nil || n != 0 {
+		t.Errorf("BatchDelete(): got %d, %v; want 0, nil", n, err)
+	}
 }
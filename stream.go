@@ -0,0 +1,138 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/creachadair/ffs/blob"
+)
+
+// GetReader returns a reader for the value of key, along with its size in
+// bytes. If key is not present, GetReader reports [blob.ErrKeyNotFound].
+//
+// If the value is stored with [NoCodec], the reader fetches it from the
+// database in chunks as it is read, within a read transaction that is held
+// open until the reader is closed, so that it reads a consistent value. As
+// with [KV.Snapshot], the transaction has a connection of its own, added to
+// the connection pool while the reader is open, and it does not prevent
+// other operations on the store. If the database uses write-ahead logging,
+// writes proceed while the reader is open; otherwise, they fail as busy until
+// it is closed, so the caller should close the reader promptly. If the value
+// is stored with another codec, it is decoded fully into memory, and no
+// transaction is held open.
+func (s KV) GetReader(ctx context.Context, key string) (_ io.ReadCloser, _ int64, err error) {
+	s.db.txmu.RLock()
+	defer s.db.txmu.RUnlock()
+
+	growPool(s.db.db, 1)
+	tx, err := s.db.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		growPool(s.db.db, -1)
+		return nil, 0, fmt.Errorf("get: %w", err)
+	}
+	vr := &valueReader{ctx: ctx, s: s, tx: tx, key: key, crc: crc32.New(crcTable)}
+	defer func() {
+		if err != nil {
+			vr.Close()
+		}
+	}()
+
+	var codec byte
+	query := fmt.Sprintf(`select vsize, checksum, %s from %s as t where key = $key and %s`, rowCodec, s.table(), liveRow("t"))
+	if err := tx.QueryRowContext(ctx, query, sql.Named("key", s.encodeKey(key)), nowArg()).Scan(&vr.size, &vr.sum, &codec); errors.Is(err, sql.ErrNoRows) {
+		return nil, 0, blob.KeyNotFound(key)
+	} else if err != nil {
+		return nil, 0, fmt.Errorf("get: %w", err)
+	}
+	if codec != CodecNone {
+		// The value must be decoded as a whole.
+		data, err := s.getTx(ctx, tx, key)
+		if err != nil {
+			return nil, 0, err
+		}
+		vr.Close()
+		return io.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+	}
+	return vr, vr.size, nil
+}
+
+// valueReaderChunk is the maximum number of bytes a valueReader fetches from
+// the database at once.
+const valueReaderChunk = 1 << 20
+
+// A valueReader implements [io.ReadCloser] for a value stored without
+// encoding, by reading ranges of the value within a transaction.
+type valueReader struct {
+	ctx  context.Context
+	s    KV
+	tx   *sql.Tx // nil after close
+	key  string
+	size int64
+	sum  sql.NullInt64
+	crc  hash.Hash32
+
+	off int64  // offset of the next unread byte of the value
+	buf []byte // unread bytes fetched from the database
+}
+
+// Read implements the [io.Reader] interface.
+func (v *valueReader) Read(data []byte) (int, error) {
+	if v.tx == nil {
+		return 0, errors.New("read: reader is closed")
+	}
+	if len(v.buf) == 0 {
+		if v.off >= v.size {
+			if v.s.db.verify && v.sum.Valid && uint32(v.sum.Int64) != v.crc.Sum32() {
+				return 0, &blob.KeyError{Key: v.key, Err: fmt.Errorf("%w: checksum mismatch", ErrCorruptValue)}
+			}
+			return 0, io.EOF
+		}
+		if err := v.fetch(); err != nil {
+			return 0, err
+		}
+	}
+	nr := copy(data, v.buf)
+	v.crc.Write(v.buf[:nr])
+	v.buf = v.buf[nr:]
+	return nr, nil
+}
+
+// fetch reads the next chunk of the value into v.buf.
+func (v *valueReader) fetch() error {
+	query := fmt.Sprintf(`select substr(coalesce(c.value, t.value), $pos, $len) from %s as t
+  left join %s as c on c.hash = t.ref
+  where t.key = $key`, v.s.table(), v.s.db.contentIdent())
+	var chunk []byte
+	if err := v.tx.QueryRowContext(v.ctx, query,
+		sql.Named("pos", v.off+1), // SQLite offsets are 1-based
+		sql.Named("len", min(valueReaderChunk, v.size-v.off)),
+		sql.Named("key", v.s.encodeKey(v.key)),
+	).Scan(&chunk); err != nil {
+		return fmt.Errorf("read: %w", err)
+	} else if len(chunk) == 0 {
+		return fmt.Errorf("read: %w", io.ErrUnexpectedEOF)
+	}
+	v.off += int64(len(chunk))
+	v.buf = chunk
+	return nil
+}
+
+// Close implements the [io.Closer] interface. It ends the read transaction
+// held by v. Close is safe to call more than once.
+func (v *valueReader) Close() error {
+	if v.tx == nil {
+		return nil
+	}
+	err := v.tx.Rollback() // the transaction is read-only
+	v.tx = nil
+	growPool(v.s.db.db, -1)
+	return err
+}
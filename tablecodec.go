@@ -0,0 +1,131 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/creachadair/mds/value"
+)
+
+// A tableCodec is the codec of a table that has its own, along with its name
+// as recorded in the schema table.
+type tableCodec struct {
+	name  string
+	codec Codec
+}
+
+// namedCodecs are the codecs that can be selected for a table by name.
+var namedCodecs = map[string]Codec{"snappy": SnappyCodec, "gzip": GzipCodec, "none": NoCodec}
+
+// codec returns the codec of s. The caller must hold s.db.txmu.
+func (s KV) codec() Codec {
+	if tc, ok := s.db.codecs[s.tableName]; ok {
+		return tc.codec
+	}
+	return s.db.codec
+}
+
+// codecID returns the ID of the codec of s. The caller must hold s.db.txmu.
+func (s KV) codecID() byte {
+	if tc, ok := s.db.codecs[s.tableName]; ok {
+		id, _ := builtinCodecID(tc.codec)
+		return id
+	}
+	return s.db.codecID
+}
+
+// loadCodec records the codec of table from the schema table within tx, if
+// the table has its own. The caller must hold d.txmu exclusively.
+func (d *sqlDB) loadCodec(ctx context.Context, tx *sql.Tx, table string) error {
+	tc, ok, err := d.readCodec(ctx, tx, table)
+	if err != nil {
+		return err
+	} else if !ok {
+		delete(d.codecs, table)
+		return nil
+	}
+	d.codecs[table] = tc
+	return nil
+}
+
+// readCodec reads the codec of table from the schema table within tx, and
+// reports whether the table has its own.
+func (d *sqlDB) readCodec(ctx context.Context, tx *sql.Tx, table string) (tableCodec, bool, error) {
+	var name sql.NullString
+	err := tx.QueryRowContext(ctx, fmt.Sprintf(`select codec from %s where name = $name`, quoteIdent(d.schemaTable())),
+		sql.Named("name", table)).Scan(&name)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !name.Valid) {
+		return tableCodec{}, false, nil
+	} else if err != nil {
+		return tableCodec{}, false, err
+	}
+	c, ok := namedCodecs[name.String]
+	if !ok {
+		return tableCodec{}, false, fmt.Errorf("table %q has unknown codec %q", table, name.String)
+	}
+	return tableCodec{name: name.String, codec: c}, true, nil
+}
+
+// namedTableCodec returns the table codec with the given name, in any case.
+func namedTableCodec(name string) (tableCodec, error) {
+	name = strings.ToLower(name)
+	c, ok := namedCodecs[name]
+	if !ok {
+		return tableCodec{}, fmt.Errorf("unknown codec %q", name)
+	}
+	return tableCodec{name: name, codec: c}, nil
+}
+
+// recordCodec records tc as the codec of table in the schema table within tx.
+// Unless replace is true, it does so only if the table does not already have
+// a codec of its own.
+func (d *sqlDB) recordCodec(ctx context.Context, tx *sql.Tx, table string, tc tableCodec, replace bool) error {
+	cond := value.Cond(replace, "", " and codec is null")
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`update %s set codec = $codec where name = $name%s`, quoteIdent(d.schemaTable()), cond),
+		sql.Named("codec", tc.name), sql.Named("name", table))
+	return err
+}
+
+// SetCompression sets the codec used to store new values of s by name, one
+// of "snappy", "gzip", or "none" (see [GzipCodec]). The choice is recorded in
+// the database, and applies to s whenever it is opened, regardless of the
+// codec of the store (see [Options]). This allows the namespaces of one
+// database to use different codecs, for example to store media that are
+// already compressed without compressing them again. To choose the codec of
+// a namespace when it is first opened, see [Options.NamespaceCodecs].
+//
+// Values already stored in s keep the codec that encoded them, which is
+// recorded with each value, so they remain readable and SetCompression does
+// not rewrite them. To convert them to the new codec, use [KV.Recompress].
+//
+// Per-namespace codecs are not supported by a store that sets Codec to a
+// codec other than [SnappyCodec], [GzipCodec], or [NoCodec], or sets
+// EncryptionKey, since values of such a namespace would bypass the codec of
+// the store; for such a store SetCompression reports [errors.ErrUnsupported].
+func (s KV) SetCompression(ctx context.Context, name string) (err error) {
+	ctx, op := s.db.begin(ctx, "setcompression", s.tableName)
+	defer op.end(&err)
+
+	tc, err := namedTableCodec(name)
+	if err != nil {
+		return fmt.Errorf("set compression: %w", err)
+	} else if _, ok := builtinCodecID(s.db.codec); !ok {
+		return fmt.Errorf("set compression: %w", errors.ErrUnsupported)
+	}
+
+	s.db.txmu.Lock()
+	defer s.db.txmu.Unlock()
+
+	if err := withTxErr(ctx, s.db.sqlDB, func(tx *sql.Tx) error {
+		return s.db.recordCodec(ctx, tx, s.tableName, tc, true)
+	}); err != nil {
+		return fmt.Errorf("set compression: %w", err)
+	}
+	s.db.codecs[s.tableName] = tc
+	return nil
+}
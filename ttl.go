@@ -0,0 +1,141 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/creachadair/ffs/blob"
+	"github.com/creachadair/mds/value"
+)
+
+// liveRow returns an SQL condition that is true for a row of the table with
+// the given alias that has not expired. The query must bind $now to the
+// value of nowArg.
+func liveRow(alias string) string {
+	return fmt.Sprintf(`(%[1]s.expires_at is null or %[1]s.expires_at > $now)`, alias)
+}
+
+// nowArg returns the $now argument for a query using liveRow.
+func nowArg() sql.NamedArg { return sql.Named("now", time.Now().UnixNano()) }
+
+// PutTTL is as Put, but the value expires after the specified duration has
+// elapsed. An expired key is treated as absent by Get, Stat, and List, and a
+// Put without Replace may re-add it. Expired rows continue to occupy space,
+// and are counted by Len, until they are removed by [KV.PurgeExpired] or
+// replaced. If ttl == 0 the value does not expire, as with Put.
+//
+// A subsequent Put that replaces the value of key clears its expiration.
+func (s KV) PutTTL(ctx context.Context, opts blob.PutOptions, ttl time.Duration) (err error) {
+	ctx, op := s.db.begin(ctx, "putttl", s.tableName)
+	defer op.end(&err)
+	var cs []change
+	defer s.db.changed(&cs, &err) // after the lock is released
+
+	if ttl < 0 {
+		return fmt.Errorf("put: invalid TTL %v", ttl)
+	}
+
+	s.db.txmu.Lock()
+	defer s.db.txmu.Unlock()
+
+	op.setKey(opts.Key)
+	op.setSize(len(opts.Data))
+	var expires int64
+	if ttl > 0 {
+		expires = time.Now().Add(ttl).UnixNano()
+	}
+	if _, err := s.prepare(ctx, s.putQuery(opts.Replace)); err != nil {
+		return fmt.Errorf("put: %w", err)
+	}
+	var added bool
+	var evicted []string
+	if err := withTxErr(ctx, s.db.sqlDB, func(tx *sql.Tx) (err error) {
+		added, err = s.putTx(ctx, tx, opts.Key, opts.Data, opts.Replace, expires)
+		if err == nil {
+			evicted, err = s.evictTx(ctx, tx)
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+	s.db.addLen(s.tableName, value.Cond[int64](added, 1, 0)-int64(len(evicted)))
+	cs = withDeletes([]change{{"put", opts.Key}}, evicted)
+	return nil
+}
+
+// PurgeExpired deletes all the expired keys from s, and reports the number of
+// keys deleted.
+func (s KV) PurgeExpired(ctx context.Context) (_ int, err error) {
+	ctx, op := s.db.begin(ctx, "purge", s.tableName)
+	defer op.end(&err)
+	var cs []change
+	defer s.db.changed(&cs, &err) // after the lock is released
+
+	s.db.txmu.Lock()
+	defer s.db.txmu.Unlock()
+
+	var n int
+	if err := withTxErr(ctx, s.db.sqlDB, func(tx *sql.Tx) error {
+		n, cs = 0, cs[:0] // reset in case of retry
+		query := fmt.Sprintf(`delete from %s where expires_at <= $now returning key, ref`, s.table())
+		rows, err := tx.QueryContext(ctx, query, nowArg())
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		var refs [][]byte
+		for rows.Next() {
+			var ekey any
+			var ref []byte
+			if err := rows.Scan(&ekey, &ref); err != nil {
+				return err
+			}
+			key, err := s.decodeKey(storedBytes(ekey))
+			if err != nil {
+				return err
+			}
+			n++
+			cs = append(cs, change{"delete", key})
+			if ref != nil {
+				refs = append(refs, ref)
+			}
+		}
+		if err := rows.Close(); err != nil {
+			return err
+		}
+		for _, ref := range refs {
+			if err := s.db.release(ctx, tx, ref); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return 0, fmt.Errorf("purge: %w", err)
+	}
+	s.db.addLen(s.tableName, -int64(n))
+	return n, nil
+}
+
+// purgeKeyTx deletes key within tx if it is present and has expired, and
+// reports whether it did so. It does not update the cached length.
+func (s KV) purgeKeyTx(ctx context.Context, tx *sql.Tx, key string) (bool, error) {
+	query := fmt.Sprintf(`delete from %s where key = $key and expires_at <= $now returning ref`, s.table())
+	var ref []byte
+	err := tx.QueryRowContext(ctx, query, sql.Named("key", s.encodeKey(key)), nowArg()).Scan(&ref)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if ref != nil {
+		if err := s.db.release(ctx, tx, ref); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
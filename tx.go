@@ -0,0 +1,95 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/creachadair/ffs/blob"
+)
+
+// WithTx calls fn with a [KVTx] whose operations on s are performed in a
+// single transaction. If fn reports nil, the transaction is committed;
+// otherwise it is rolled back, and WithTx returns the error from fn.
+//
+// WithTx holds the write lock of the store until fn returns, so that all
+// other operations on the store wait. In particular, fn must not use s or
+// any other KV of the same store except through tx, or it will deadlock.
+// If the transaction is retried because the database is busy (see
+// [Options.BusyRetries]), fn is called again.
+func (s KV) WithTx(ctx context.Context, fn func(tx *KVTx) error) (err error) {
+	ctx, op := s.db.begin(ctx, "withtx", s.tableName)
+	defer op.end(&err)
+	var cs []change
+	defer s.db.changed(&cs, &err) // after the lock is released
+
+	s.db.txmu.Lock()
+	defer s.db.txmu.Unlock()
+
+	kt := &KVTx{s: s}
+	defer func() { kt.tx = nil }() // invalidate after return
+	if err := withTxErr(ctx, s.db.sqlDB, func(tx *sql.Tx) error {
+		kt.tx, kt.added, kt.changes = tx, 0, nil // reset in case of retry
+		if err := fn(kt); err != nil {
+			return err
+		}
+		evicted, err := s.evictTx(ctx, tx)
+		kt.added -= int64(len(evicted))
+		kt.changes = withDeletes(kt.changes, evicted)
+		return err
+	}); err != nil {
+		return err
+	}
+	s.db.addLen(s.tableName, kt.added)
+	cs = kt.changes
+	return nil
+}
+
+// A KVTx provides operations on a [KV] within a transaction.
+// See [KV.WithTx]. A KVTx is not safe for concurrent use.
+type KVTx struct {
+	s       KV
+	tx      *sql.Tx  // nil when the transaction is no longer active
+	added   int64    // net number of keys added
+	changes []change // writes to report once the transaction commits
+}
+
+var errTxDone = errors.New("transaction is no longer active")
+
+// Get returns the value of key, as [KV.Get].
+func (t *KVTx) Get(ctx context.Context, key string) ([]byte, error) {
+	if t.tx == nil {
+		return nil, errTxDone
+	}
+	return t.s.getTx(ctx, t.tx, key)
+}
+
+// Put writes a value, as [KV.Put].
+func (t *KVTx) Put(ctx context.Context, opts blob.PutOptions) error {
+	if t.tx == nil {
+		return errTxDone
+	}
+	added, err := t.s.putTx(ctx, t.tx, opts.Key, opts.Data, opts.Replace, 0)
+	if err != nil {
+		return err
+	} else if added {
+		t.added++
+	}
+	t.changes = append(t.changes, change{"put", opts.Key})
+	return nil
+}
+
+// Delete removes key, as [KV.Delete].
+func (t *KVTx) Delete(ctx context.Context, key string) error {
+	if t.tx == nil {
+		return errTxDone
+	}
+	if err := t.s.deleteTx(ctx, t.tx, key); err != nil {
+		return err
+	}
+	t.added--
+	t.changes = append(t.changes, change{"delete", key})
+	return nil
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2026 Michael J. Fromberger. All Rights Reserved.
+
+package sqlitestore
+
+import (
+	"expvar"
+	"maps"
+)
+
+// storeVars are the counters a store publishes to an [expvar.Map].
+// See [Options.Expvar].
+type storeVars struct {
+	ops     *expvar.Map // op name → count
+	errors  *expvar.Map // op name → count of failures
+	read    *expvar.Int
+	written *expvar.Int
+}
+
+// newStoreVars adds the counters for d to m, and returns them.
+func newStoreVars(m *expvar.Map, d *sqlDB) *storeVars {
+	v := &storeVars{
+		ops:     new(expvar.Map).Init(),
+		errors:  new(expvar.Map).Init(),
+		read:    new(expvar.Int),
+		written: new(expvar.Int),
+	}
+	m.Set("ops", v.ops)
+	m.Set("errors", v.errors)
+	m.Set("bytes_read", v.read)
+	m.Set("bytes_written", v.written)
+	if d.fastLen {
+		m.Set("keys", expvar.Func(func() any {
+			d.txmu.RLock()
+			defer d.txmu.RUnlock()
+			return maps.Clone(d.lens)
+		}))
+	}
+	return v
+}
+
+// observe records the completion of the named operation, which read (see
+// readOps) or wrote (otherwise) values of the given total size.
+func (v *storeVars) observe(name string, size int, err error) {
+	v.ops.Add(name, 1)
+	if err != nil {
+		v.errors.Add(name, 1)
+	} else if readOps[name] {
+		v.read.Add(int64(size))
+	} else {
+		v.written.Add(int64(size))
+	}
+}
+
+// readOps are the names of the operations whose size counts bytes read.
+var readOps = map[string]bool{
+	"get":      true,
+	"batchget": true,
+	"getraw":   true,
+}